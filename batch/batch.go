@@ -0,0 +1,211 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package batch generates the lineage graphs of a directory of whistle mappings concurrently.
+package batch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/loader"
+	"google.golang.org/protobuf/proto"
+)
+
+// Options configures a batch run. An empty output directory skips that output format.
+type Options struct {
+	InputDir    string
+	DOTDir      string
+	PNGDir      string
+	ProtobufDir string
+	// Libraries are the library projectors every mapping can call.
+	Libraries    []*mbp.ProjectorDefinition
+	GraphOptions graph.Options
+	Workers      int
+}
+
+// Result is the outcome of processing one mapping file.
+type Result struct {
+	File     string        `json:"file"`
+	NumNodes int           `json:"numNodes"`
+	NumEdges int           `json:"numEdges"`
+	Duration time.Duration `json:"durationNs"`
+	Error    string        `json:"error,omitempty"`
+	// Diagnostics lists the problems worked around in tolerant mode.
+	Diagnostics []string `json:"diagnostics,omitempty"`
+}
+
+// Report summarises a batch run. Results are sorted by file name.
+type Report struct {
+	Succeeded int      `json:"succeeded"`
+	Failed    int      `json:"failed"`
+	Results   []Result `json:"results"`
+}
+
+// Run generates the outputs of every whistle file under the input directory. The outputs mirror
+// the layout of the input directory. A file that fails is recorded in the report and doesn't stop
+// the others; only failing to find the input files is returned as an error.
+func Run(opts Options) (Report, error) {
+	files, err := loader.LibraryFiles(opts.InputDir)
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to find the whistle files in %v:\n%w", opts.InputDir, err)
+	}
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]Result, len(files))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				results[i] = processFile(files[i], opts)
+			}
+		}()
+	}
+	for i := range files {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].File < results[j].File })
+	report := Report{Results: results}
+	for _, result := range results {
+		if result.Error == "" {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+	}
+	return report, nil
+}
+
+// fromWhistle generates the graph of a mapping file; tests replace it to control the outcome.
+var fromWhistle = loader.FromWhistle
+
+// processFile generates the outputs of a mapping file. A panic while processing it is recorded as
+// its error, like any other failure, so that it doesn't take the other files down with it.
+func processFile(file string, opts Options) (result Result) {
+	start := time.Now()
+	result = Result{File: file}
+	defer func() {
+		if r := recover(); r != nil {
+			result = Result{File: file, Duration: time.Since(start), Error: fmt.Sprintf("panic: %v", r)}
+		}
+	}()
+	g, err := writeOutputs(file, opts)
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.NumNodes = len(g.Nodes)
+	for _, diagnostic := range g.Diagnostics {
+		result.Diagnostics = append(result.Diagnostics, diagnostic.String())
+	}
+	for _, adjList := range []map[int][]int{g.Edges, g.ArgumentEdges, g.ConditionEdges} {
+		for _, ancestorIDs := range adjList {
+			result.NumEdges += len(ancestorIDs)
+		}
+	}
+	return result
+}
+
+func writeOutputs(file string, opts Options) (graph.Graph, error) {
+	whistle, err := ioutil.ReadFile(file)
+	if err != nil {
+		return graph.Graph{}, fmt.Errorf("failed to read %v:\n%w", file, err)
+	}
+	g, _, err := fromWhistle(file, whistle, opts.Libraries, opts.GraphOptions)
+	if err != nil {
+		return graph.Graph{}, err
+	}
+
+	pngFile, err := outputFile(file, opts.InputDir, opts.PNGDir, ".png")
+	if err != nil {
+		return graph.Graph{}, err
+	}
+	dotString, err := graph.WriteDOTpng(g, pngFile)
+	if err != nil {
+		return graph.Graph{}, fmt.Errorf("failed to write graph to DOT:\n%w", err)
+	}
+	dotFile, err := outputFile(file, opts.InputDir, opts.DOTDir, ".dot")
+	if err != nil {
+		return graph.Graph{}, err
+	}
+	if dotFile != "" {
+		if err := ioutil.WriteFile(dotFile, []byte(dotString), 0644); err != nil {
+			return graph.Graph{}, fmt.Errorf("failed to write dot text:\n%w", err)
+		}
+	}
+
+	pbFile, err := outputFile(file, opts.InputDir, opts.ProtobufDir, ".pb")
+	if err != nil {
+		return graph.Graph{}, err
+	}
+	if pbFile != "" {
+		pbGraph, err := graph.WriteProtobuf(g)
+		if err != nil {
+			return graph.Graph{}, fmt.Errorf("failed to write graph to protobuf:\n%w", err)
+		}
+		out, err := proto.Marshal(pbGraph)
+		if err != nil {
+			return graph.Graph{}, fmt.Errorf("failed to marshal the protobuf graph:\n%w", err)
+		}
+		if err := ioutil.WriteFile(pbFile, out, 0644); err != nil {
+			return graph.Graph{}, fmt.Errorf("failed to write the protobuf graph:\n%w", err)
+		}
+	}
+	return g, nil
+}
+
+// outputFile returns the path in the output directory mirroring the input file, creating its
+// directory. It returns "" if the output directory is empty.
+func outputFile(file string, inputDir string, outputDir string, ext string) (string, error) {
+	if outputDir == "" {
+		return "", nil
+	}
+	rel, err := filepath.Rel(inputDir, file)
+	if err != nil {
+		return "", fmt.Errorf("failed to find %v relative to %v:\n%w", file, inputDir, err)
+	}
+	path := filepath.Join(outputDir, strings.TrimSuffix(rel, filepath.Ext(rel))+ext)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create the output directory for %v:\n%w", path, err)
+	}
+	return path, nil
+}
+
+// WriteReport writes the report as JSON.
+func WriteReport(report Report, path string) error {
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal the batch report:\n%w", err)
+	}
+	return loader.WriteOutput(path, out)
+}
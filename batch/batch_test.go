@@ -0,0 +1,135 @@
+package batch
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/internal/mappingtest"
+)
+
+// fakeFromWhistle reads a mapping file holding "panic", "fail" or the number of fields it maps.
+func fakeFromWhistle(fileName string, whistle []byte, libraries []*mbp.ProjectorDefinition, opts graph.Options) (graph.Graph, *graph.Source, error) {
+	content := strings.TrimSpace(string(whistle))
+	switch content {
+	case "panic":
+		panic("mapping crashed")
+	case "fail":
+		return graph.Graph{}, nil, errors.New("transpiling failed")
+	}
+	numFields, err := strconv.Atoi(content)
+	if err != nil {
+		return graph.Graph{}, nil, err
+	}
+	mpc := &mbp.MappingConfig{}
+	for i := 0; i < numFields; i++ {
+		field := fmt.Sprintf("f%v", i)
+		mpc.RootMapping = append(mpc.RootMapping, mappingtest.Mapping(field, mappingtest.FromInput("."+field)))
+	}
+	g, err := graph.NewWithOptions(mpc, opts)
+	return g, nil, err
+}
+
+func TestRun(t *testing.T) {
+	defer func(f func(string, []byte, []*mbp.ProjectorDefinition, graph.Options) (graph.Graph, *graph.Source, error)) {
+		fromWhistle = f
+	}(fromWhistle)
+	fromWhistle = fakeFromWhistle
+
+	inputDir, err := ioutil.TempDir("", "batch")
+	if err != nil {
+		t.Fatalf("failed to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(inputDir)
+	files := map[string]string{
+		"d.wstl":        "3",
+		"a.wstl":        "1",
+		"nested/b.wstl": "panic",
+		"nested/c.wstl": "fail",
+		"notes.txt":     "not a mapping",
+	}
+	for name, content := range files {
+		path := filepath.Join(inputDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create the directory of %v: %v", path, err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %v: %v", path, err)
+		}
+	}
+
+	want := Report{
+		Succeeded: 2,
+		Failed:    2,
+		Results: []Result{
+			{File: filepath.Join(inputDir, "a.wstl"), NumNodes: 2, NumEdges: 1},
+			{File: filepath.Join(inputDir, "d.wstl"), NumNodes: 6, NumEdges: 3},
+			{File: filepath.Join(inputDir, "nested/b.wstl"), Error: "panic: mapping crashed"},
+			{File: filepath.Join(inputDir, "nested/c.wstl"), Error: "transpiling failed"},
+		},
+	}
+	tests := []struct {
+		name    string
+		workers int
+	}{
+		{
+			name:    "one worker",
+			workers: 1,
+		},
+		{
+			name:    "more workers than files",
+			workers: 8,
+		},
+		{
+			name:    "unset workers",
+			workers: 0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pbDir, err := ioutil.TempDir("", "batch_pb")
+			if err != nil {
+				t.Fatalf("failed to create a temporary directory: %v", err)
+			}
+			defer os.RemoveAll(pbDir)
+
+			got, err := Run(Options{InputDir: inputDir, ProtobufDir: pbDir, Workers: test.workers})
+			if err != nil {
+				t.Fatalf("Run() returned an unexpected error: %v", err)
+			}
+			for i := range got.Results {
+				got.Results[i].Duration = 0
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("Run() returned an unexpected report (-want +got):\n%v", diff)
+			}
+
+			written := []string{}
+			filepath.Walk(pbDir, func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					rel, _ := filepath.Rel(pbDir, path)
+					written = append(written, rel)
+				}
+				return nil
+			})
+			if diff := cmp.Diff([]string{"a.pb", "d.pb"}, written); diff != "" {
+				t.Errorf("Run() wrote unexpected protobuf graphs (-want +got):\n%v", diff)
+			}
+		},
+		)
+	}
+}
+
+func TestRun_MissingInputDir(t *testing.T) {
+	if _, err := Run(Options{InputDir: filepath.Join(os.TempDir(), "batch-missing-input-dir")}); err == nil {
+		t.Errorf("Run() returned no error, want an error for a missing input directory")
+	}
+}
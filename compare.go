@@ -0,0 +1,124 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/compare"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/loader"
+)
+
+// runCompare compares the static lineage graphs of the mapping files given as arguments with the
+// lineage traced from running them on fixture inputs, and writes a report per mapping. Fixtures a
+// mapping can't be traced on are reported as skipped. With -fail_on_missed, it fails if a trace
+// has a flow the static graph doesn't.
+func runCompare(args []string) error {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	fixtures := flags.String("fixtures", "", "Comma-separated list of JSON input files and directories of them to run the mappings on.")
+	format := flags.String("format", "text", "Output format of the reports: text or json.")
+	out := flags.String("out", loader.StdioSpec, "File to write the reports to. Use - to write to stdout.")
+	libDir := flags.String("lib_dir_spec", "", "Directory of whistle library files whose projectors the mappings can call.")
+	pluginsSpec := flags.String("plugins", "", pluginsUsage)
+	failOnMissed := flags.Bool("fail_on_missed", false, "Fail if a fixture has a flow that the static graph is missing.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v compare -fixtures=inputs/ [flags] mapping.wstl...\n", flag.CommandLine.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 || *fixtures == "" {
+		flags.Usage()
+		return fmt.Errorf("no fixtures or no mapping files were given")
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown output format %v; expected text or json", *format)
+	}
+	files, err := loader.SampleFiles(*fixtures)
+	if err != nil {
+		return err
+	}
+	inputs := []interface{}{}
+	for _, file := range files {
+		input, err := loader.ReadJSON(file)
+		if err != nil {
+			return err
+		}
+		inputs = append(inputs, input)
+	}
+	plugins, err := loader.ReadPlugins(*pluginsSpec)
+	if err != nil {
+		return err
+	}
+	libraries, err := loader.LibraryProjectors(*libDir)
+	if err != nil {
+		return err
+	}
+
+	opts := graph.Options{Plugins: plugins}
+	reports := []compare.Report{}
+	missed := 0
+	for _, file := range flags.Args() {
+		whistle, err := loader.ReadMapping(file)
+		if err != nil {
+			return err
+		}
+		static, _, err := loader.FromWhistle(file, whistle, libraries, opts)
+		if err != nil {
+			return fmt.Errorf("failed to build the lineage graph of %v:\n%w", file, err)
+		}
+		traces := []graph.Graph{}
+		skipped := []compare.Skip{}
+		for i, input := range inputs {
+			trace, _, err := loader.TraceWhistle(file, whistle, libraries, input, opts)
+			var unsupported *graph.UnsupportedMessageError
+			if errors.As(err, &unsupported) {
+				skipped = append(skipped, compare.Skip{Fixture: files[i], Reason: unsupported.Error()})
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to trace %v on %v:\n%w", file, files[i], err)
+			}
+			traces = append(traces, trace)
+		}
+		report := compare.Compare(file, static, traces, skipped)
+		missed += len(report.Missed)
+		reports = append(reports, report)
+	}
+
+	var output []byte
+	if *format == "json" {
+		if output, err = json.MarshalIndent(reports, "", "  "); err != nil {
+			return fmt.Errorf("failed to marshal the reports:\n%w", err)
+		}
+	} else {
+		lines := []string{}
+		for _, report := range reports {
+			lines = append(lines, report.String()+"\n")
+		}
+		output = []byte(strings.Join(lines, ""))
+	}
+	if err := loader.WriteOutput(*out, output); err != nil {
+		return fmt.Errorf("failed to write the reports:\n%w", err)
+	}
+	if *failOnMissed && missed > 0 {
+		return fmt.Errorf("the static graphs are missing %v traced flows", missed)
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package compare compares the static lineage graph of a mapping with the instance graphs traced
+// from running it on test fixtures: which static flows no fixture exercises, and which traced
+// flows the static graph misses.
+package compare
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+)
+
+// Flow is the derivation of a target from a source, which is an input field, a constant, or a
+// projector called without arguments. Conditions guarding the target, or any node it derives
+// from, are followed too.
+type Flow struct {
+	Target   string             `json:"target"`
+	Source   string             `json:"source"`
+	Position graph.FileMetaData `json:"position"` // of the target
+}
+
+func (f Flow) String() string {
+	position := ""
+	if f.Position.LineStart > 0 {
+		position = fmt.Sprintf("%v:%v:%v: ", f.Position.FileName, f.Position.LineStart, f.Position.CharStart)
+	}
+	return fmt.Sprintf("%v%v <- %v", position, f.Target, f.Source)
+}
+
+// Skip is a fixture the mapping couldn't be traced on, because the run reached a construct the
+// mapping engine can't expose to the trace, such as a plugin without its Go function.
+type Skip struct {
+	Fixture string `json:"fixture"`
+	Reason  string `json:"reason"`
+}
+
+func (s Skip) String() string {
+	return fmt.Sprintf("%v: %v", s.Fixture, s.Reason)
+}
+
+// Report compares the flows of a static graph with those of the traces. Exercised counts the
+// static flows found in at least one trace; Untested lists the others, and Missed the traced
+// flows that aren't in the static graph, which the static graph should have found. Fixtures counts
+// the traces, leaving out the Skipped fixtures.
+type Report struct {
+	Mapping   string  `json:"mapping"`
+	Fixtures  int     `json:"fixtures"`
+	Flows     int     `json:"flows"`
+	Exercised int     `json:"exercised"`
+	Percent   float64 `json:"percent"`
+	Untested  []Flow  `json:"untested"`
+	Missed    []Flow  `json:"missed"`
+	Skipped   []Skip  `json:"skipped"`
+}
+
+func (r Report) String() string {
+	lines := []string{fmt.Sprintf("%v: %v of %v flows exercised by %v fixtures (%.1f%%)", r.Mapping, r.Exercised, r.Flows, r.Fixtures, r.Percent)}
+	if len(r.Untested) > 0 {
+		lines = append(lines, "untested flows:")
+		for _, flow := range r.Untested {
+			lines = append(lines, "    "+flow.String())
+		}
+	}
+	if len(r.Missed) > 0 {
+		lines = append(lines, "traced flows missing from the static graph:")
+		for _, flow := range r.Missed {
+			lines = append(lines, "    "+flow.String())
+		}
+	}
+	if len(r.Skipped) > 0 {
+		lines = append(lines, "fixtures that can't be traced:")
+		for _, skip := range r.Skipped {
+			lines = append(lines, "    "+skip.String())
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Compare compares the static graph of a mapping with the instance graphs traced from it by
+// graph.Trace, which runs the mapping with the mapping engine, and lists the fixtures that were
+// skipped since they couldn't be traced. For the exercised and untested flows, nodes are matched
+// by kind, context, name or value, and position, so the graphs should be annotated with the same
+// source, or both left without positions. Variables aren't targets of flows, since the flows go
+// through them.
+//
+// The missed flows are found from the output fields each trace computed from input fields, which
+// the trace tracks from the values of the run rather than from its nodes, so that a static graph
+// whose nodes are built wrong is caught too. A traced flow is missed unless the static graph
+// derives the output field, or an object containing it, from the input field or an object
+// containing it.
+func Compare(mapping string, static graph.Graph, traces []graph.Graph, skipped []Skip) Report {
+	staticFlows := flows(static)
+	tracedFlows := map[string]Flow{}
+	for _, trace := range traces {
+		for key, flow := range flows(trace) {
+			tracedFlows[key] = flow
+		}
+	}
+
+	report := Report{Mapping: mapping, Fixtures: len(traces), Flows: len(staticFlows), Untested: []Flow{}, Missed: []Flow{}, Skipped: append([]Skip{}, skipped...)}
+	for key, flow := range staticFlows {
+		if _, ok := tracedFlows[key]; ok {
+			report.Exercised++
+		} else {
+			report.Untested = append(report.Untested, flow)
+		}
+	}
+	report.Percent = 100
+	if report.Flows > 0 {
+		report.Percent = float64(report.Exercised) * 100 / float64(report.Flows)
+	}
+
+	staticData := dataFlows(static)
+	missed := map[string]bool{}
+	for _, trace := range traces {
+		positions := map[string]graph.FileMetaData{}
+		for _, path := range trace.OutputPaths() {
+			positions[outputPath(path.Path)] = graph.FileData(trace.Nodes[path.ID])
+		}
+		for output, inputs := range trace.Provenance {
+			for _, input := range inputs {
+				if covers(staticData, output, input) || missed[output+"\x00"+input] {
+					continue
+				}
+				missed[output+"\x00"+input] = true
+				report.Missed = append(report.Missed, Flow{Target: output, Source: "$root." + input, Position: positions[output]})
+			}
+		}
+	}
+	sortFlows(report.Untested)
+	sortFlows(report.Missed)
+	return report
+}
+
+// flows returns the flows of a graph by their key.
+func flows(g graph.Graph) map[string]Flow {
+	result := map[string]Flow{}
+	for id, node := range g.Nodes {
+		target, ok := node.(*graph.TargetNode)
+		if !ok || target.IsVariable {
+			continue
+		}
+		for _, ancestorID := range g.Upstream(id, true) {
+			if len(g.Edges[ancestorID]) > 0 || len(g.ArgumentEdges[ancestorID]) > 0 || len(g.ConditionEdges[ancestorID]) > 0 {
+				continue
+			}
+			ancestor := g.Nodes[ancestorID]
+			result[key(node)+"\x00"+key(ancestor)] = Flow{
+				Target:   target.Name,
+				Source:   graph.Describe(ancestor),
+				Position: graph.FileData(node),
+			}
+		}
+	}
+	return result
+}
+
+// key identifies a node across graphs of the same mapping.
+func key(node graph.Node) string {
+	data := graph.FileData(node)
+	return fmt.Sprintf("%T %v %v %v:%v", node, graph.Context(node), graph.Describe(node), data.LineStart, data.CharStart)
+}
+
+// dataFlows returns the input paths each output path of a static graph derives from. The body of
+// a projector whose value is written to an output target isn't followed from the target, since
+// the targets of the body are output fields of their own.
+func dataFlows(g graph.Graph) map[string]map[string]bool {
+	inputs := map[int][]string{}
+	for _, path := range g.InputPaths() {
+		inputs[path.ID] = append(inputs[path.ID], strings.Join(path.Path, "."))
+	}
+	type step struct {
+		id       int
+		skipBody bool
+	}
+	argumentEdges := g.LineageArgumentEdges()
+	result := map[string]map[string]bool{}
+	for _, output := range g.OutputPaths() {
+		path := outputPath(output.Path)
+		if result[path] == nil {
+			result[path] = map[string]bool{}
+		}
+		visited := map[step]bool{}
+		queue := []step{{id: output.ID}}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			if visited[current] {
+				continue
+			}
+			visited[current] = true
+			for _, input := range inputs[current.id] {
+				result[path][input] = true
+			}
+			ancestorIDs := append(append([]int{}, argumentEdges[current.id]...), g.ConditionEdges[current.id]...)
+			if !current.skipBody {
+				ancestorIDs = append(ancestorIDs, g.Edges[current.id]...)
+			}
+			target, isTarget := g.Nodes[current.id].(*graph.TargetNode)
+			for _, ancestorID := range ancestorIDs {
+				projector, isProjector := g.Nodes[ancestorID].(*graph.ProjectorNode)
+				skipBody := isTarget && !target.IsVariable && isProjector && !projector.IsBuiltin
+				queue = append(queue, step{id: ancestorID, skipBody: skipBody})
+			}
+		}
+	}
+	return result
+}
+
+// outputPath joins the fields of an output path, leaving out $this, which is the whole value.
+func outputPath(path []string) string {
+	fields := []string{}
+	for _, field := range path {
+		if field != "$this" {
+			fields = append(fields, field)
+		}
+	}
+	return strings.Join(fields, ".")
+}
+
+// covers returns whether the static data flows derive the output path, or an object containing
+// it, from the input path or an object containing it.
+func covers(static map[string]map[string]bool, output, input string) bool {
+	for _, o := range prefixes(output) {
+		for _, i := range prefixes(input) {
+			if static[o][i] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// prefixes returns a path and the paths of the objects containing it.
+func prefixes(path string) []string {
+	result := []string{path}
+	for i := strings.LastIndex(path, "."); i >= 0; i = strings.LastIndex(path, ".") {
+		path = path[:i]
+		result = append(result, path)
+	}
+	return result
+}
+
+func sortFlows(flows []Flow) {
+	sort.Slice(flows, func(i, j int) bool {
+		if flows[i].Position.LineStart != flows[j].Position.LineStart {
+			return flows[i].Position.LineStart < flows[j].Position.LineStart
+		}
+		if flows[i].Target != flows[j].Target {
+			return flows[i].Target < flows[j].Target
+		}
+		return flows[i].Source < flows[j].Source
+	})
+}
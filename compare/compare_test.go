@@ -0,0 +1,147 @@
+package compare
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/internal/mappingtest"
+)
+
+func TestCompare(t *testing.T) {
+	copyA := mappingtest.Mapping("x", mappingtest.FromInput(".a"))
+	copyB := mappingtest.Mapping("y", mappingtest.FromInput(".b"))
+	guarded := mappingtest.Guarded("big", mappingtest.ConstInt(1), &mbp.ValueSource{
+		Source:        mappingtest.FromInput(".a").Source,
+		Projector:     "$Gt",
+		AdditionalArg: []*mbp.ValueSource{mappingtest.ConstInt(5)},
+	})
+	lookup := mappingtest.Mapping("code", mappingtest.Call("LookupCode", mappingtest.FromInput(".a")))
+	tests := []struct {
+		name         string
+		static       []*mbp.FieldMapping
+		traced       []*mbp.FieldMapping // the static mappings if nil
+		plugins      []graph.Plugin
+		inputs       []interface{}
+		wantCounts   [2]int // flows and exercised flows
+		wantUntested []string
+		wantMissed   []string
+		wantSkipped  []string
+	}{
+		{
+			name:         "all exercised",
+			static:       []*mbp.FieldMapping{copyA, copyB},
+			inputs:       []interface{}{map[string]interface{}{"a": 1.0, "b": 2.0}},
+			wantCounts:   [2]int{2, 2},
+			wantUntested: []string{},
+			wantMissed:   []string{},
+		},
+		{
+			name:         "field missing from the fixtures",
+			static:       []*mbp.FieldMapping{copyA, copyB},
+			inputs:       []interface{}{map[string]interface{}{"a": 1.0}, map[string]interface{}{"a": 2.0}},
+			wantCounts:   [2]int{2, 1},
+			wantUntested: []string{"y <- $root.b"},
+			wantMissed:   []string{},
+		},
+		{
+			name:         "branch not taken",
+			static:       []*mbp.FieldMapping{guarded},
+			inputs:       []interface{}{map[string]interface{}{"a": 1.0}},
+			wantCounts:   [2]int{3, 0},
+			wantUntested: []string{"big <- $root.a", "big <- 1", "big <- 5"},
+			wantMissed:   []string{},
+		},
+		{
+			name:         "branch taken",
+			static:       []*mbp.FieldMapping{guarded},
+			inputs:       []interface{}{map[string]interface{}{"a": 1.0}, map[string]interface{}{"a": 9.0}},
+			wantCounts:   [2]int{3, 3},
+			wantUntested: []string{},
+			wantMissed:   []string{},
+		},
+		{
+			name:         "missed by the static graph",
+			static:       []*mbp.FieldMapping{copyA},
+			traced:       []*mbp.FieldMapping{copyA, copyB},
+			inputs:       []interface{}{map[string]interface{}{"a": 1.0, "b": 2.0}},
+			wantCounts:   [2]int{1, 1},
+			wantUntested: []string{},
+			wantMissed:   []string{"y <- $root.b"},
+		},
+		{
+			name:         "plugin without its Go function",
+			static:       []*mbp.FieldMapping{copyA, lookup},
+			plugins:      []graph.Plugin{{Name: "LookupCode", NumArgs: 1}},
+			inputs:       []interface{}{map[string]interface{}{"a": 1.0}},
+			wantCounts:   [2]int{2, 0},
+			wantUntested: []string{"code <- $root.a", "x <- $root.a"},
+			wantMissed:   []string{},
+			wantSkipped:  []string{"fixture 0: tracing plugin LookupCode without its Go function is not supported in root"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := graph.Options{Plugins: test.plugins}
+			static, err := graph.NewWithOptions(&mbp.MappingConfig{RootMapping: test.static}, opts)
+			if err != nil {
+				t.Fatalf("building the graph failed:\n%v", err)
+			}
+			traced := test.traced
+			if traced == nil {
+				traced = test.static
+			}
+			traces := []graph.Graph{}
+			skipped := []Skip{}
+			for i, input := range test.inputs {
+				trace, _, err := graph.Trace(&mbp.MappingConfig{RootMapping: traced}, input, opts)
+				var unsupported *graph.UnsupportedMessageError
+				if errors.As(err, &unsupported) {
+					skipped = append(skipped, Skip{Fixture: fmt.Sprintf("fixture %v", i), Reason: unsupported.Error()})
+					continue
+				}
+				if err != nil {
+					t.Fatalf("tracing the mapping failed:\n%v", err)
+				}
+				traces = append(traces, trace)
+			}
+
+			report := Compare("test.wstl", static, traces, skipped)
+			if got := [2]int{report.Flows, report.Exercised}; got != test.wantCounts {
+				t.Errorf("Compare() counted %v flows and exercised flows, want %v", got, test.wantCounts)
+			}
+			if want := len(test.inputs) - len(test.wantSkipped); report.Fixtures != want {
+				t.Errorf("Compare() counted %v fixtures, want %v", report.Fixtures, want)
+			}
+			if diff := cmp.Diff(test.wantUntested, flowStrings(report.Untested)); diff != "" {
+				t.Errorf("unexpected untested flows (-want +got):\n%v", diff)
+			}
+			if diff := cmp.Diff(test.wantMissed, flowStrings(report.Missed)); diff != "" {
+				t.Errorf("unexpected missed flows (-want +got):\n%v", diff)
+			}
+			wantSkipped := test.wantSkipped
+			if wantSkipped == nil {
+				wantSkipped = []string{}
+			}
+			gotSkipped := []string{}
+			for _, skip := range report.Skipped {
+				gotSkipped = append(gotSkipped, skip.String())
+			}
+			if diff := cmp.Diff(wantSkipped, gotSkipped); diff != "" {
+				t.Errorf("unexpected skipped fixtures (-want +got):\n%v", diff)
+			}
+		},
+		)
+	}
+}
+
+func flowStrings(flows []Flow) []string {
+	strs := []string{}
+	for _, flow := range flows {
+		strs = append(strs, flow.String())
+	}
+	return strs
+}
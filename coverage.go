@@ -0,0 +1,103 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/coverage"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/loader"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/schema"
+)
+
+// runCoverage reports how much of the input and output schemas the mapping files given as
+// arguments cover.
+func runCoverage(args []string) error {
+	flags := flag.NewFlagSet("coverage", flag.ExitOnError)
+	inputSpec := flags.String("input_schema", "", "JSON Schema file, or file or directory of FHIR StructureDefinitions, describing the input.")
+	inputResource := flags.String("input_resource_type", "", "Type of the FHIR resource the mappings read, such as Patient, if -input_schema defines more than one.")
+	outputSpec := flags.String("output_schema", "", "JSON Schema file, or file or directory of FHIR StructureDefinitions, describing the output.")
+	format := flags.String("format", "html", "Output format of the report: html or json.")
+	out := flags.String("out", loader.StdioSpec, "File to write the report to. Use - to write to stdout.")
+	libDir := flags.String("lib_dir_spec", "", "Directory of whistle library files whose projectors the mappings can call.")
+	pluginsSpec := flags.String("plugins", "", pluginsUsage)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v coverage [flags] mapping.wstl...\n", flag.CommandLine.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no mapping files were given")
+	}
+	if *inputSpec == "" && *outputSpec == "" {
+		return fmt.Errorf("neither -input_schema nor -output_schema was given")
+	}
+	if *format != "html" && *format != "json" {
+		return fmt.Errorf("unknown output format %v; expected html or json", *format)
+	}
+
+	var input, output coverage.Schema
+	if *inputSpec != "" {
+		s, err := schema.LoadInput(*inputSpec, *inputResource)
+		if err != nil {
+			return fmt.Errorf("failed to load the input schema:\n%w", err)
+		}
+		input = s
+	}
+	if *outputSpec != "" {
+		s, err := schema.Load(*outputSpec)
+		if err != nil {
+			return fmt.Errorf("failed to load the output schema:\n%w", err)
+		}
+		output = s
+	}
+	plugins, err := loader.ReadPlugins(*pluginsSpec)
+	if err != nil {
+		return err
+	}
+	libraries, err := loader.LibraryProjectors(*libDir)
+	if err != nil {
+		return err
+	}
+	reports := []coverage.Report{}
+	for _, file := range flags.Args() {
+		whistle, err := loader.ReadMapping(file)
+		if err != nil {
+			return err
+		}
+		g, _, err := loader.FromWhistle(file, whistle, libraries, graph.Options{Tolerant: true, Plugins: plugins})
+		if err != nil {
+			return fmt.Errorf("failed to build the lineage graph of %v:\n%w", file, err)
+		}
+		reports = append(reports, coverage.New(file, g, input, output))
+	}
+
+	var report []byte
+	if *format == "html" {
+		report, err = coverage.HTML(reports)
+	} else {
+		report, err = coverage.JSON(reports)
+	}
+	if err != nil {
+		return err
+	}
+	if err := loader.WriteOutput(*out, report); err != nil {
+		return fmt.Errorf("failed to write the coverage report:\n%w", err)
+	}
+	return nil
+}
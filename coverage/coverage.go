@@ -0,0 +1,117 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package coverage reports how much of an input and an output schema a mapping covers: which
+// source fields it reads and which target elements it writes.
+package coverage
+
+import (
+	"strings"
+
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+)
+
+// Schema is a schema whose elements can be listed, such as those loaded by the schema package.
+type Schema interface {
+	graph.Schema
+	Elements() []graph.SchemaElement
+}
+
+// Element is a schema element in a report.
+type Element struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Min  int    `json:"min"`
+	Max  string `json:"max"`
+}
+
+// Coverage counts the elements of a schema covered by a mapping, and lists the others.
+type Coverage struct {
+	Elements  int       `json:"elements"`
+	Covered   int       `json:"covered"`
+	Percent   float64   `json:"percent"`
+	Uncovered []Element `json:"uncovered"`
+}
+
+// Report is the coverage of the schemas given. Source counts the input fields read; Target the
+// output elements written, and RequiredTarget the output elements with a minimum cardinality of
+// at least one.
+type Report struct {
+	Mapping        string    `json:"mapping"`
+	Source         *Coverage `json:"source,omitempty"`
+	Target         *Coverage `json:"target,omitempty"`
+	RequiredTarget *Coverage `json:"requiredTarget,omitempty"`
+}
+
+// New computes the coverage of the schemas by a graph. Either schema may be nil, in which case
+// its part of the report is left out. A source field counts as read if the mapping reads it or
+// one of its fields, through a $root field or an argument bound to one. A target element counts
+// as written if the mapping writes it or one of its fields.
+func New(mapping string, g graph.Graph, input, output Schema) Report {
+	report := Report{Mapping: mapping}
+	if input != nil {
+		report.Source = measure(input.Elements(), g.InputPaths(), func(graph.SchemaElement) bool { return true })
+	}
+	if output != nil {
+		elements := output.Elements()
+		paths := g.OutputPaths()
+		report.Target = measure(elements, paths, func(graph.SchemaElement) bool { return true })
+		report.RequiredTarget = measure(elements, paths, func(e graph.SchemaElement) bool { return e.Min > 0 })
+	}
+	return report
+}
+
+func measure(elements []graph.SchemaElement, paths []graph.FieldPath, include func(graph.SchemaElement) bool) *Coverage {
+	c := &Coverage{Uncovered: []Element{}}
+	for _, element := range elements {
+		if !include(element) {
+			continue
+		}
+		c.Elements++
+		if covered(element.Path, paths) {
+			c.Covered++
+			continue
+		}
+		c.Uncovered = append(c.Uncovered, Element{Path: element.Path, Type: element.Type, Min: element.Min, Max: element.Max})
+	}
+	c.Percent = 100
+	if c.Elements > 0 {
+		c.Percent = float64(c.Covered) * 100 / float64(c.Elements)
+	}
+	return c
+}
+
+// covered returns whether one of the paths is the element's path or starts with it. A choice of
+// types such as value[x] in the element's path matches a field named after one of the types, such
+// as valueString.
+func covered(elementPath string, paths []graph.FieldPath) bool {
+	fields := strings.Split(elementPath, ".")
+	for _, path := range paths {
+		if len(path.Path) < len(fields) {
+			continue
+		}
+		match := true
+		for i, field := range fields {
+			if choice := strings.TrimSuffix(field, "[x]"); choice != field {
+				match = match && strings.HasPrefix(path.Path[i], choice)
+			} else {
+				match = match && path.Path[i] == field
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package coverage
+
+import (
+	"strings"
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/internal/mappingtest"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/schema"
+)
+
+// fakeSchema lists its elements, in path order.
+type fakeSchema []graph.SchemaElement
+
+func (s fakeSchema) Lookup(path []string) (graph.SchemaElement, bool) {
+	for _, element := range s {
+		if element.Path == strings.Join(path, ".") {
+			return element, true
+		}
+	}
+	return graph.SchemaElement{}, false
+}
+
+func (s fakeSchema) Elements() []graph.SchemaElement {
+	return s
+}
+
+func TestNew(t *testing.T) {
+	input := fakeSchema{
+		{Path: "patient", Type: "object", Min: 1, Max: "1"},
+		{Path: "patient.name", Type: "string", Min: 0, Max: "1"},
+		{Path: "patient.ssn", Type: "string", Min: 0, Max: "1"},
+		{Path: "status", Type: "string", Min: 0, Max: "1"},
+	}
+	output := fakeSchema{
+		{Path: "Patient.deceased[x]", Type: "boolean|dateTime", Min: 0, Max: "1"},
+		{Path: "Patient.gender", Type: "code", Min: 1, Max: "1"},
+		{Path: "Patient.name", Type: "HumanName", Min: 1, Max: "*"},
+		{Path: "Patient.name.family", Type: "string", Min: 0, Max: "1"},
+	}
+	mpc := &mbp.MappingConfig{
+		Projector: []*mbp.ProjectorDefinition{{
+			Name: "PatientP",
+			Mapping: []*mbp.FieldMapping{
+				mappingtest.Mapping("name.family", mappingtest.FromInput(".name")),
+				mappingtest.Mapping("deceasedBoolean", &mbp.ValueSource{Source: &mbp.ValueSource_ConstBool{ConstBool: false}}),
+			},
+		}},
+		RootMapping: []*mbp.FieldMapping{
+			mappingtest.Mapping("Patient", &mbp.ValueSource{Source: mappingtest.FromInput(".patient").Source, Projector: "PatientP"}),
+		},
+	}
+	g, err := graph.New(mpc)
+	if err != nil {
+		t.Fatalf("building the graph failed:\n%v", err)
+	}
+	want := Report{
+		Mapping: "test.wstl",
+		Source: &Coverage{
+			Elements: 4,
+			Covered:  2,
+			Percent:  50,
+			Uncovered: []Element{
+				{Path: "patient.ssn", Type: "string", Min: 0, Max: "1"},
+				{Path: "status", Type: "string", Min: 0, Max: "1"},
+			},
+		},
+		Target: &Coverage{
+			Elements:  4,
+			Covered:   3,
+			Percent:   75,
+			Uncovered: []Element{{Path: "Patient.gender", Type: "code", Min: 1, Max: "1"}},
+		},
+		RequiredTarget: &Coverage{
+			Elements:  2,
+			Covered:   1,
+			Percent:   50,
+			Uncovered: []Element{{Path: "Patient.gender", Type: "code", Min: 1, Max: "1"}},
+		},
+	}
+	got := New("test.wstl", g, input, output)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected report (-want +got):\n%v", diff)
+	}
+	if _, err := HTML([]Report{got}); err != nil {
+		t.Errorf("writing the report as HTML failed:\n%v", err)
+	}
+}
+
+func TestNew_FHIRInput(t *testing.T) {
+	definitions, err := schema.ParseFHIR([]byte(`{
+  "resourceType": "StructureDefinition",
+  "type": "Patient",
+  "kind": "resource",
+  "snapshot": {"element": [
+    {"path": "Patient", "min": 0, "max": "*"},
+    {"path": "Patient.gender", "min": 0, "max": "1", "type": [{"code": "code"}]},
+    {"path": "Patient.birthDate", "min": 0, "max": "1", "type": [{"code": "date"}]}
+  ]}
+}`))
+	if err != nil {
+		t.Fatalf("parsing the definitions failed:\n%v", err)
+	}
+	input, err := definitions.Resource("Patient")
+	if err != nil {
+		t.Fatalf("finding the Patient resource failed:\n%v", err)
+	}
+	// Patient.gender: $root.gender
+	mpc := &mbp.MappingConfig{RootMapping: []*mbp.FieldMapping{
+		mappingtest.Mapping("Patient.gender", mappingtest.FromInput(".gender")),
+	}}
+	g, err := graph.New(mpc)
+	if err != nil {
+		t.Fatalf("building the graph failed:\n%v", err)
+	}
+	want := Report{
+		Mapping: "test.wstl",
+		Source: &Coverage{
+			Elements:  2,
+			Covered:   1,
+			Percent:   50,
+			Uncovered: []Element{{Path: "birthDate", Type: "date", Min: 0, Max: "1"}},
+		},
+		Target: &Coverage{
+			Elements:  2,
+			Covered:   1,
+			Percent:   50,
+			Uncovered: []Element{{Path: "Patient.birthDate", Type: "date", Min: 0, Max: "1"}},
+		},
+		RequiredTarget: &Coverage{Percent: 100, Uncovered: []Element{}},
+	}
+	if diff := cmp.Diff(want, New("test.wstl", g, input, definitions)); diff != "" {
+		t.Errorf("unexpected report (-want +got):\n%v", diff)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coverage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+)
+
+// JSON formats the reports as a JSON array.
+func JSON(reports []Report) ([]byte, error) {
+	out, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the coverage reports:\n%w", err)
+	}
+	return out, nil
+}
+
+type htmlSection struct {
+	Title    string
+	Coverage *Coverage
+}
+
+var htmlTemplate = template.Must(template.New("coverage").Funcs(template.FuncMap{
+	"section": func(title string, c *Coverage) htmlSection { return htmlSection{Title: title, Coverage: c} },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Mapping coverage</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+.bar { background: #eee; width: 20em; height: 1em; }
+.bar div { background: #4a4; height: 100%; }
+</style>
+</head>
+<body>
+<h1>Mapping coverage</h1>
+{{range .}}
+<h2>{{.Mapping}}</h2>
+{{with .RequiredTarget}}{{template "section" section "Required target elements written" .}}{{end}}
+{{with .Target}}{{template "section" section "Target elements written" .}}{{end}}
+{{with .Source}}{{template "section" section "Source fields read" .}}{{end}}
+{{end}}
+</body>
+</html>
+{{define "section"}}
+<h3>{{.Title}}: {{.Coverage.Covered}} of {{.Coverage.Elements}} ({{printf "%.1f" .Coverage.Percent}}%)</h3>
+<div class="bar"><div style="width: {{printf "%.1f" .Coverage.Percent}}%"></div></div>
+{{if .Coverage.Uncovered}}
+<table>
+<tr><th>Not covered</th><th>Type</th><th>Cardinality</th></tr>
+{{range .Coverage.Uncovered}}<tr><td>{{.Path}}</td><td>{{.Type}}</td><td>{{.Min}}..{{.Max}}</td></tr>
+{{end}}
+</table>
+{{end}}
+{{end}}
+`))
+
+// HTML formats the reports as a standalone HTML page.
+func HTML(reports []Report) ([]byte, error) {
+	var b bytes.Buffer
+	if err := htmlTemplate.Execute(&b, reports); err != nil {
+		return nil, fmt.Errorf("failed to write the coverage reports as HTML:\n%w", err)
+	}
+	return b.Bytes(), nil
+}
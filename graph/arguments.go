@@ -0,0 +1,90 @@
+package graph
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+)
+
+// paramName returns the declared name of an argument of the projector the env is in, or "" if it
+// isn't known. Index is 1-based.
+func (e *env) paramName(index int) string {
+	if e == nil {
+		return ""
+	}
+	if params := e.params[e.name]; index > 0 && index <= len(params) {
+		return params[index-1]
+	}
+	return ""
+}
+
+// binding returns the text of the call-site argument bound to an argument of the projector the env
+// is in, or "" if it isn't bound. Index is 1-based.
+func (e *env) binding(index int) string {
+	if e == nil || index < 1 || index > len(e.bindings) {
+		return ""
+	}
+	return e.bindings[index-1]
+}
+
+// argumentTexts renders each argument of a projector call in the env as text.
+func (e *env) argumentTexts(call *mbp.ValueSource) []string {
+	if call.GetSource() == nil {
+		return nil
+	}
+	texts := []string{e.expression(&mbp.ValueSource{Source: call.GetSource()})}
+	for _, arg := range call.GetAdditionalArg() {
+		texts = append(texts, e.expression(arg))
+	}
+	return texts
+}
+
+// expression renders a value source in the env as whistle-like text, such as $root.subject or
+// $StrCat(patient.name, "x"). The arguments of the env's projector are named by their declared
+// names, or else by their index.
+func (e *env) expression(source *mbp.ValueSource) string {
+	text := ""
+	switch s := source.GetSource().(type) {
+	case nil:
+	case *mbp.ValueSource_ConstBool:
+		text = fmt.Sprintf("%v", s.ConstBool)
+	case *mbp.ValueSource_ConstInt:
+		text = fmt.Sprintf("%v", s.ConstInt)
+	case *mbp.ValueSource_ConstFloat:
+		text = fmt.Sprintf("%v", s.ConstFloat)
+	case *mbp.ValueSource_ConstString:
+		text = strconv.Quote(s.ConstString)
+	case *mbp.ValueSource_FromInput:
+		index := int(s.FromInput.GetArg())
+		switch name := e.paramName(index); {
+		case index-1 == len(e.args):
+			text = "$root" + s.FromInput.GetField()
+		case name != "":
+			text = name + s.FromInput.GetField()
+		default:
+			text = fmt.Sprintf("arg %v%v", index, s.FromInput.GetField())
+		}
+	case *mbp.ValueSource_FromLocalVar:
+		text = s.FromLocalVar
+	case *mbp.ValueSource_FromDestination:
+		text = "dest " + s.FromDestination
+	case *mbp.ValueSource_ProjectedValue:
+		text = e.expression(s.ProjectedValue)
+	default:
+		text = messageType(s)
+	}
+	projector := source.GetProjector()
+	if projector == "" {
+		return text
+	}
+	args := []string{}
+	if text != "" {
+		args = append(args, text)
+	}
+	for _, arg := range source.GetAdditionalArg() {
+		args = append(args, e.expression(arg))
+	}
+	return projector + "(" + strings.Join(args, ", ") + ")"
+}
@@ -0,0 +1,98 @@
+package graph
+
+import (
+	"sort"
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestArgumentBindings(t *testing.T) {
+	foo := makeProjDefMsg("foo", []*mbp.FieldMapping{makeMappingMsg("id", makeArgMsg(1, ".id"), nil)})
+	bar := makeProjDefMsg("bar", []*mbp.FieldMapping{makeMappingMsg("y", makeProjSourceMsg("foo", makeArgMsg(1, ".subject"), nil), nil)})
+	pair := makeProjDefMsg("pair", []*mbp.FieldMapping{
+		makeMappingMsg("a", makeArgMsg(1, ""), nil),
+		makeMappingMsg("b", makeArgMsg(2, ""), nil),
+	})
+	params := map[string][]string{"foo": {"patient"}, "bar": {"p"}, "pair": {"left", "right"}}
+	tests := []struct {
+		name      string
+		mappings  []*mbp.FieldMapping
+		params    map[string][]string
+		want      []string // the descriptions of the argument nodes
+		wantSlots []int    // the slots of the argument edges of the first call
+	}{
+		{
+			name:      "named parameter",
+			mappings:  []*mbp.FieldMapping{makeMappingMsg("x", makeProjSourceMsg("foo", makeArgMsg(1, ".subject"), nil), nil)},
+			params:    params,
+			want:      []string{"foo.patient.id ← $root.subject"},
+			wantSlots: []int{1},
+		},
+		{
+			name:      "parameter without a name",
+			mappings:  []*mbp.FieldMapping{makeMappingMsg("x", makeProjSourceMsg("foo", makeArgMsg(1, ".subject"), nil), nil)},
+			want:      []string{"arg 1.id of foo ← $root.subject"},
+			wantSlots: []int{1},
+		},
+		{
+			name:      "bound to a parameter of the caller",
+			mappings:  []*mbp.FieldMapping{makeMappingMsg("x", makeProjSourceMsg("bar", makeArgMsg(1, ""), nil), nil)},
+			params:    params,
+			want:      []string{"bar.p.subject ← $root", "foo.patient.id ← p.subject"},
+			wantSlots: []int{1},
+		},
+		{
+			name: "bound to a projector call",
+			mappings: []*mbp.FieldMapping{
+				makeMappingMsg("x", makeProjectedSourceMsg(makeProjSourceMsg("$StrCat", makeArgMsg(1, ".a"), []*mbp.ValueSource{makeStringMsg("b")}), "pair", []*mbp.ValueSource{makeStringMsg("c")}), nil),
+			},
+			params:    params,
+			want:      []string{`pair.left ← $StrCat($root.a, "b")`, `pair.right ← "c"`},
+			wantSlots: []int{1, 2},
+		},
+		{
+			name: "call-site slots",
+			mappings: []*mbp.FieldMapping{
+				makeVarMappingMsg("v", makeIntMsg(1), nil),
+				makeVarMappingMsg("v", makeIntMsg(2), nil),
+				makeMappingMsg("x", makeProjSourceMsg("pair", makeLocalVarMsg("v"), []*mbp.ValueSource{makeArgMsg(1, ".b")}), nil),
+			},
+			params:    params,
+			want:      []string{"pair.left ← v", "pair.right ← $root.b"},
+			wantSlots: []int{1, 1, 2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := NewWithOptions(makeMappingConfigMsg([]*mbp.ProjectorDefinition{foo, bar, pair}, test.mappings), Options{Params: test.params})
+			if err != nil {
+				t.Fatalf("building graph failed:\n%v", err)
+			}
+			got := []string{}
+			calls := []int{}
+			for id, node := range g.Nodes {
+				switch n := node.(type) {
+				case *ArgumentNode:
+					got = append(got, DescribeArgument(n))
+				case *ProjectorNode:
+					if n.Context == rootContext && !n.IsBuiltin {
+						calls = append(calls, id)
+					}
+				}
+			}
+			sort.Strings(got)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("unexpected arguments (-want +got):\n%v", diff)
+			}
+			if len(calls) != 1 {
+				t.Fatalf("expected one call in the root mappings, got %v", len(calls))
+			}
+			if diff := cmp.Diff(test.wantSlots, g.ArgumentSlots[calls[0]]); diff != "" {
+				t.Errorf("unexpected argument slots (-want +got):\n%v", diff)
+			}
+		},
+		)
+	}
+}
@@ -0,0 +1,162 @@
+package graph
+
+import (
+	"sort"
+)
+
+// BuiltinSemantics classifies how the value of a builtin projector relates to its arguments.
+type BuiltinSemantics string
+
+const (
+	// PassThrough builtins return the elements or fields of their arguments unchanged, such as
+	// $Flatten and $ListCat, so the lineage of each element is kept.
+	PassThrough BuiltinSemantics = "pass-through"
+	// Combining builtins compute a new value from their arguments, such as $StrCat and $Hash.
+	Combining BuiltinSemantics = "combining"
+	// Filtering builtins return some of the elements or a part of their arguments, such as $Unique
+	// and $SubStr.
+	Filtering BuiltinSemantics = "filtering"
+	// Generating builtins return data that isn't derived from their arguments, such as $UUID and
+	// $CurrentTime, so their value has no input lineage.
+	Generating BuiltinSemantics = "generating"
+	// SideEffecting builtins have effects beyond returning a value, such as writing to a service.
+	// None of the mapping engine's builtins do; custom Go builtins that do are classified with
+	// Options.BuiltinSemantics.
+	SideEffecting BuiltinSemantics = "side-effecting"
+	// Discarding builtins return null whatever their arguments, such as $Void, so their value has
+	// no lineage. Their arguments are only evaluated.
+	Discarding BuiltinSemantics = "discarding"
+)
+
+// builtinSemantics classifies every builtin of the mapping engine.
+var builtinSemantics = map[string]BuiltinSemantics{
+	"$Flatten":      PassThrough,
+	"$ListCat":      PassThrough,
+	"$ListOf":       PassThrough,
+	"$MergeJSON":    PassThrough,
+	"$UnnestArrays": PassThrough,
+
+	"$SortAndTakeTop": Filtering,
+	"$StrSplit":       Filtering,
+	"$SubStr":         Filtering,
+	"$UnionBy":        Filtering,
+	"$Unique":         Filtering,
+
+	"$CurrentTime": Generating,
+	"$UUID":        Generating,
+
+	"$Void": Discarding,
+
+	// arithmetic
+	"$Div": Combining,
+	"$Mod": Combining,
+	"$Mul": Combining,
+	"$Sub": Combining,
+	"$Sum": Combining,
+	// collections
+	"$ListLen": Combining,
+	"$Range":   Combining,
+	// dates and times
+	"$MultiFormatParseTime": Combining,
+	"$ParseTime":            Combining,
+	"$ParseUnixTime":        Combining,
+	"$ReformatTime":         Combining,
+	"$SplitTime":            Combining,
+	// data
+	"$DebugString": Combining,
+	"$Hash":        Combining,
+	"$IntHash":     Combining,
+	"$IsNil":       Combining,
+	"$IsNotNil":    Combining,
+	// logic
+	"$And":  Combining,
+	"$Eq":   Combining,
+	"$Gt":   Combining,
+	"$GtEq": Combining,
+	"$Lt":   Combining,
+	"$LtEq": Combining,
+	"$NEq":  Combining,
+	"$Not":  Combining,
+	"$Or":   Combining,
+	// harmonization
+	"$HarmonizeCode":         Combining,
+	"$HarmonizeCodeBySearch": Combining,
+	// strings
+	"$Concat":       Combining,
+	"$MatchesRegex": Combining,
+	"$ParseFloat":   Combining,
+	"$ParseInt":     Combining,
+	"$StrCat":       Combining,
+	"$StrFmt":       Combining,
+	"$StrJoin":      Combining,
+	"$ToLower":      Combining,
+	"$ToUpper":      Combining,
+}
+
+// SemanticsOf returns the semantics of a builtin of the mapping engine. Other builtins, such as
+// custom Go builtins not classified with Options.BuiltinSemantics, are Combining, since their value
+// may derive from all of their arguments.
+func SemanticsOf(name string) BuiltinSemantics {
+	if semantics, ok := builtinSemantics[name]; ok {
+		return semantics
+	}
+	return Combining
+}
+
+// semanticsOf returns the semantics of a builtin, as classified by the graph's options or else by
+// SemanticsOf.
+func (e *env) semanticsOf(name string) BuiltinSemantics {
+	if e != nil {
+		if semantics, ok := e.semantics[name]; ok {
+			return semantics
+		}
+	}
+	return SemanticsOf(name)
+}
+
+// keepsElements returns whether a node is a call to a builtin whose value is made of the elements
+// or fields of its arguments, so the targets of the arguments are targets of its value too.
+func keepsElements(node Node) bool {
+	p, ok := node.(*ProjectorNode)
+	if !ok || !p.IsBuiltin {
+		return false
+	}
+	return p.Semantics == PassThrough || p.Semantics == Filtering
+}
+
+// discardsArguments returns whether a node is a call to a Generating or Discarding builtin, whose
+// value isn't derived from its arguments, so lineage stops at the call.
+func discardsArguments(node Node) bool {
+	p, ok := node.(*ProjectorNode)
+	return ok && p.IsBuiltin && (p.Semantics == Generating || p.Semantics == Discarding)
+}
+
+// LineageArgumentEdges returns the ArgumentEdges the value of each call derives from: those of
+// every call but the Generating and Discarding builtins, whose arguments are only evaluated.
+func (g Graph) LineageArgumentEdges() map[int][]int {
+	edges := map[int][]int{}
+	for id, argIDs := range g.ArgumentEdges {
+		if !discardsArguments(g.Nodes[id]) {
+			edges[id] = argIDs
+		}
+	}
+	return edges
+}
+
+// BuiltinCalls returns the IDs of the calls to builtins of the given semantics that a node derives
+// from, following Edges and ArgumentEdges, or conditions too if withConditions is true. For
+// example, the Generating calls of an output are where its data doesn't come from the input.
+func (g Graph) BuiltinCalls(id int, withConditions bool, semantics ...BuiltinSemantics) []int {
+	wanted := map[BuiltinSemantics]bool{}
+	for _, s := range semantics {
+		wanted[s] = true
+	}
+	calls := []int{}
+	for _, ancestorID := range append(g.Upstream(id, withConditions), id) {
+		if p, ok := g.Nodes[ancestorID].(*ProjectorNode); ok && p.IsBuiltin && wanted[p.Semantics] {
+			calls = append(calls, ancestorID)
+		}
+	}
+	sort.Ints(calls)
+	return calls
+}
@@ -0,0 +1,148 @@
+package graph
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/builtins"
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSemanticsOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		builtin string
+		want    BuiltinSemantics
+	}{
+		{
+			name:    "pass-through",
+			builtin: "$Flatten",
+			want:    PassThrough,
+		},
+		{
+			name:    "generating",
+			builtin: "$UUID",
+			want:    Generating,
+		},
+		{
+			name:    "combining",
+			builtin: "$StrCat",
+			want:    Combining,
+		},
+		{
+			name:    "discarding",
+			builtin: "$Void",
+			want:    Discarding,
+		},
+		{
+			name:    "custom builtin",
+			builtin: "$CustomLookup",
+			want:    Combining,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := SemanticsOf(test.builtin); got != test.want {
+				t.Errorf("SemanticsOf(%v) = %v, want %v", test.builtin, got, test.want)
+			}
+		},
+		)
+	}
+}
+
+func TestSemanticsOf_EveryBuiltin(t *testing.T) {
+	for name := range builtins.BuiltinFunctions {
+		if _, ok := builtinSemantics[name]; !ok {
+			t.Errorf("the builtin %v isn't classified in builtinSemantics", name)
+		}
+	}
+	for name := range harmonizationTables {
+		if _, ok := builtinSemantics[name]; !ok {
+			t.Errorf("the harmonization builtin %v isn't classified in builtinSemantics", name)
+		}
+	}
+}
+
+func TestBuiltinSemantics_Lineage(t *testing.T) {
+	proj1 := makeProjDefMsg("proj1", []*mbp.FieldMapping{makeMappingMsg("y", makeArgMsg(1, ".a"), nil)})
+	tests := []struct {
+		name          string
+		mappings      []*mbp.FieldMapping
+		semantics     map[string]BuiltinSemantics
+		path          string
+		wantTargets   []string // the targets found for the path, as context.name
+		wantGenerated []string // the generating builtins the path derives from
+	}{
+		{
+			name: "element lineage kept through $Flatten",
+			mappings: []*mbp.FieldMapping{
+				makeMappingMsg("x", makeProjSourceMsg("$Flatten", makeProjValMsg("proj1"), nil), nil),
+				makeMappingMsg("z", makeDestValSourceMsg("x.y"), nil),
+			},
+			path:          "x.y",
+			wantTargets:   []string{"proj1.y"},
+			wantGenerated: []string{},
+		},
+		{
+			name: "element lineage lost through $StrCat",
+			mappings: []*mbp.FieldMapping{
+				makeMappingMsg("x", makeProjSourceMsg("$StrCat", makeProjValMsg("proj1"), nil), nil),
+			},
+			path:          "x.y",
+			wantTargets:   []string{},
+			wantGenerated: []string{},
+		},
+		{
+			name: "element lineage lost through $Void",
+			mappings: []*mbp.FieldMapping{
+				makeMappingMsg("x", makeProjSourceMsg("$Void", makeProjValMsg("proj1"), nil), nil),
+			},
+			path:          "x.y",
+			wantTargets:   []string{},
+			wantGenerated: []string{},
+		},
+		{
+			name: "element lineage kept through a builtin classified by the options",
+			mappings: []*mbp.FieldMapping{
+				makeMappingMsg("x", makeProjSourceMsg("$StrCat", makeProjValMsg("proj1"), nil), nil),
+			},
+			semantics:     map[string]BuiltinSemantics{"$StrCat": PassThrough},
+			path:          "x.y",
+			wantTargets:   []string{"proj1.y"},
+			wantGenerated: []string{},
+		},
+		{
+			name: "generated data",
+			mappings: []*mbp.FieldMapping{
+				makeMappingMsg("x", makeProjSourceMsg("$StrCat", makeProjValMsg("$UUID"), []*mbp.ValueSource{makeArgMsg(1, ".a")}), nil),
+			},
+			path:          "x",
+			wantTargets:   []string{"root.x"},
+			wantGenerated: []string{"$UUID"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := NewWithOptions(makeMappingConfigMsg([]*mbp.ProjectorDefinition{proj1}, test.mappings), Options{BuiltinSemantics: test.semantics})
+			if err != nil {
+				t.Fatalf("building graph failed:\n%v", err)
+			}
+			targets := []string{}
+			generated := []string{}
+			for _, id := range g.FindTargets(test.path) {
+				target := g.Nodes[id].(*TargetNode)
+				targets = append(targets, target.Context+"."+target.Name)
+				for _, callID := range g.BuiltinCalls(id, false, Generating) {
+					generated = append(generated, g.Nodes[callID].(*ProjectorNode).Name)
+				}
+			}
+			if diff := cmp.Diff(test.wantTargets, targets); diff != "" {
+				t.Errorf("unexpected targets (-want +got):\n%v", diff)
+			}
+			if diff := cmp.Diff(test.wantGenerated, generated); diff != "" {
+				t.Errorf("unexpected generating builtins (-want +got):\n%v", diff)
+			}
+		},
+		)
+	}
+}
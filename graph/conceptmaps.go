@@ -0,0 +1,206 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+)
+
+// harmonizationTables gives the 1-based index of the argument naming the concept map each
+// harmonization builtin of the mapping engine looks codes up in. The engine registers them apart
+// from builtins.BuiltinFunctions, so graphs add them as builtins of their own.
+var harmonizationTables = map[string]int{
+	"$HarmonizeCode":         4, // (sourceName, sourceCode, sourceSystem, conceptMapID)
+	"$HarmonizeCodeBySearch": 1, // (sourceName, sourceCode, sourceSystem)
+}
+
+// isHarmonization returns whether a builtin looks its arguments up in a concept map.
+func isHarmonization(name string) bool {
+	_, ok := harmonizationTables[name]
+	return ok
+}
+
+// tableOf returns the 1-based index of the argument naming the concept map or lookup table a call
+// reads, which is given by the plugin's Table or by harmonizationTables, or 0 if it reads none.
+func (e *env) tableOf(projNode *ProjectorNode) int {
+	if table, ok := harmonizationTables[projNode.Name]; ok {
+		return table
+	}
+	def, _ := projNode.msg.(*mbp.ProjectorDefinition)
+	plugin, _ := e.pluginOf(def)
+	return plugin.Table
+}
+
+// addConceptMap adds the concept map or lookup table read by a call to a harmonization builtin or
+// a plugin with a table argument, as an ancestor of the call and of each field of its value. The
+// map derives from the nodes bound to the table argument.
+func (g Graph) addConceptMap(projNode *ProjectorNode, callEnv *env) error {
+	table := callEnv.tableOf(projNode)
+	if table == 0 || table > len(callEnv.args) {
+		return nil
+	}
+	tableIDs := []int{}
+	for _, arg := range callEnv.args[table-1] {
+		tableIDs = append(tableIDs, arg.node.ID())
+	}
+	bodyIDs := append([]int{}, g.Edges[projNode.ID()]...)
+	mapNode := &ConceptMapNode{
+		id:        callEnv.newID(),
+		Map:       g.constantString(tableIDs),
+		Projector: projNode.Name,
+		Context:   projNode.Context,
+	}
+	if err := addNode(g, mapNode, projNode, false, false, true); err != nil {
+		return fmt.Errorf("adding the concept map of %v failed:\n%w", Describe(projNode), err)
+	}
+	g.Edges[mapNode.ID()] = tableIDs
+	for _, id := range bodyIDs {
+		g.Edges[id] = append(g.Edges[id], mapNode.ID())
+	}
+	return nil
+}
+
+// constantString returns the string the nodes evaluate to, if they are, or derive from, a single
+// constant string, such as the file name of a concept map passed through a variable.
+func (g Graph) constantString(ids []int) string {
+	values := map[string]bool{}
+	for _, id := range ids {
+		for _, ancestorID := range append(g.Upstream(id, false), id) {
+			if s, ok := g.Nodes[ancestorID].(*ConstStringNode); ok {
+				values[s.Value] = true
+			}
+		}
+	}
+	if len(values) != 1 {
+		return ""
+	}
+	for value := range values {
+		return value
+	}
+	return ""
+}
+
+// mapName names a concept map by its file, or as computed if the mapping doesn't name it with a
+// constant.
+func mapName(file string) string {
+	if file == "" {
+		return "(computed)"
+	}
+	return file
+}
+
+// Derivation is an input field a node derives from, and the concept maps and lookup tables that
+// translate it on the way.
+type Derivation struct {
+	Input       int   // a RootNode
+	ConceptMaps []int // ConceptMapNodes, sorted
+}
+
+// Derivations returns the input fields a node derives from through primary and argument edges,
+// each with the concept maps it goes through, sorted by input. An input reaching the node both
+// directly and through a concept map has a derivation for each path.
+func (g Graph) Derivations(id int) []Derivation {
+	derivations := g.derivations(id, map[int]map[string]Derivation{}, map[int]bool{})
+	keys := []string{}
+	for key := range derivations {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := derivations[keys[i]], derivations[keys[j]]
+		if a.Input != b.Input {
+			return a.Input < b.Input
+		}
+		return keys[i] < keys[j]
+	})
+	result := []Derivation{}
+	for _, key := range keys {
+		result = append(result, derivations[key])
+	}
+	return result
+}
+
+func (g Graph) derivations(id int, memo map[int]map[string]Derivation, visiting map[int]bool) map[string]Derivation {
+	if derivations, ok := memo[id]; ok {
+		return derivations
+	}
+	derivations := map[string]Derivation{}
+	if _, ok := g.Nodes[id].(*RootNode); ok {
+		derivations[derivationKey(id, nil)] = Derivation{Input: id}
+		memo[id] = derivations
+		return derivations
+	}
+	if visiting[id] {
+		return derivations
+	}
+	visiting[id] = true
+	defer delete(visiting, id)
+
+	maps := []int{}
+	ancestorIDs := []int{}
+	for _, ancestorID := range g.Edges[id] {
+		if _, ok := g.Nodes[ancestorID].(*ConceptMapNode); ok {
+			maps = append(maps, ancestorID)
+		} else {
+			ancestorIDs = append(ancestorIDs, ancestorID)
+		}
+	}
+	if _, ok := g.Nodes[id].(*ConceptMapNode); ok {
+		ancestorIDs = nil // the file name of a concept map isn't data translated by it
+	}
+	tables := map[int]bool{}
+	for _, mapID := range maps {
+		for _, tableID := range g.Edges[mapID] {
+			tables[tableID] = true
+		}
+	}
+	ancestorIDs = append(ancestorIDs, g.ArgumentEdges[id]...)
+	for _, ancestorID := range ancestorIDs {
+		if tables[ancestorID] { // the argument naming the concept map
+			continue
+		}
+		for _, d := range g.derivations(ancestorID, memo, visiting) {
+			conceptMaps := uniqueInts(append(append([]int{}, d.ConceptMaps...), maps...))
+			derivations[derivationKey(d.Input, conceptMaps)] = Derivation{Input: d.Input, ConceptMaps: conceptMaps}
+		}
+	}
+	memo[id] = derivations
+	return derivations
+}
+
+func derivationKey(input int, conceptMaps []int) string {
+	return fmt.Sprint(input, conceptMaps)
+}
+
+func uniqueInts(ids []int) []int {
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Ints(ids)
+	unique := ids[:1]
+	for _, id := range ids[1:] {
+		if id != unique[len(unique)-1] {
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// DescribeDerivation describes a derivation, such as "$root.gender via concept map maps/gender.json".
+func (g Graph) DescribeDerivation(d Derivation) string {
+	input := "$root"
+	if root, ok := g.Nodes[d.Input].(*RootNode); ok {
+		input += root.Field
+	}
+	maps := []string{}
+	for _, id := range d.ConceptMaps {
+		if m, ok := g.Nodes[id].(*ConceptMapNode); ok {
+			maps = append(maps, "concept map "+mapName(m.Map))
+		}
+	}
+	if len(maps) == 0 {
+		return input
+	}
+	return input + " via " + strings.Join(maps, " and ")
+}
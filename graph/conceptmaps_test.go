@@ -0,0 +1,95 @@
+package graph
+
+import (
+	"sort"
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDerivations(t *testing.T) {
+	opts := Options{Plugins: []Plugin{{Name: "TranslateCode", NumArgs: 2, Table: 1}}}
+	translate := func(table *mbp.ValueSource, code *mbp.ValueSource) *mbp.ValueSource {
+		return makeProjSourceMsg("TranslateCode", table, []*mbp.ValueSource{code})
+	}
+	tests := []struct {
+		name     string
+		mappings []*mbp.FieldMapping
+		wantMaps []string
+		want     []string
+	}{
+		{
+			name:     "concept map named by a constant",
+			mappings: []*mbp.FieldMapping{makeMappingMsg("x", translate(makeStringMsg("maps/gender.json"), makeArgMsg(1, ".gender")), nil)},
+			wantMaps: []string{"maps/gender.json"},
+			want:     []string{"$root.gender via concept map maps/gender.json"},
+		},
+		{
+			name: "concept map named through a variable",
+			mappings: []*mbp.FieldMapping{
+				makeVarMappingMsg("m", makeStringMsg("maps/gender.json"), nil),
+				makeMappingMsg("x", translate(makeLocalVarMsg("m"), makeArgMsg(1, ".gender")), nil),
+			},
+			wantMaps: []string{"maps/gender.json"},
+			want:     []string{"$root.gender via concept map maps/gender.json"},
+		},
+		{
+			name:     "computed concept map",
+			mappings: []*mbp.FieldMapping{makeMappingMsg("x", translate(makeArgMsg(1, ".system"), makeArgMsg(1, ".code")), nil)},
+			wantMaps: []string{""},
+			want:     []string{"$root.code via concept map (computed)"},
+		},
+		{
+			name: "input read directly and through a concept map",
+			mappings: []*mbp.FieldMapping{
+				makeVarMappingMsg("t", translate(makeStringMsg("maps/code.json"), makeArgMsg(1, ".code")), nil),
+				makeMappingMsg("x", makeProjSourceMsg("$StrCat", makeArgMsg(1, ".code"), []*mbp.ValueSource{makeLocalVarMsg("t")}), nil),
+			},
+			wantMaps: []string{"maps/code.json"},
+			want:     []string{"$root.code", "$root.code via concept map maps/code.json"},
+		},
+		{
+			name: "harmonization builtin, not registered as a plugin",
+			mappings: []*mbp.FieldMapping{makeMappingMsg("x", makeProjSourceMsg("$HarmonizeCode", makeStringMsg("$$LOCAL"), []*mbp.ValueSource{
+				makeArgMsg(1, ".code"), makeArgMsg(1, ".system"), makeStringMsg("gender-map"),
+			}), nil)},
+			wantMaps: []string{"gender-map"},
+			want:     []string{"$root.code via concept map gender-map", "$root.system via concept map gender-map"},
+		},
+		{
+			name:     "no concept map",
+			mappings: []*mbp.FieldMapping{makeMappingMsg("x", makeArgMsg(1, ".code"), nil)},
+			wantMaps: []string{},
+			want:     []string{"$root.code"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := NewWithOptions(makeMappingConfigMsg(nil, test.mappings), opts)
+			if err != nil {
+				t.Fatalf("building graph failed:\n%v", err)
+			}
+			maps := []string{}
+			for _, node := range g.Nodes {
+				if m, ok := node.(*ConceptMapNode); ok {
+					maps = append(maps, m.Map)
+				}
+			}
+			if diff := cmp.Diff(test.wantMaps, maps); diff != "" {
+				t.Errorf("unexpected concept maps (-want +got):\n%v", diff)
+			}
+			got := []string{}
+			for _, id := range g.FindTargets("x") {
+				for _, d := range g.Derivations(id) {
+					got = append(got, g.DescribeDerivation(d))
+				}
+			}
+			sort.Strings(got)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("unexpected derivations (-want +got):\n%v", diff)
+			}
+		},
+		)
+	}
+}
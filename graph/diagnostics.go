@@ -0,0 +1,62 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DiagnosticKind classifies the problems found while building a graph.
+type DiagnosticKind string
+
+const (
+	// UnsupportedConstruct is a whistle construct the graph can't represent yet.
+	UnsupportedConstruct DiagnosticKind = "unsupported"
+	// UnresolvedReference is a projector, variable, destination or argument that couldn't be found.
+	UnresolvedReference DiagnosticKind = "unresolved"
+	// Recursion is a projector that calls itself.
+	Recursion DiagnosticKind = "recursion"
+	// UnknownField is an input field or output target missing from the schema given for it.
+	UnknownField DiagnosticKind = "unknown-field"
+)
+
+// Diagnostic is a problem found while building a graph in tolerant mode, where the offending mapping
+// is replaced by the UnknownNode NodeID, or a field missing from a schema, where NodeID is the field.
+// The Position is filled in when the graph is annotated with its source, from the node or the
+// nearest descendant with a position.
+type Diagnostic struct {
+	Kind     DiagnosticKind
+	Message  string
+	Context  string
+	NodeID   int
+	Position FileMetaData
+}
+
+func (d Diagnostic) String() string {
+	position := ""
+	if d.Position.LineStart > 0 {
+		position = fmt.Sprintf("%v:%v:%v: ", d.Position.FileName, d.Position.LineStart, d.Position.CharStart)
+	}
+	return fmt.Sprintf("%v%v in %v: %v", position, d.Kind, d.Context, d.Message)
+}
+
+// diagnose classifies a graph construction error and describes the problem; the diagnostic carries
+// its context and position separately. Errors that aren't a graph Error are described by the
+// innermost error they wrap, without the context of every enclosing message.
+func diagnose(err error) (DiagnosticKind, string) {
+	var gerr Error
+	if !errors.As(err, &gerr) {
+		for errors.Unwrap(err) != nil {
+			err = errors.Unwrap(err)
+		}
+		return UnsupportedConstruct, err.Error()
+	}
+	msg := gerr.problem()
+	switch gerr.(type) {
+	case *RecursionError:
+		return Recursion, msg
+	case *UnsupportedMessageError:
+		return UnsupportedConstruct, msg
+	default:
+		return UnresolvedReference, msg
+	}
+}
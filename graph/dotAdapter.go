@@ -2,18 +2,66 @@ package graph
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
 
 	"github.com/goccy/go-graphviz"
 	"github.com/goccy/go-graphviz/cgraph"
 )
 
+// WriteDOTpng renders the graph to DOT text and, if an output file is given, writes a PNG image of it.
 func WriteDOTpng(graph Graph, outputFile string) (string, error) {
+	var dotString string
+	err := withDOTGraph(graph, func(g *graphviz.Graphviz, dotGraph *cgraph.Graph) error {
+		var buf bytes.Buffer
+		if err := g.Render(dotGraph, "dot", &buf); err != nil {
+			return fmt.Errorf("%v", err)
+		}
+		dotString = buf.String()
+
+		if outputFile != "" {
+			if err := g.RenderFilename(dotGraph, graphviz.PNG, outputFile); err != nil {
+				return fmt.Errorf("could not write PNG image for graph %v\n%w", dotString, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return dotString, nil
+}
+
+// WriteSVG renders the graph to an SVG image.
+func WriteSVG(graph Graph) ([]byte, error) {
+	var buf bytes.Buffer
+	err := withDOTGraph(graph, func(g *graphviz.Graphviz, dotGraph *cgraph.Graph) error {
+		if err := g.Render(dotGraph, graphviz.SVG, &buf); err != nil {
+			return fmt.Errorf("could not render SVG image:\n%w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// graphvizMu serializes rendering, since the graphviz C library keeps global state.
+var graphvizMu sync.Mutex
+
+// withDOTGraph builds the DOT graph for the lineage graph and hands it to the render function.
+func withDOTGraph(graph Graph, render func(*graphviz.Graphviz, *cgraph.Graph) error) error {
+	graphvizMu.Lock()
+	defer graphvizMu.Unlock()
+
 	g := graphviz.New()
 	dotGraph, err := g.Graph()
 	if err != nil {
-		return "", fmt.Errorf("failed to create new dot graph:\n%w", err)
+		return fmt.Errorf("failed to create new dot graph:\n%w", err)
 	}
 	defer func() {
 		if err := dotGraph.Close(); err != nil {
@@ -22,17 +70,59 @@ func WriteDOTpng(graph Graph, outputFile string) (string, error) {
 		g.Close()
 	}()
 
+	unreachable := map[int]bool{}
+	for _, id := range graph.Unreachable() {
+		unreachable[id] = true
+	}
 	dotNodes := map[int]*cgraph.Node{}
 	for id, node := range graph.Nodes {
 		label, err := getNodeLabel(node)
 		if err != nil {
-			return "", fmt.Errorf("failed to create label for node %v:\n%w", node, err)
+			return fmt.Errorf("failed to create label for node %v:\n%w", node, err)
 		}
 		dotNode, err := dotGraph.CreateNode(fmt.Sprintf("%v", id))
 		if err != nil {
-			return "", fmt.Errorf("failed to create node for %v:\n%w", node, err)
+			return fmt.Errorf("failed to create node for %v:\n%w", node, err)
+		}
+		if labels, ok := graph.Labels[id]; ok {
+			label += labelsLabel(labels)
+			color := "red" // derived from a labelled field
+			if len(labels.Derived) == 0 {
+				color = "darkorange" // only influenced by one
+			}
+			dotNode.SetColor(color)
+			dotNode.SetFontColor(color)
+		}
+		if value, ok := graph.Values[id]; ok {
+			label += valueLabel(value)
+		}
+		if stats, ok := graph.Samples[id]; ok {
+			label += samplesLabel(stats)
+		}
+		if p, ok := node.(*ProjectorNode); ok && p.IsBuiltin {
+			if p.Semantics == Generating || p.Semantics == SideEffecting || p.Semantics == Discarding {
+				label += "\n" + string(p.Semantics)
+				dotNode.SetShape(cgraph.BoxShape)
+			}
+		}
+		if p, ok := node.(*ProjectorNode); ok && p.IsPlugin {
+			if p.IsLookup {
+				label += "\nlookup"
+			} else {
+				label += "\nplugin"
+			}
+		} else if ok && p.IsLookup {
+			label += "\nlookup"
+		}
+		if _, ok := node.(*ConceptMapNode); ok {
+			dotNode.SetShape(cgraph.CylinderShape)
 		}
 		dotNode.SetLabel(label)
+		if unreachable[id] { // a target whose condition is always false
+			dotNode.SetStyle(cgraph.DashedNodeStyle)
+			dotNode.SetFontColor("gray")
+			dotNode.SetColor("gray")
+		}
 		dotNodes[id] = dotNode
 	}
 
@@ -40,19 +130,26 @@ func WriteDOTpng(graph Graph, outputFile string) (string, error) {
 		for _, ancestorID := range ancestorIDs {
 			_, err := dotGraph.CreateEdge("", dotNodes[nodeID], dotNodes[ancestorID])
 			if err != nil {
-				return "", err
+				return err
 			}
 		}
 	}
 
 	for nodeID, ancestorIDs := range graph.ArgumentEdges {
-		for _, ancestorID := range ancestorIDs {
+		for i, ancestorID := range ancestorIDs {
 			e, err := dotGraph.CreateEdge("", dotNodes[nodeID], dotNodes[ancestorID])
 			if err != nil {
-				return "", err
+				return err
 			}
 			e.SetStyle(cgraph.DashedEdgeStyle)
-			e.SetLabel("arg")
+			label := "arg"
+			if slot := graph.ArgumentSlot(nodeID, i); slot > 0 {
+				label = fmt.Sprintf("arg %v", slot)
+			}
+			if p, ok := graph.Nodes[nodeID].(*ProjectorNode); ok && p.IsBuiltin {
+				label += "\n" + string(p.Semantics)
+			}
+			e.SetLabel(label)
 		}
 	}
 
@@ -60,26 +157,21 @@ func WriteDOTpng(graph Graph, outputFile string) (string, error) {
 		for _, ancestorID := range ancestorIDs {
 			e, err := dotGraph.CreateEdge("", dotNodes[nodeID], dotNodes[ancestorID])
 			if err != nil {
-				return "", err
+				return err
 			}
 			e.SetStyle(cgraph.DottedEdgeStyle)
-			e.SetLabel("cond")
-		}
-	}
-
-	var buf bytes.Buffer
-	if err := g.Render(dotGraph, "dot", &buf); err != nil {
-		return "", fmt.Errorf("%v", err)
-	}
-	dotString := buf.String()
-
-	if outputFile != "" {
-		if err := g.RenderFilename(dotGraph, graphviz.PNG, outputFile); err != nil {
-			return "", fmt.Errorf("could not write PNG image for graph %v\n%w", dotString, err)
+			label := "cond"
+			if counts, ok := graph.Branches[nodeID]; ok {
+				label += branchLabel(counts)
+				if counts.Taken == 0 && counts.Unknown == 0 { // never met on the samples
+					e.SetColor("red")
+				}
+			}
+			e.SetLabel(label)
 		}
 	}
 
-	return dotString, nil
+	return render(g, dotGraph)
 }
 
 func getNodeLabel(node Node) (string, error) {
@@ -101,10 +193,13 @@ func getNodeLabel(node Node) (string, error) {
 		} else if n.IsOut {
 			modString = "out "
 		}
-		return fmt.Sprintf("%v%v", modString, n.Name), nil
+		return fmt.Sprintf("%v%v%v", modString, n.Name, schemaLabel(n.Schema)), nil
 	case *ProjectorNode:
 		return fmt.Sprintf("def %v", n.Name), nil
 	case *ArgumentNode:
+		if n.Name != "" || n.Binding != "" {
+			return DescribeArgument(n), nil
+		}
 		fieldString := ""
 		if n.Field != "" {
 			fieldString = fmt.Sprintf("\nfield %v", n.Field)
@@ -115,8 +210,51 @@ func getNodeLabel(node Node) (string, error) {
 		if n.Field != "" {
 			fieldString = fmt.Sprintf("\nfield %v", n.Field)
 		}
-		return fmt.Sprintf("$root%v", fieldString), nil
+		return fmt.Sprintf("$root%v%v", fieldString, schemaLabel(n.Schema)), nil
+	case *ConceptMapNode:
+		return fmt.Sprintf("concept map\n%v", mapName(n.Map)), nil
+	case *UnknownNode:
+		return fmt.Sprintf("unknown\n%v", n.Reason), nil
 	default:
 		return "", fmt.Errorf("node of type %T is not supported", n)
 	}
 }
+
+// schemaLabel is the line showing a node's schema type and cardinality, if it has one.
+func schemaLabel(element SchemaElement) string {
+	if element.Type == "" {
+		return ""
+	}
+	return "\n" + element.String()
+}
+
+// labelsLabel is the line showing the sensitivity labels of a node, with the labels it is only
+// influenced by in parentheses.
+func labelsLabel(labels NodeLabels) string {
+	parts := append([]string{}, labels.Derived...)
+	if len(labels.Influenced) > 0 {
+		parts = append(parts, "("+strings.Join(labels.Influenced, ", ")+")")
+	}
+	return "\n" + strings.Join(parts, ", ")
+}
+
+// maxValueLabel is the length values are shortened to in node labels.
+const maxValueLabel = 40
+
+// valueLabel is the line showing the value of a node in an instance graph, as JSON.
+func valueLabel(value interface{}) string {
+	return "\n= " + shortJSON(value)
+}
+
+// shortJSON formats a value as JSON, shortened to maxValueLabel characters.
+func shortJSON(value interface{}) string {
+	out, err := json.Marshal(value)
+	if err != nil {
+		return "?"
+	}
+	s := []rune(string(out))
+	if len(s) > maxValueLabel {
+		s = append(s[:maxValueLabel-3], []rune("...")...)
+	}
+	return string(s)
+}
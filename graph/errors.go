@@ -0,0 +1,197 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error is implemented by the errors returned when a mapping can't be turned into a graph. Use
+// errors.As to find the concrete type, or to find the Error and its Info for any of them.
+type Error interface {
+	error
+	Info() *ErrorInfo
+	problem() string // the error message without its position and context
+}
+
+// ErrorInfo locates a graph construction error. Name is the projector, variable, argument or
+// message type at fault, Path the full field path if there is one, and Context the projector the
+// error occurred in ("root" for root mappings). The Position is only known once the error is
+// located in its source, see Source.Locate.
+type ErrorInfo struct {
+	Name     string
+	Path     string
+	Context  string
+	Position FileMetaData
+}
+
+// Info returns the location of the error.
+func (i *ErrorInfo) Info() *ErrorInfo { return i }
+
+// format prefixes the problem with the position of the error, if known, and suffixes it with its context.
+func (i *ErrorInfo) format(problem string) string {
+	position := ""
+	if i.Position.LineStart > 0 {
+		position = fmt.Sprintf("%v:%v:%v: ", i.Position.FileName, i.Position.LineStart, i.Position.CharStart)
+	}
+	if i.Context == "" {
+		return position + problem
+	}
+	return fmt.Sprintf("%v%v in %v", position, problem, i.Context)
+}
+
+// UnknownProjectorError is a call to a projector that isn't defined.
+type UnknownProjectorError struct {
+	ErrorInfo
+}
+
+func (e *UnknownProjectorError) Error() string { return e.format(e.problem()) }
+
+func (e *UnknownProjectorError) problem() string {
+	return fmt.Sprintf("projector %v is not defined", e.Name)
+}
+
+// UnresolvedDestinationError is a read of a destination field that hasn't been written.
+type UnresolvedDestinationError struct {
+	ErrorInfo
+}
+
+func (e *UnresolvedDestinationError) Error() string { return e.format(e.problem()) }
+
+func (e *UnresolvedDestinationError) problem() string {
+	return fmt.Sprintf("destination %v has not been written", e.Path)
+}
+
+// UnresolvedLocalVarError is a read of a local variable, or a field of one, that hasn't been written.
+// Name is the variable and Path the field read.
+type UnresolvedLocalVarError struct {
+	ErrorInfo
+}
+
+func (e *UnresolvedLocalVarError) Error() string { return e.format(e.problem()) }
+
+func (e *UnresolvedLocalVarError) problem() string {
+	return fmt.Sprintf("local variable %v has not been written", e.Path)
+}
+
+// ArgumentOutOfRangeError is a read of an argument the projector wasn't called with. Index is 1-based, as in whistle.
+type ArgumentOutOfRangeError struct {
+	ErrorInfo
+	Index   int
+	NumArgs int
+}
+
+func (e *ArgumentOutOfRangeError) Error() string { return e.format(e.problem()) }
+
+func (e *ArgumentOutOfRangeError) problem() string {
+	return fmt.Sprintf("argument %v is out of range; the projector has %v arguments", e.Index, e.NumArgs)
+}
+
+// ArgumentCountError is a call to a plugin with a different number of arguments than it takes.
+type ArgumentCountError struct {
+	ErrorInfo
+	NumArgs int
+	Want    int
+}
+
+func (e *ArgumentCountError) Error() string { return e.format(e.problem()) }
+
+func (e *ArgumentCountError) problem() string {
+	return fmt.Sprintf("plugin %v is called with %v arguments, but takes %v", e.Name, e.NumArgs, e.Want)
+}
+
+// RecursionError is a projector or target whose lineage depends on itself. Name is the projector or target.
+type RecursionError struct {
+	ErrorInfo
+}
+
+func (e *RecursionError) Error() string { return e.format(e.problem()) }
+
+func (e *RecursionError) problem() string {
+	return fmt.Sprintf("%v depends on itself", e.Name)
+}
+
+// UnsupportedMessageError is a whistler message the graph can't represent. Name is the message type.
+type UnsupportedMessageError struct {
+	ErrorInfo
+}
+
+func (e *UnsupportedMessageError) Error() string { return e.format(e.problem()) }
+
+func (e *UnsupportedMessageError) problem() string {
+	return fmt.Sprintf("%v is not supported", e.Name)
+}
+
+// messageType names the type of a whistler message without its package.
+func messageType(msg interface{}) string {
+	name := fmt.Sprintf("%T", msg)
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// Describe names a node by its kind and name or value, such as "target x", "argument 1.a of P" or
+// "$root.a", without printing its whole message.
+func Describe(node Node) string {
+	switch n := node.(type) {
+	case *TargetNode:
+		return fmt.Sprintf("target %v", n.Name)
+	case *ConstBoolNode:
+		return fmt.Sprintf("%v", n.Value)
+	case *ConstIntNode:
+		return fmt.Sprintf("%v", n.Value)
+	case *ConstFloatNode:
+		return fmt.Sprintf("%v", n.Value)
+	case *ConstStringNode:
+		return fmt.Sprintf("%q", n.Value)
+	case *ProjectorNode:
+		return fmt.Sprintf("projector %v", n.Name)
+	case *ArgumentNode:
+		return fmt.Sprintf("argument %v%v of %v", n.Index, n.Field, n.Context)
+	case *RootNode:
+		return fmt.Sprintf("$root%v", n.Field)
+	case *ConceptMapNode:
+		return fmt.Sprintf("concept map %v", n.Map)
+	case *UnknownNode:
+		return fmt.Sprintf("unknown %v", n.Reason)
+	default:
+		return fmt.Sprintf("%v", node)
+	}
+}
+
+// Context returns the name of the projector whose body a node is in, or "root".
+func Context(node Node) string {
+	switch n := node.(type) {
+	case *TargetNode:
+		return n.Context
+	case *ConstBoolNode:
+		return n.Context
+	case *ConstIntNode:
+		return n.Context
+	case *ConstFloatNode:
+		return n.Context
+	case *ConstStringNode:
+		return n.Context
+	case *ProjectorNode:
+		return n.Context
+	case *ArgumentNode:
+		return n.Context
+	case *RootNode:
+		return n.Context
+	case *ConceptMapNode:
+		return n.Context
+	case *UnknownNode:
+		return n.Context
+	default:
+		return ""
+	}
+}
+
+// nodeName returns the name of a target or projector node, or describes any other node.
+func nodeName(node Node) string {
+	switch n := node.(type) {
+	case *TargetNode:
+		return n.Name
+	case *ProjectorNode:
+		return n.Name
+	default:
+		return Describe(node)
+	}
+}
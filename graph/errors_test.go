@@ -0,0 +1,118 @@
+package graph
+
+import (
+	"errors"
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNew_TypedErrors(t *testing.T) {
+	fromInput := func(arg int32) *mbp.ValueSource {
+		return &mbp.ValueSource{Source: &mbp.ValueSource_FromInput{FromInput: &mbp.ValueSource_InputSource{Arg: arg}}}
+	}
+	tests := []struct {
+		name     string
+		mpc      *mbp.MappingConfig
+		target   Error
+		wantInfo ErrorInfo
+		wantMsg  string
+	}{
+		{
+			name: "unknown projector",
+			mpc: makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+				makeMappingMsg("x", makeProjValMsg("missing"), nil),
+			}),
+			target:   &UnknownProjectorError{},
+			wantInfo: ErrorInfo{Name: "missing", Context: "root"},
+			wantMsg:  "projector missing is not defined in root",
+		},
+		{
+			name: "unresolved destination",
+			mpc: makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+				makeMappingMsg("x", &mbp.ValueSource{Source: &mbp.ValueSource_FromDestination{FromDestination: "a.b"}}, nil),
+			}),
+			target:   &UnresolvedDestinationError{},
+			wantInfo: ErrorInfo{Name: "a", Path: "a.b", Context: "root"},
+			wantMsg:  "destination a.b has not been written in root",
+		},
+		{
+			name: "unresolved local variable",
+			mpc: makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+				makeMappingMsg("x", &mbp.ValueSource{Source: &mbp.ValueSource_FromLocalVar{FromLocalVar: "v"}}, nil),
+			}),
+			target:   &UnresolvedLocalVarError{},
+			wantInfo: ErrorInfo{Name: "v", Path: "v", Context: "root"},
+			wantMsg:  "local variable v has not been written in root",
+		},
+		{
+			name: "argument out of range",
+			mpc: makeMappingConfigMsg([]*mbp.ProjectorDefinition{
+				makeProjDefMsg("foo", []*mbp.FieldMapping{makeMappingMsg("y", fromInput(3), nil)}),
+			}, []*mbp.FieldMapping{
+				makeMappingMsg("x", makeProjValMsg("foo"), nil),
+			}),
+			target:   &ArgumentOutOfRangeError{},
+			wantInfo: ErrorInfo{Context: "foo"},
+			wantMsg:  "argument 3 is out of range; the projector has 0 arguments in foo",
+		},
+		{
+			name: "recursion",
+			mpc: makeMappingConfigMsg([]*mbp.ProjectorDefinition{
+				makeProjDefMsg("foo", []*mbp.FieldMapping{makeMappingMsg("y", makeProjValMsg("foo"), nil)}),
+			}, []*mbp.FieldMapping{
+				makeMappingMsg("x", makeProjValMsg("foo"), nil),
+			}),
+			target:   &RecursionError{},
+			wantInfo: ErrorInfo{Name: "foo", Context: "foo"},
+			wantMsg:  "foo depends on itself in foo",
+		},
+		{
+			name: "unsupported message",
+			mpc: makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+				makeMappingMsg("x", &mbp.ValueSource{Source: &mbp.ValueSource_FromSource{FromSource: "a"}}, nil),
+			}),
+			target:   &UnsupportedMessageError{},
+			wantInfo: ErrorInfo{Name: "ValueSource_FromSource", Context: "root"},
+			wantMsg:  "ValueSource_FromSource is not supported in root",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := New(test.mpc)
+			if err == nil {
+				t.Fatalf("expected an error building the graph")
+			}
+			target := test.target
+			if !errors.As(err, &target) {
+				t.Fatalf("expected error of type %T, but got %v", test.target, err)
+			}
+			if !cmp.Equal(test.wantInfo, *target.Info()) {
+				t.Errorf("expected error info %v, but got %v", test.wantInfo, *target.Info())
+			}
+			if target.Error() != test.wantMsg {
+				t.Errorf("expected message %q, but got %q", test.wantMsg, target.Error())
+			}
+		},
+		)
+	}
+}
+
+func TestNew_ArgumentOutOfRangeIndices(t *testing.T) {
+	mpc := makeMappingConfigMsg([]*mbp.ProjectorDefinition{
+		makeProjDefMsg("foo", []*mbp.FieldMapping{makeMappingMsg("y", &mbp.ValueSource{
+			Source: &mbp.ValueSource_FromInput{FromInput: &mbp.ValueSource_InputSource{Arg: 0, Field: ".c"}},
+		}, nil)}),
+	}, []*mbp.FieldMapping{
+		makeMappingMsg("x", makeProjValMsg("foo"), nil),
+	})
+	_, err := New(mpc)
+	var rangeErr *ArgumentOutOfRangeError
+	if !errors.As(err, &rangeErr) {
+		t.Fatalf("expected an ArgumentOutOfRangeError, but got %v", err)
+	}
+	if rangeErr.Index != 0 || rangeErr.NumArgs != 0 || rangeErr.Path != ".c" {
+		t.Errorf("expected argument 0 of 0 with path .c, but got %+v", rangeErr)
+	}
+}
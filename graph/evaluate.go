@@ -0,0 +1,318 @@
+package graph
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// evaluators are the pure builtins that can be evaluated when their arguments are known. They
+// return false if the arguments are of the wrong types.
+var evaluators = map[string]func(args []interface{}) (interface{}, bool){
+	"$Eq": func(args []interface{}) (interface{}, bool) {
+		if len(args) == 0 {
+			return nil, false
+		}
+		for _, arg := range args[1:] {
+			if !reflect.DeepEqual(arg, args[0]) {
+				return false, true
+			}
+		}
+		return true, true
+	},
+	"$NEq": func(args []interface{}) (interface{}, bool) {
+		return len(args) == 2 && !reflect.DeepEqual(args[0], args[1]), len(args) == 2
+	},
+	"$Not": func(args []interface{}) (interface{}, bool) {
+		if len(args) != 1 {
+			return nil, false
+		}
+		b, ok := args[0].(bool)
+		return !b, ok
+	},
+	"$Gt":   compare(func(a, b float64) bool { return a > b }),
+	"$GtEq": compare(func(a, b float64) bool { return a >= b }),
+	"$Lt":   compare(func(a, b float64) bool { return a < b }),
+	"$LtEq": compare(func(a, b float64) bool { return a <= b }),
+}
+
+// runners run the other pure builtins that Evaluate evaluates besides the evaluators.
+var runners = map[string]func(args []interface{}) (interface{}, bool){
+	and_keyword: func(args []interface{}) (interface{}, bool) {
+		for _, arg := range args {
+			if b, ok := arg.(bool); !ok || !b {
+				return false, true
+			}
+		}
+		return true, true
+	},
+	"$Or": func(args []interface{}) (interface{}, bool) {
+		for _, arg := range args {
+			if b, ok := arg.(bool); ok && b {
+				return true, true
+			}
+		}
+		return false, true
+	},
+	"$IsNil": func(args []interface{}) (interface{}, bool) {
+		return len(args) == 1 && isNil(args[0]), len(args) == 1
+	},
+	"$IsNotNil": func(args []interface{}) (interface{}, bool) {
+		return len(args) == 1 && !isNil(args[0]), len(args) == 1
+	},
+	"$StrCat": func(args []interface{}) (interface{}, bool) {
+		var b strings.Builder
+		for _, arg := range args {
+			if arg != nil {
+				fmt.Fprintf(&b, "%v", arg)
+			}
+		}
+		return b.String(), true
+	},
+	"$ListOf": func(args []interface{}) (interface{}, bool) {
+		return append([]interface{}{}, args...), true
+	},
+	"$ListCat": func(args []interface{}) (interface{}, bool) {
+		list := []interface{}{}
+		for _, arg := range args {
+			elements, ok := arg.([]interface{})
+			if arg != nil && !ok {
+				return nil, false
+			}
+			list = append(list, elements...)
+		}
+		return list, true
+	},
+	"$Sum": func(args []interface{}) (interface{}, bool) {
+		sum := 0.0
+		for _, arg := range args {
+			n, ok := arg.(float64)
+			if !ok {
+				return nil, false
+			}
+			sum += n
+		}
+		return sum, true
+	},
+}
+
+func compare(op func(a, b float64) bool) func(args []interface{}) (interface{}, bool) {
+	return func(args []interface{}) (interface{}, bool) {
+		if len(args) != 2 {
+			return nil, false
+		}
+		a, aOK := args[0].(float64)
+		b, bOK := args[1].(float64)
+		return aOK && bOK && op(a, b), aOK && bOK
+	}
+}
+
+// Evaluate partially evaluates a node. Constants evaluate to their value, with numbers as float64,
+// as in JSON. The pure builtins $Eq, $NEq, $Not, $And, $Or, $Gt, $GtEq, $Lt, $LtEq, $IsNil,
+// $IsNotNil, $StrCat, $ListOf, $ListCat and $Sum evaluate when their arguments do, and $And and
+// $Or short-circuit on a known false or true argument. Targets and arguments evaluate to the value
+// of their only ancestor. It returns false if the value depends on the input, on another
+// projector, or on which of several writes is read.
+func (g Graph) Evaluate(id int) (interface{}, bool) {
+	return g.evaluate(id, map[int]bool{}, nil)
+}
+
+// EvaluateOn evaluates a node like Evaluate, reading the input fields from a sample input decoded
+// from JSON. Fields missing from the sample are null.
+func (g Graph) EvaluateOn(id int, sample interface{}) (interface{}, bool) {
+	return g.evaluate(id, map[int]bool{}, &evalInput{value: sample})
+}
+
+// evalInput is the input a node is evaluated on. Without one, input fields are unknown.
+type evalInput struct {
+	value interface{}
+}
+
+func (g Graph) evaluate(id int, visiting map[int]bool, input *evalInput) (interface{}, bool) {
+	if visiting[id] {
+		return nil, false
+	}
+	visiting[id] = true
+	defer delete(visiting, id)
+
+	switch n := g.Nodes[id].(type) {
+	case *ConstBoolNode:
+		return n.Value, true
+	case *ConstIntNode:
+		return float64(n.Value), true
+	case *ConstFloatNode:
+		return float64(n.Value), true
+	case *ConstStringNode:
+		return n.Value, true
+	case *RootNode:
+		if input == nil {
+			return nil, false
+		}
+		value, _ := readField(input.value, n.Field)
+		return value, true
+	case *TargetNode:
+		if len(g.Edges[id]) != 1 || !g.alwaysMet(id, visiting, input) {
+			return nil, false
+		}
+		return g.evaluate(g.Edges[id][0], visiting, input)
+	case *ArgumentNode:
+		if len(g.Edges[id]) != 1 {
+			return nil, false
+		}
+		ancestor := g.Nodes[g.Edges[id][0]]
+		value, ok := g.evaluate(ancestor.ID(), visiting, input)
+		if ok && n.Field != "" && isInput(ancestor) && !isBinding(n, ancestor) { // the field is read from the input bound to the argument
+			value, _ = readField(value, n.Field)
+		}
+		return value, ok
+	case *ProjectorNode:
+		if !n.IsBuiltin || !g.distinctArgs(id) {
+			return nil, false
+		}
+		return g.evaluateBuiltin(n.Name, g.ArgumentEdges[id], visiting, input)
+	default:
+		return nil, false
+	}
+}
+
+func (g Graph) evaluateBuiltin(name string, argIDs []int, visiting map[int]bool, input *evalInput) (interface{}, bool) {
+	if name == and_keyword || name == "$Or" {
+		shortCircuit := name == "$Or" // a true argument decides $Or, and a false one $And
+		known := true
+		for _, argID := range argIDs {
+			value, ok := g.evaluate(argID, visiting, input)
+			if b, isBool := value.(bool); ok && isBool && b == shortCircuit {
+				return shortCircuit, true
+			}
+			known = known && ok
+		}
+		return !shortCircuit, known && len(argIDs) > 0
+	}
+	evaluator, ok := evaluators[name]
+	if !ok {
+		if evaluator, ok = runners[name]; !ok {
+			return nil, false
+		}
+	}
+	args := make([]interface{}, len(argIDs))
+	for i, argID := range argIDs {
+		if args[i], ok = g.evaluate(argID, visiting, input); !ok {
+			return nil, false
+		}
+	}
+	return evaluator(args)
+}
+
+// distinctArgs returns whether each of the projector's argument nodes is an argument of its own.
+// An argument read from a target written more than once has a node for each write, so the
+// arguments can't be told apart.
+func (g Graph) distinctArgs(id int) bool {
+	seen := map[string]int{}
+	for _, argID := range g.ArgumentEdges[id] {
+		var key string
+		switch n := g.Nodes[argID].(type) {
+		case *TargetNode:
+			key = "target " + n.Context + " " + n.Name
+		case *ArgumentNode:
+			key = "arg " + n.Context + " " + argName(n.Index, n.Field)
+		default:
+			continue
+		}
+		if prev, ok := seen[key]; ok && prev != argID {
+			return false
+		}
+		seen[key] = argID
+	}
+	return true
+}
+
+// alwaysMet returns whether the conditions of a node are all known to be true.
+func (g Graph) alwaysMet(id int, visiting map[int]bool, input *evalInput) bool {
+	for _, conditionID := range g.ConditionEdges[id] {
+		if value, ok := g.evaluate(conditionID, visiting, input); !ok || value != true {
+			return false
+		}
+	}
+	return true
+}
+
+// Unreachable returns the sorted IDs of the targets with a condition that is always false, which are never written.
+func (g Graph) Unreachable() []int {
+	ids := []int{}
+	for id, conditionIDs := range g.ConditionEdges {
+		for _, conditionID := range conditionIDs {
+			if value, ok := g.Evaluate(conditionID); ok && value == false {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// PruneUnreachable returns the graph without its unreachable targets and the nodes that only
+// feed them. Other nodes are kept even if they are left without ancestors.
+func (g Graph) PruneUnreachable() Graph {
+	descendants := map[int][]int{}
+	for _, adjList := range []map[int][]int{g.Edges, g.ArgumentEdges, g.ConditionEdges} {
+		for id, ancestorIDs := range adjList {
+			for _, ancestorID := range ancestorIDs {
+				descendants[ancestorID] = append(descendants[ancestorID], id)
+			}
+		}
+	}
+	pruned := map[int]bool{}
+	queue := g.Unreachable()
+	for _, id := range queue {
+		pruned[id] = true
+	}
+	for len(queue) > 0 {
+		var id int
+		id, queue = queue[0], queue[1:]
+		for _, adjList := range []map[int][]int{g.Edges, g.ArgumentEdges, g.ConditionEdges} {
+			for _, ancestorID := range adjList[id] {
+				if pruned[ancestorID] {
+					continue
+				}
+				onlyFeedsPruned := true
+				for _, d := range descendants[ancestorID] {
+					onlyFeedsPruned = onlyFeedsPruned && pruned[d]
+				}
+				if onlyFeedsPruned {
+					pruned[ancestorID] = true
+					queue = append(queue, ancestorID)
+				}
+			}
+		}
+	}
+
+	keep := []int{}
+	for id := range g.Nodes {
+		if !pruned[id] {
+			keep = append(keep, id)
+		}
+	}
+	sub := g.Subgraph(keep)
+	for _, diagnostic := range g.Diagnostics {
+		if !pruned[diagnostic.NodeID] {
+			sub.Diagnostics = append(sub.Diagnostics, diagnostic)
+		}
+	}
+	return sub
+}
+
+// isNil returns whether a value is null, or an empty object or array, which the mapping engine doesn't write.
+func isNil(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
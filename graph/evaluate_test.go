@@ -0,0 +1,116 @@
+package graph
+
+import (
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+)
+
+func TestEvaluate(t *testing.T) {
+	eq := func(a, b *mbp.ValueSource) *mbp.ValueSource {
+		return makeProjSourceMsg("$Eq", a, []*mbp.ValueSource{b})
+	}
+	tests := []struct {
+		name      string
+		vars      []*mbp.FieldMapping
+		condition *mbp.ValueSource
+		wantValue interface{}
+		wantOK    bool
+	}{
+		{
+			name:      "constants not equal",
+			condition: eq(makeIntMsg(0), makeIntMsg(5)),
+			wantValue: false,
+			wantOK:    true,
+		},
+		{
+			name:      "int equals float",
+			condition: eq(makeIntMsg(2), makeFloatMsg(2)),
+			wantValue: true,
+			wantOK:    true,
+		},
+		{
+			name:      "else branch",
+			condition: makeProjectedSourceMsg(eq(makeIntMsg(0), makeIntMsg(5)), "$Not", nil),
+			wantValue: true,
+			wantOK:    true,
+		},
+		{
+			name:      "input",
+			condition: eq(makeArgMsg(1, ".a"), makeIntMsg(5)),
+			wantOK:    false,
+		},
+		{
+			name:      "variable",
+			vars:      []*mbp.FieldMapping{makeVarMappingMsg("v", makeStringMsg("test"), nil)},
+			condition: eq(makeLocalVarMsg("v"), makeStringMsg("test")),
+			wantValue: true,
+			wantOK:    true,
+		},
+		{
+			name: "variable written twice",
+			vars: []*mbp.FieldMapping{
+				makeVarMappingMsg("v", makeIntMsg(1), nil),
+				makeVarMappingMsg("v", makeIntMsg(2), nil),
+			},
+			condition: eq(makeLocalVarMsg("v"), makeIntMsg(2)),
+			wantOK:    false,
+		},
+		{
+			name:      "or short-circuits",
+			condition: makeProjSourceMsg("$Or", makeArgMsg(1, ".a"), []*mbp.ValueSource{makeBoolMsg(true)}),
+			wantValue: true,
+			wantOK:    true,
+		},
+		{
+			name:      "comparison",
+			condition: makeProjSourceMsg("$Gt", makeIntMsg(1), []*mbp.ValueSource{makeFloatMsg(2.5)}),
+			wantValue: false,
+			wantOK:    true,
+		},
+		{
+			name:      "impure builtin",
+			condition: eq(makeProjSourceMsg("$CurrentTime", &mbp.ValueSource{}, nil), makeIntMsg(0)),
+			wantOK:    false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mappings := append(test.vars, makeMappingMsg("x", makeIntMsg(1), test.condition))
+			g, err := New(makeMappingConfigMsg(nil, mappings))
+			if err != nil {
+				t.Fatalf("building the graph failed:\n%v", err)
+			}
+			x := g.Outputs()["x"][0]
+			if len(g.ConditionEdges[x]) != 1 {
+				t.Fatalf("expected target x to have one condition, but got %v", g)
+			}
+			value, ok := g.Evaluate(g.ConditionEdges[x][0])
+			if ok != test.wantOK || (ok && value != test.wantValue) {
+				t.Errorf("expected the condition to evaluate to %v (%v), but got %v (%v)", test.wantValue, test.wantOK, value, ok)
+			}
+			unreachable := len(g.Unreachable()) > 0
+			if wantUnreachable := test.wantOK && test.wantValue == false; unreachable != wantUnreachable {
+				t.Errorf("expected x to be unreachable: %v, but got unreachable targets %v", wantUnreachable, g.Unreachable())
+			}
+		},
+		)
+	}
+}
+
+func TestPruneUnreachable(t *testing.T) {
+	mpc := makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+		makeMappingMsg("x", makeStringMsg("never"), makeProjSourceMsg("$Eq", makeIntMsg(0), []*mbp.ValueSource{makeIntMsg(5)})),
+		makeMappingMsg("y", makeStringMsg("always"), nil),
+	})
+	g, err := NewWithOptions(mpc, Options{PruneUnreachable: true})
+	if err != nil {
+		t.Fatalf("building the graph failed:\n%v", err)
+	}
+	if len(g.Nodes) != 2 {
+		t.Errorf("expected only y and its value to be left, but got %v", g)
+	}
+	if _, ok := g.Outputs()["y"]; !ok {
+		t.Errorf("expected y to be kept, but got %v", g)
+	}
+}
@@ -2,17 +2,19 @@ package graph
 
 import (
 	"fmt"
+	"hash/fnv"
+	"sort"
 	"strings"
 
 	"github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/builtins"
 	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
 	proto "github.com/golang/protobuf/proto"
-	"github.com/google/go-cmp/cmp"
-	"google.golang.org/protobuf/testing/protocmp"
 )
 
 const anon_prefix = "$anon_block_"
 const and_keyword = "$And"
+const rootContext = "root"
+const this_keyword = "$this"
 
 // env represents the lexical scope a whistler message and its corresponding node belong to.
 // args is a list of the parent projector arguments.
@@ -24,12 +26,107 @@ type env struct {
 	args    [][]argLineage // args, targets, and vars may have many multiple mappings due to conditions and overwrites
 	targets map[string][]targetLineage
 	vars    map[string][]targetLineage
+	ids     *idGenerator // shared by every env of a graph
+	calls   *callStack   // shared by every env of a graph
+	// templates holds the projector templates of a summarised graph, keyed by projector name. It is nil when projectors are inlined.
+	templates map[string]*projectorTemplate
+	template  *projectorTemplate // the template being expanded in this env, if any
+	// diagnostics collects the problems worked around in tolerant mode. It is nil when any problem fails the build.
+	diagnostics *[]Diagnostic
+	// params holds the declared parameter names of each projector, shared by every env of a graph,
+	// and bindings the text of each argument the projector of this env is called with.
+	params   map[string][]string
+	bindings []string
+	// plugins holds the plugin each projector definition standing for one stands for, shared by
+	// every env of a graph.
+	plugins map[*mbp.ProjectorDefinition]Plugin
+	// semantics holds the semantics of the builtins classified by the graph's options, shared by
+	// every env of a graph.
+	semantics map[string]BuiltinSemantics
+}
+
+// projectorTemplate is the body of a projector expanded once and shared by all its calls in a summarised graph.
+// The template's ArgumentNodes aren't bound to any call; each call binds them through ArgumentNodes of its own.
+type projectorTemplate struct {
+	numArgs int
+	bodyIDs []int
+	params  []*ArgumentNode
+}
+
+// Options configures how a graph is built.
+type Options struct {
+	// Summarise expands each projector's body once into a template shared by all calls to it, instead
+	// of once per call. The graph is much smaller, but the lineage of a call also includes the
+	// arguments of every other call to the projector.
+	Summarise bool
+	// Tolerant replaces the mappings that can't be added to the graph by UnknownNodes, and lists
+	// the problems in the graph's Diagnostics instead of failing.
+	Tolerant bool
+	// PruneUnreachable removes the targets whose conditions are always false, and the nodes that
+	// only feed them, from the graph.
+	PruneUnreachable bool
+	// InputSchema and OutputSchema, if set, type the $root input fields and the output targets.
+	// Fields missing from them are listed in the graph's Diagnostics.
+	InputSchema  Schema
+	OutputSchema Schema
+	// Labels tags input fields with sensitivity labels, which are propagated to the nodes computed
+	// from them. LabelConditions also propagates them from conditions to the targets they guard.
+	Labels          []LabelRule
+	LabelConditions bool
+	// Samples are sample inputs, decoded from JSON, the input fields and conditions are evaluated
+	// on; see ApplySamples.
+	Samples []interface{}
+	// Params holds the declared parameter names of each projector, such as the Params of the
+	// mapping's Source. The transpiled ProjectorDefinitions don't keep them, so without Params
+	// arguments are named by their index.
+	Params map[string][]string
+	// Plugins are the signatures of the Go projectors registered with the mapping engine that the
+	// mapping can call. A projector of the same name defined by the mapping takes precedence.
+	Plugins []Plugin
+	// BuiltinSemantics classifies builtins by how their value relates to their arguments, such as
+	// custom Go builtins added to builtins.BuiltinFunctions, overriding SemanticsOf.
+	BuiltinSemantics map[string]BuiltinSemantics
+}
+
+// newID allocates a node ID from the graph's generator. A nil env, as used by tests of a single
+// node, allocates from a generator of its own.
+func (e *env) newID() int {
+	if e == nil {
+		return (&idGenerator{}).newID()
+	}
+	return e.generator().newID()
+}
+
+// generator returns the env's ID generator. An env built without one, as in tests, gets its own the
+// first time, which the envs derived from it share.
+func (e *env) generator() *idGenerator {
+	if e.ids == nil {
+		e.ids = &idGenerator{}
+	}
+	return e.ids
+}
+
+// context returns the name of the env for error messages.
+func (e *env) context() string {
+	if e == nil {
+		return ""
+	}
+	return e.name
+}
+
+// callStack returns the call stack of the graph being built.
+func (e *env) callStack() *callStack {
+	if e.calls == nil {
+		e.calls = &callStack{hashes: map[proto.Message]uint64{}}
+	}
+	return e.calls
 }
 
 // ancestorCollection is a composition containing lists of ancestors a whistler message can generate
 type ancestorCollection struct {
 	mainAncestors []whistlerNode
 	projectorArgs [][]whistlerNode
+	argTexts      []string // the text of each argument of a projector call
 	conditions    []whistlerNode
 }
 
@@ -54,13 +151,31 @@ type argLineage struct {
 	childTargets map[string][]targetLineage
 }
 
-// New uses a whistler MappingConfig to generate a new lineage graph.
+// New uses a whistler MappingConfig to generate a new lineage graph, inlining every projector call.
 func New(mpc *mbp.MappingConfig) (Graph, error) {
+	return NewWithOptions(mpc, Options{})
+}
+
+// NewWithOptions uses a whistler MappingConfig to generate a new lineage graph built with the given options.
+func NewWithOptions(mpc *mbp.MappingConfig, opts Options) (Graph, error) {
+	plugins, err := pluginDefinitions(opts.Plugins)
+	if err != nil {
+		return Graph{}, fmt.Errorf("invalid plugin:\n%w", err)
+	}
 	projectors := make(map[string]*mbp.ProjectorDefinition)
+	for p := range plugins {
+		projectors[p.GetName()] = p
+	}
 	for _, p := range mpc.GetProjector() {
 		projectors[p.GetName()] = p
 	}
-	for name, _ := range builtins.BuiltinFunctions {
+	for name := range builtins.BuiltinFunctions {
+		projectors[name] = &mbp.ProjectorDefinition{
+			Name:    name,
+			Mapping: []*mbp.FieldMapping{},
+		}
+	}
+	for name := range harmonizationTables { // the engine registers them apart from BuiltinFunctions
 		projectors[name] = &mbp.ProjectorDefinition{
 			Name:    name,
 			Mapping: []*mbp.FieldMapping{},
@@ -70,24 +185,45 @@ func New(mpc *mbp.MappingConfig) (Graph, error) {
 	graph := Graph{
 		Edges:             map[int][]int{},
 		ArgumentEdges:     map[int][]int{},
+		ArgumentSlots:     map[int][]int{},
 		ConditionEdges:    map[int][]int{},
 		RootAndOutTargets: map[string][]int{},
 		Nodes:             map[int]Node{},
 		targetLineages:    map[int]targetLineage{},
 	}
 	e := &env{
-		name:    "root",
-		parent:  nil,
-		args:    [][]argLineage{},
-		targets: map[string][]targetLineage{},
-		vars:    map[string][]targetLineage{},
+		name:      rootContext,
+		parent:    nil,
+		args:      [][]argLineage{},
+		targets:   map[string][]targetLineage{},
+		vars:      map[string][]targetLineage{},
+		ids:       &idGenerator{},
+		calls:     &callStack{hashes: map[proto.Message]uint64{}},
+		params:    opts.Params,
+		plugins:   plugins,
+		semantics: opts.BuiltinSemantics,
+	}
+	if opts.Summarise {
+		e.templates = map[string]*projectorTemplate{}
+	}
+	if opts.Tolerant {
+		e.diagnostics = &[]Diagnostic{}
 	}
 	wstlrNodes := make([]whistlerNode, len(mpc.GetRootMapping()))
 	for i, mapping := range mpc.GetRootMapping() {
 		wstlrNodes[i] = whistlerNode{msg: mapping}
 	}
 	if err := graph.addAncestorLineages(ancestorCollection{mainAncestors: wstlrNodes}, e, nil, projectors); err != nil {
-		return Graph{}, fmt.Errorf("adding lineages for mapping config {%v} failed:\n%w", mpc, err)
+		return Graph{}, fmt.Errorf("adding lineages for the root mappings failed:\n%w", err)
+	}
+	if e.diagnostics != nil {
+		graph.Diagnostics = *e.diagnostics
+	}
+	graph.ApplySchemas(opts.InputSchema, opts.OutputSchema)
+	graph.ApplyLabels(opts.Labels, opts.LabelConditions)
+	graph.ApplySamples(opts.Samples)
+	if opts.PruneUnreachable {
+		return graph.PruneUnreachable(), nil
 	}
 	return graph, nil
 }
@@ -98,7 +234,12 @@ func New(mpc *mbp.MappingConfig) (Graph, error) {
 func (g Graph) addWhistlerLineage(wstlrNode whistlerNode, wstlrEnv *env, descendantNode Node, isArg bool, isCondition bool, projectors map[string]*mbp.ProjectorDefinition) (Node, error) {
 	node, nodeIsNew, err := getNode(wstlrNode, wstlrEnv)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get a node from msg {%v}:\n%w", wstlrNode.msg, err)
+		return g.addUnknownNode(wstlrEnv, descendantNode, isArg, isCondition, err)
+	}
+	calls := wstlrEnv.callStack()
+	if !isArg && calls.isRecursive(node) {
+		err := &RecursionError{ErrorInfo{Name: nodeName(node), Context: wstlrEnv.context()}}
+		return g.addUnknownNode(wstlrEnv, descendantNode, isArg, isCondition, err)
 	}
 	if err = addNode(g, node, descendantNode, isArg, isCondition, nodeIsNew); err != nil {
 		return nil, fmt.Errorf("adding node %v to graph failed:\n%w", node, err)
@@ -106,23 +247,57 @@ func (g Graph) addWhistlerLineage(wstlrNode whistlerNode, wstlrEnv *env, descend
 	if !nodeIsNew {
 		return node, nil
 	}
+	calls.push(node, isArg)
+	defer calls.pop()
+	if argNode, ok := node.(*ArgumentNode); ok && wstlrEnv.template != nil {
+		wstlrEnv.template.params = append(wstlrEnv.template.params, argNode)
+	}
 
 	allAncestors, err := getAllAncestors(wstlrNode, wstlrEnv, projectors)
 	if err != nil {
-		return nil, fmt.Errorf("getting ancestors for msg {%v} failed:\n%w", wstlrNode.msg, err)
+		err = fmt.Errorf("getting the ancestors of %v failed:\n%w", Describe(node), err)
+		if _, err := g.addUnknownNode(wstlrEnv, node, false, false, err); err != nil {
+			return nil, err
+		}
+		return node, nil
 	}
 	if err = g.addAncestorLineages(allAncestors, wstlrEnv, node, projectors); err != nil {
-		return nil, fmt.Errorf("adding lineage for ancestors of msg {%v} failed:\n%w", wstlrNode.msg, err)
+		return nil, fmt.Errorf("adding lineage for ancestors of %v failed:\n%w", Describe(node), err)
 	}
 
 	return node, nil
 }
 
+// addUnknownNode adds an UnknownNode in place of a message that couldn't be added to the graph, and
+// records a diagnostic. Outside of tolerant mode, it returns the error instead.
+func (g Graph) addUnknownNode(wstlrEnv *env, descendantNode Node, isArg bool, isCondition bool, err error) (Node, error) {
+	if wstlrEnv.diagnostics == nil {
+		return nil, err
+	}
+	kind, msg := diagnose(err)
+	node := &UnknownNode{
+		id:      wstlrEnv.newID(),
+		Reason:  string(kind),
+		Context: wstlrEnv.name,
+	}
+	if err := addNode(g, node, descendantNode, isArg, isCondition, true); err != nil {
+		return nil, fmt.Errorf("adding placeholder %v to graph failed:\n%w", node, err)
+	}
+	*wstlrEnv.diagnostics = append(*wstlrEnv.diagnostics, Diagnostic{
+		Kind:    kind,
+		Message: msg,
+		Context: wstlrEnv.name,
+		NodeID:  node.ID(),
+	})
+	return node, nil
+}
+
 func (g Graph) addAncestorLineages(allAncestors ancestorCollection, descendantEnv *env, descendantNode Node, projectors map[string]*mbp.ProjectorDefinition) error {
 	ancestorEnv := descendantEnv
-	if projNode, ok := descendantNode.(*ProjectorNode); ok { // if this descendant is a projector, then a new environment is made
+	projNode, isProjector := descendantNode.(*ProjectorNode)
+	if isProjector { // if this descendant is a projector, then a new environment is made
 		var err error
-		if ancestorEnv, err = g.addArgLineages(allAncestors.projectorArgs, descendantEnv, projNode, projectors); err != nil {
+		if ancestorEnv, err = g.addArgLineages(allAncestors.projectorArgs, allAncestors.argTexts, descendantEnv, projNode, projectors); err != nil {
 			return fmt.Errorf("failed to add argument lineages to the graph:\n%w", err)
 		}
 	}
@@ -130,21 +305,34 @@ func (g Graph) addAncestorLineages(allAncestors ancestorCollection, descendantEn
 		return fmt.Errorf("failed to add condition lineages to the graph:\n%w", err)
 	}
 
+	if isProjector && ancestorEnv.templates != nil && !projNode.IsBuiltin && !projNode.IsPlugin && !strings.HasPrefix(projNode.Name, anon_prefix) {
+		if err := g.addTemplateLineage(allAncestors.mainAncestors, ancestorEnv, projNode, projectors); err != nil {
+			return fmt.Errorf("failed to add the template lineage of %v to the graph:\n%w", projNode, err)
+		}
+		return nil
+	}
+
 	if err := g.addMainAncestorLineages(allAncestors.mainAncestors, ancestorEnv, descendantNode, projectors); err != nil {
 		return fmt.Errorf("failed to add ancestor lineages to the graph:\n%w", err)
 	}
+	if isProjector && (projNode.IsPlugin || projNode.IsBuiltin) {
+		return g.addConceptMap(projNode, ancestorEnv)
+	}
 	return nil
 }
 
 // adds projector arguments and their lineages to the graph and returns the new projector environment they create
-func (g Graph) addArgLineages(argLists [][]whistlerNode, descendantEnv *env, projNode *ProjectorNode, projectors map[string]*mbp.ProjectorDefinition) (*env, error) {
+func (g Graph) addArgLineages(argLists [][]whistlerNode, argTexts []string, descendantEnv *env, projNode *ProjectorNode, projectors map[string]*mbp.ProjectorDefinition) (*env, error) {
 	envArgs := make([][]argLineage, len(argLists))
 	for i, args := range argLists {
 		envArgs[i] = make([]argLineage, len(args))
 		for j, arg := range args {
 			node, err := g.addWhistlerLineage(arg, descendantEnv, projNode, true, false, projectors)
 			if err != nil {
-				return nil, fmt.Errorf("adding lineage for projector argument {%v} failed:\n%w", arg, err)
+				return nil, fmt.Errorf("adding lineage for argument %v of %v failed:\n%w", i+1, Describe(projNode), err)
+			}
+			if g.ArgumentSlots != nil { // graphs put together by hand may not record slots
+				g.ArgumentSlots[projNode.ID()] = append(g.ArgumentSlots[projNode.ID()], i+1)
 			}
 			var childTargets map[string][]targetLineage
 			if target, ok := node.(*TargetNode); ok {
@@ -155,18 +343,11 @@ func (g Graph) addArgLineages(argLists [][]whistlerNode, descendantEnv *env, pro
 				childTargets = l.childTargets
 			}
 			if argProj, ok := node.(*ProjectorNode); ok {
-				targetIDs, ok := g.Edges[argProj.ID()]
-				if !ok {
-					return nil, fmt.Errorf("the node %v was not found in the graph", argProj)
-				}
-				childTargets = map[string][]targetLineage{}
-				for _, targetID := range targetIDs {
-					lineage, ok := g.targetLineages[targetID]
-					if !ok {
-						return nil, fmt.Errorf("the target node %v should have a lineage in graph.targetLineages", targetID)
-					}
-					appendOrAddTargetLineage(childTargets, lineage, lineage.node.Name)
+				lineage := targetLineage{childTargets: map[string][]targetLineage{}}
+				if err := writeTargetLineage(argProj, &lineage, g); err != nil {
+					return nil, fmt.Errorf("failed to find the targets of %v:\n%w", Describe(argProj), err)
 				}
+				childTargets = lineage.childTargets
 			}
 			envArgs[i][j] = argLineage{
 				node:         node,
@@ -179,31 +360,105 @@ func (g Graph) addArgLineages(argLists [][]whistlerNode, descendantEnv *env, pro
 		parentEnv = descendantEnv // only remember the parent if in a closure
 	}
 	return &env{
-		name:    projNode.Name,
-		parent:  parentEnv,
-		args:    envArgs,
-		targets: map[string][]targetLineage{},
-		vars:    map[string][]targetLineage{},
+		name:        projNode.Name,
+		parent:      parentEnv,
+		args:        envArgs,
+		targets:     map[string][]targetLineage{},
+		vars:        map[string][]targetLineage{},
+		ids:         descendantEnv.generator(),
+		calls:       descendantEnv.callStack(),
+		templates:   descendantEnv.templates,
+		diagnostics: descendantEnv.diagnostics,
+		params:      descendantEnv.params,
+		bindings:    argTexts,
+		plugins:     descendantEnv.plugins,
+		semantics:   descendantEnv.semantics,
 	}, nil
 }
 
+// addTemplateLineage links a projector call to the template of the projector's body, expanding the
+// template on the first call. Each distinct argument the template reads is bound to the call's
+// arguments by a new ArgumentNode in the context of the call.
+func (g Graph) addTemplateLineage(mappings []whistlerNode, callEnv *env, projNode *ProjectorNode, projectors map[string]*mbp.ProjectorDefinition) error {
+	template, ok := callEnv.templates[projNode.Name]
+	if ok && template.numArgs != len(callEnv.args) {
+		return g.addMainAncestorLineages(mappings, callEnv, projNode, projectors) // the template can't be bound to this call
+	}
+	if !ok {
+		template = &projectorTemplate{numArgs: len(callEnv.args)}
+		callEnv.templates[projNode.Name] = template
+		templateEnv := &env{
+			name:        projNode.Name,
+			args:        make([][]argLineage, len(callEnv.args)), // unbound, so the template's arguments have no ancestors
+			targets:     map[string][]targetLineage{},
+			vars:        map[string][]targetLineage{},
+			ids:         callEnv.generator(),
+			calls:       callEnv.callStack(),
+			templates:   callEnv.templates,
+			template:    template,
+			diagnostics: callEnv.diagnostics,
+			params:      callEnv.params,
+			plugins:     callEnv.plugins,
+			semantics:   callEnv.semantics,
+		}
+		if err := g.addMainAncestorLineages(mappings, templateEnv, projNode, projectors); err != nil {
+			return fmt.Errorf("failed to expand the template of projector %v:\n%w", projNode.Name, err)
+		}
+		template.bodyIDs = append([]int{}, g.Edges[projNode.ID()]...)
+	} else {
+		g.Edges[projNode.ID()] = append(g.Edges[projNode.ID()], template.bodyIDs...)
+	}
+
+	bindings := map[string]*ArgumentNode{}
+	for _, param := range template.params {
+		key := argName(param.Index, param.Field)
+		if binding, ok := bindings[key]; ok {
+			if err := addNode(g, binding, param, false, false, false); err != nil {
+				return fmt.Errorf("failed to bind argument %v:\n%w", param, err)
+			}
+			continue
+		}
+		binding := &ArgumentNode{
+			id:      callEnv.newID(),
+			Index:   param.Index,
+			Field:   param.Field,
+			Context: projNode.Name,
+			Name:    param.Name,
+			Binding: callEnv.binding(param.Index),
+			msg:     param.msg,
+		}
+		bindings[key] = binding
+		if err := addNode(g, binding, param, false, false, true); err != nil {
+			return fmt.Errorf("failed to bind argument %v:\n%w", param, err)
+		}
+		ancestors, err := argumentAncestor(param.msg.(*mbp.ValueSource).GetFromInput(), callEnv)
+		if err != nil {
+			return fmt.Errorf("failed to find the value of argument %v:\n%w", param, err)
+		}
+		if err := g.addMainAncestorLineages(ancestors, callEnv, binding, projectors); err != nil {
+			return fmt.Errorf("failed to bind argument %v:\n%w", param, err)
+		}
+	}
+	return nil
+}
+
 func (g Graph) addConditionLineages(conditions []whistlerNode, descendantEnv *env, descendantNode Node, projectors map[string]*mbp.ProjectorDefinition) error {
 	for _, condition := range conditions {
 		if _, err := g.addWhistlerLineage(condition, descendantEnv, descendantNode, false, true, projectors); err != nil {
-			return fmt.Errorf("adding lineage for condition {%v} failed:\n%w", condition, err)
+			return fmt.Errorf("adding lineage for a condition of %v failed:\n%w", Describe(descendantNode), err)
 		}
 	}
 	return nil
 }
 
 func (g Graph) addMainAncestorLineages(ancestors []whistlerNode, newEnv *env, descendantNode Node, projectors map[string]*mbp.ProjectorDefinition) error {
-	for _, wstlrNode := range ancestors {
+	for _, wstlrNode := range orderByDestination(ancestors) {
 		node, err := g.addWhistlerLineage(wstlrNode, newEnv, descendantNode, false, false, projectors)
 		if err != nil {
-			return fmt.Errorf("adding lineage for message {%v} failed:\n%w", wstlrNode.msg, err)
+			return fmt.Errorf("adding lineage for an ancestor of %v failed:\n%w", Describe(descendantNode), err)
 		}
 
-		if targetNode, ok := node.(*TargetNode); ok {
+		if targetNode, ok := node.(*TargetNode); ok && wstlrNode.nodeInGraph == nil { // targets already in the graph are only read
 			lineage, err := targetLineageFromGraph(targetNode, g)
 			if err != nil {
 				return fmt.Errorf("failed to generate lineage for target %v:\n%w", targetNode, err)
@@ -213,6 +468,9 @@ func (g Graph) addMainAncestorLineages(ancestors []whistlerNode, newEnv *env, de
 				appendOrAddTargetLineage(newEnv.vars, lineage, targetNode.Name)
 			} else {
 				appendOrAddTargetLineage(newEnv.targets, lineage, targetNode.Name)
+				if isThis(targetNode.Name) { // the fields of the whole output are written into it
+					mergeTargetLineages(newEnv.targets, lineage.childTargets)
+				}
 			}
 			if targetNode.IsOut || targetNode.IsRoot {
 				appendOrAddID(g.RootAndOutTargets, targetNode.ID(), targetNode.Name)
@@ -222,6 +480,112 @@ func (g Graph) addMainAncestorLineages(ancestors []whistlerNode, newEnv *env, de
 	return nil
 }
 
+// orderByDestination moves each mapping reading a destination that is only written by later
+// mappings after them, so the read can link to the targets. Mappings are otherwise kept in order,
+// and mappings reading each other's targets are left in place.
+func orderByDestination(ancestors []whistlerNode) []whistlerNode {
+	written := make([][]string, len(ancestors))
+	for i, ancestor := range ancestors {
+		if m, ok := ancestor.msg.(*mbp.FieldMapping); ok && m.GetTargetLocalVar() == "" {
+			written[i] = destinationPath(targetName(m))
+		}
+	}
+	deps := make([][]int, len(ancestors))
+	hasDeps := false
+	for i, ancestor := range ancestors {
+		m, ok := ancestor.msg.(*mbp.FieldMapping)
+		if !ok {
+			continue
+		}
+		for _, path := range destinationReads(m) {
+			writers := []int{}
+			for j, names := range written {
+				if j != i && names != nil && (len(path) == 0 || matchUpToDiff(names, path) > 0) {
+					writers = append(writers, j)
+				}
+			}
+			if len(writers) > 0 && writers[0] > i { // not written yet
+				deps[i] = append(deps[i], writers...)
+				hasDeps = true
+			}
+		}
+	}
+	if !hasDeps {
+		return ancestors
+	}
+
+	ordered := make([]whistlerNode, 0, len(ancestors))
+	added := make([]bool, len(ancestors))
+	for len(ordered) < len(ancestors) {
+		next := -1
+		for i := range ancestors {
+			if !added[i] && allAdded(deps[i], added) {
+				next = i
+				break
+			}
+		}
+		if next < 0 { // the remaining mappings read each other's targets
+			for i := range ancestors {
+				if !added[i] {
+					ordered = append(ordered, ancestors[i])
+				}
+			}
+			break
+		}
+		added[next] = true
+		ordered = append(ordered, ancestors[next])
+	}
+	return ordered
+}
+
+func allAdded(ids []int, added []bool) bool {
+	for _, id := range ids {
+		if !added[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// targetName returns the name of the field, root field or object a mapping writes.
+func targetName(m *mbp.FieldMapping) string {
+	switch target := m.GetTarget().(type) {
+	case *mbp.FieldMapping_TargetField:
+		return target.TargetField
+	case *mbp.FieldMapping_TargetRootField:
+		return target.TargetRootField
+	case *mbp.FieldMapping_TargetObject:
+		return target.TargetObject
+	default:
+		return ""
+	}
+}
+
+// destinationReads returns the paths of the destinations a mapping reads in its value and
+// condition, as returned by destinationPath. Reads within the projectors it calls are in another
+// env, and aren't returned.
+func destinationReads(m *mbp.FieldMapping) [][]string {
+	paths := [][]string{}
+	var read func(source *mbp.ValueSource)
+	read = func(source *mbp.ValueSource) {
+		if source == nil {
+			return
+		}
+		switch s := source.GetSource().(type) {
+		case *mbp.ValueSource_FromDestination:
+			paths = append(paths, destinationPath(s.FromDestination))
+		case *mbp.ValueSource_ProjectedValue:
+			read(s.ProjectedValue)
+		}
+		for _, arg := range source.GetAdditionalArg() {
+			read(arg)
+		}
+	}
+	read(m.GetValueSource())
+	read(m.GetCondition())
+	return paths
+}
+
 func targetLineageFromGraph(node *TargetNode, g Graph) (targetLineage, error) {
 	lineage := targetLineage{
 		node:         node,
@@ -235,12 +599,16 @@ func targetLineageFromGraph(node *TargetNode, g Graph) (targetLineage, error) {
 
 // writeTargetLineage finds a target's children targets (and their lineages).
 // it looks through the graph for targets that are immediate ancestors of the node
-// and adds the ancestors' lineages to the node's
+// and adds the ancestors' lineages to the node's. The targets of the arguments of builtins that
+// keep their elements, like $Flatten, are children too.
 func writeTargetLineage(node Node, lineage *targetLineage, g Graph) error {
 	idList, ok := g.Edges[node.ID()]
 	if !ok {
 		return fmt.Errorf("couldn't find node %v in the graph", node)
 	}
+	if keepsElements(node) {
+		idList = append(append([]int{}, idList...), g.ArgumentEdges[node.ID()]...)
+	}
 
 	for _, ancestorID := range idList {
 		ancestor, ok := g.Nodes[ancestorID]
@@ -252,6 +620,9 @@ func writeTargetLineage(node Node, lineage *targetLineage, g Graph) error {
 			if !ok {
 				return fmt.Errorf("found a target {%v} in the lineage with no targetLineage associated; it should already have been generated", targetNode)
 			}
+			if isThis(targetNode.Name) && !targetNode.IsVariable { // its fields are written into the output
+				mergeTargetLineages(lineage.childTargets, childLineage.childTargets)
+			}
 			appendOrAddTargetLineage(lineage.childTargets, childLineage, targetNode.Name)
 		} else {
 			if err := writeTargetLineage(ancestor, lineage, g); err != nil {
@@ -270,6 +641,14 @@ func appendOrAddTargetLineage(childTargets map[string][]targetLineage, lineage t
 	}
 }
 
+func mergeTargetLineages(childTargets map[string][]targetLineage, lineages map[string][]targetLineage) {
+	for name, namedLineages := range lineages {
+		for _, lineage := range namedLineages {
+			appendOrAddTargetLineage(childTargets, lineage, name)
+		}
+	}
+}
+
 func appendOrAddID(idLists map[string][]int, id int, name string) {
 	if idList, ok := idLists[name]; ok {
 		idLists[name] = append(idList, id)
@@ -282,7 +661,7 @@ func getNode(wstlrNode whistlerNode, wstlrEnv *env) (Node, bool, error) {
 	if wstlrNode.nodeInGraph == nil {
 		node, err := newNode(wstlrNode.msg, wstlrEnv)
 		if err != nil {
-			return nil, true, fmt.Errorf("making a new for msg {%v} failed:\n%w", wstlrNode.msg, err)
+			return nil, true, fmt.Errorf("making a new node failed:\n%w", err)
 		}
 		return node, true, nil
 	} else {
@@ -299,7 +678,7 @@ func newNode(msg proto.Message, wstlrEnv *env) (Node, error) {
 	case *mbp.ProjectorDefinition:
 		return projectorNode(m, wstlrEnv), nil
 	default:
-		return nil, fmt.Errorf("interpreting whistler message %v failed; message type %T not supported", msg, msg)
+		return nil, &UnsupportedMessageError{ErrorInfo{Name: messageType(msg), Context: wstlrEnv.context()}}
 	}
 }
 
@@ -307,14 +686,14 @@ func targetNode(msg *mbp.FieldMapping, wstlrEnv *env) (*TargetNode, error) {
 	switch target := msg.GetTarget().(type) {
 	case *mbp.FieldMapping_TargetField:
 		return &TargetNode{
-			id:      newIncID(),
+			id:      wstlrEnv.newID(),
 			Name:    target.TargetField,
 			Context: wstlrEnv.name,
 			msg:     msg,
 		}, nil
 	case *mbp.FieldMapping_TargetLocalVar:
 		return &TargetNode{
-			id:         newIncID(),
+			id:         wstlrEnv.newID(),
 			Name:       target.TargetLocalVar,
 			msg:        msg,
 			Context:    wstlrEnv.name,
@@ -322,7 +701,7 @@ func targetNode(msg *mbp.FieldMapping, wstlrEnv *env) (*TargetNode, error) {
 		}, nil
 	case *mbp.FieldMapping_TargetRootField:
 		return &TargetNode{
-			id:      newIncID(),
+			id:      wstlrEnv.newID(),
 			Name:    target.TargetRootField,
 			msg:     msg,
 			Context: wstlrEnv.name,
@@ -330,14 +709,14 @@ func targetNode(msg *mbp.FieldMapping, wstlrEnv *env) (*TargetNode, error) {
 		}, nil
 	case *mbp.FieldMapping_TargetObject:
 		return &TargetNode{
-			id:      newIncID(),
+			id:      wstlrEnv.newID(),
 			Name:    target.TargetObject,
 			msg:     msg,
 			Context: wstlrEnv.name,
 			IsOut:   true,
 		}, nil
 	default:
-		return nil, fmt.Errorf("interpreting whistler message %v failed; type %T not supported", target, target)
+		return nil, &UnsupportedMessageError{ErrorInfo{Name: messageType(target), Context: wstlrEnv.context()}}
 	}
 }
 
@@ -354,13 +733,13 @@ func valueSourceNode(msg *mbp.ValueSource, wstlrEnv *env) (Node, error) {
 	case *mbp.ValueSource_FromInput:
 		return fromInputNode(m.FromInput, msg, wstlrEnv), nil
 	default:
-		return nil, fmt.Errorf("interpreting whistler message %v failed; type %T not supported", msg, m)
+		return nil, &UnsupportedMessageError{ErrorInfo{Name: messageType(m), Context: wstlrEnv.context()}}
 	}
 }
 
 func constBoolNode(msg *mbp.ValueSource_ConstBool, source *mbp.ValueSource, wstlrEnv *env) *ConstBoolNode {
 	return &ConstBoolNode{
-		id:      newIncID(),
+		id:      wstlrEnv.newID(),
 		Value:   msg.ConstBool,
 		Context: wstlrEnv.name,
 		msg:     source,
@@ -369,7 +748,7 @@ func constBoolNode(msg *mbp.ValueSource_ConstBool, source *mbp.ValueSource, wstl
 
 func constIntNode(msg *mbp.ValueSource_ConstInt, source *mbp.ValueSource, wstlrEnv *env) *ConstIntNode {
 	return &ConstIntNode{
-		id:      newIncID(),
+		id:      wstlrEnv.newID(),
 		Value:   int(msg.ConstInt),
 		Context: wstlrEnv.name,
 		msg:     source,
@@ -378,7 +757,7 @@ func constIntNode(msg *mbp.ValueSource_ConstInt, source *mbp.ValueSource, wstlrE
 
 func constFloatNode(msg *mbp.ValueSource_ConstFloat, source *mbp.ValueSource, wstlrEnv *env) *ConstFloatNode {
 	return &ConstFloatNode{
-		id:      newIncID(),
+		id:      wstlrEnv.newID(),
 		Value:   msg.ConstFloat,
 		Context: wstlrEnv.name,
 		msg:     source,
@@ -387,7 +766,7 @@ func constFloatNode(msg *mbp.ValueSource_ConstFloat, source *mbp.ValueSource, ws
 
 func constStringNode(msg *mbp.ValueSource_ConstString, source *mbp.ValueSource, wstlrEnv *env) *ConstStringNode {
 	return &ConstStringNode{
-		id:      newIncID(),
+		id:      wstlrEnv.newID(),
 		Value:   msg.ConstString,
 		Context: wstlrEnv.name,
 		msg:     source,
@@ -398,17 +777,19 @@ func fromInputNode(msg *mbp.ValueSource_InputSource, source *mbp.ValueSource, ws
 	index := int(msg.GetArg())
 	if index-1 == len(wstlrEnv.args) {
 		return &RootNode{
-			id:      newIncID(),
+			id:      wstlrEnv.newID(),
 			Field:   msg.GetField(),
 			Context: wstlrEnv.name,
 			msg:     source,
 		}
 	} else {
 		return &ArgumentNode{
-			id:      newIncID(),
-			Index:   int(msg.GetArg()),
+			id:      wstlrEnv.newID(),
+			Index:   index,
 			Field:   msg.GetField(),
 			Context: wstlrEnv.name,
+			Name:    wstlrEnv.paramName(index),
+			Binding: wstlrEnv.binding(index),
 			msg:     source,
 		}
 	}
@@ -417,10 +798,19 @@ func fromInputNode(msg *mbp.ValueSource_InputSource, source *mbp.ValueSource, ws
 func projectorNode(msg *mbp.ProjectorDefinition, wstlrEnv *env) *ProjectorNode {
 	var isBuiltin bool
 	_, isBuiltin = builtins.BuiltinFunctions[msg.GetName()]
+	isBuiltin = isBuiltin || isHarmonization(msg.GetName())
+	plugin, isPlugin := wstlrEnv.pluginOf(msg)
+	var semantics BuiltinSemantics
+	if isBuiltin {
+		semantics = wstlrEnv.semanticsOf(msg.GetName())
+	}
 	return &ProjectorNode{
-		id:        newIncID(),
+		id:        wstlrEnv.newID(),
 		Name:      msg.GetName(),
 		IsBuiltin: isBuiltin,
+		IsPlugin:  isPlugin,
+		IsLookup:  plugin.Lookup || isHarmonization(msg.GetName()),
+		Semantics: semantics,
 		Context:   wstlrEnv.name,
 		msg:       msg,
 	}
@@ -467,76 +857,70 @@ func addNode(g Graph, node Node, descendant Node, isArg bool, isCondition bool,
 		return fmt.Errorf("expected node %v to have a descendant %v in the graph, but it didn't", node, descendant)
 	}
 	graphToAppend[descendant.ID()] = append(ancestorList, node.ID())
-	if !isArg {
-		recursive, err := isRecursive(g, node)
-		if err != nil {
-			return fmt.Errorf("failed to check if graph %v is recursive", g)
-		}
-		if recursive {
-			return fmt.Errorf("adding node %v causes a recursive dependency in the graph", node)
-		}
-	}
 	return nil
 }
 
-func isRecursive(g Graph, newNode Node) (bool, error) {
-	previousAppearences := []int{}
-	for id, node := range g.Nodes {
-		if cmp.Equal(node.protoMsg(), newNode.protoMsg(), protocmp.Transform()) {
-			previousAppearences = append(previousAppearences, id)
-		}
-	}
-	ancestors := []int{}
-	for _, id := range previousAppearences {
-		ancestorsToAppend, ok := g.Edges[id]
-		if !ok {
-			return false, fmt.Errorf("couldn't find ID %v in the graph", id)
-		}
-		ancestors = append(ancestors, ancestorsToAppend...)
-	}
+// callStack holds the nodes whose lineage is being added, from the outermost mapping to the innermost.
+// A node is recursive if a node with the same message is already on the stack and derives from the
+// new node through primary or condition edges; arguments start a new chain, since a projector can
+// take a call to itself as an argument without recursing.
+// Messages are compared by hash first, and the hashes are cached by message.
+type callStack struct {
+	frames []callFrame
+	hashes map[proto.Message]uint64
+}
 
-	for _, startNode := range ancestors {
-		recursive, err := isRecursiveHelper(g, newNode.ID(), startNode)
-		if err != nil {
-			return false, err
-		}
-		if recursive {
-			return true, nil
-		}
-	}
-	return false, nil
+// callFrame is a node on the call stack. chained is false if the frames below it can't form a cycle
+// with the frames above it.
+type callFrame struct {
+	hash    uint64
+	msg     proto.Message
+	chained bool
 }
 
-func isRecursiveHelper(g Graph, newNodeID int, currNodeID int) (bool, error) {
-	currNode, ok := g.Nodes[currNodeID]
-	if !ok {
-		return false, fmt.Errorf("couldn't find ID %v in the graph", currNodeID)
-	}
-	newNode, ok := g.Nodes[newNodeID]
-	if !ok {
-		return false, fmt.Errorf("couldn't find ID %v in the graph", newNodeID)
-	}
-	if _, ok := currNode.(*ArgumentNode); ok {
-		return false, nil // can't form a cycle through an argument
-	}
+func (s *callStack) push(node Node, isArg bool) {
+	_, isArgNode := node.(*ArgumentNode)
+	s.frames = append(s.frames, callFrame{
+		hash:    s.hash(node.protoMsg()),
+		msg:     node.protoMsg(),
+		chained: !isArg && !isArgNode,
+	})
+}
 
-	if cmp.Equal(newNode.protoMsg(), currNode.protoMsg(), protocmp.Transform()) {
-		return true, nil
-	}
-	ancestors, ok := g.Edges[currNode.ID()]
-	if !ok {
-		return false, fmt.Errorf("couldn't find node %v in the Edges", currNode)
+func (s *callStack) pop() {
+	s.frames = s.frames[:len(s.frames)-1]
+}
+
+// isRecursive returns true if adding the node as an ancestor of the top of the stack creates a cycle.
+func (s *callStack) isRecursive(node Node) bool {
+	if _, ok := node.(*ArgumentNode); ok {
+		return false // can't form a cycle through an argument
 	}
-	for _, ancestor := range ancestors {
-		recursive, err := isRecursiveHelper(g, newNodeID, ancestor)
-		if err != nil {
-			return false, err
+	hash := s.hash(node.protoMsg())
+	for i := len(s.frames) - 1; i >= 0; i-- {
+		frame := s.frames[i]
+		if frame.hash == hash && proto.Equal(frame.msg, node.protoMsg()) {
+			return true
 		}
-		if recursive {
-			return true, nil
+		if !frame.chained {
+			return false
 		}
 	}
-	return false, nil
+	return false
+}
+
+func (s *callStack) hash(msg proto.Message) uint64 {
+	if hash, ok := s.hashes[msg]; ok {
+		return hash
+	}
+	buf := proto.NewBuffer(nil)
+	buf.SetDeterministic(true)
+	h := fnv.New64a()
+	if err := buf.Marshal(msg); err == nil {
+		h.Write(buf.Bytes())
+	} // messages that fail to marshal all hash alike, and are told apart by proto.Equal
+	s.hashes[msg] = h.Sum64()
+	return s.hashes[msg]
 }
 
 func getAllAncestors(wstlrNode whistlerNode, wstlrEnv *env, projectors map[string]*mbp.ProjectorDefinition) (ancestorCollection, error) {
@@ -544,40 +928,40 @@ func getAllAncestors(wstlrNode whistlerNode, wstlrEnv *env, projectors map[strin
 	case *mbp.FieldMapping:
 		allAncestors, err := fieldMappingAncestors(m, wstlrEnv, projectors)
 		if err != nil {
-			return ancestorCollection{}, fmt.Errorf("extracting ancestors from message %v failed with error: %w", wstlrNode.msg, err)
+			return ancestorCollection{}, fmt.Errorf("extracting ancestors from the mapping failed:\n%w", err)
 		}
 		return allAncestors, nil
 	case *mbp.ValueSource:
 		ancestors, err := valueSourceAncestors(m, wstlrEnv)
 		if err != nil {
-			return ancestorCollection{}, fmt.Errorf("extracting ancestors from message %v failed with error: %w", wstlrNode.msg, err)
+			return ancestorCollection{}, fmt.Errorf("extracting ancestors from the value failed:\n%w", err)
 		}
 		return ancestorCollection{mainAncestors: ancestors}, nil
 	case *mbp.ProjectorDefinition:
 		allAncestors, err := projectorAncestors(m, wstlrNode.projSource, wstlrEnv, projectors)
 		if err != nil {
-			return ancestorCollection{}, fmt.Errorf("failed to get projector ancestors for {%v}:\n%w", m, err)
+			return ancestorCollection{}, fmt.Errorf("failed to get the ancestors of projector %v:\n%w", m.GetName(), err)
 		}
 		return allAncestors, nil
 	default:
-		return ancestorCollection{}, fmt.Errorf("interpreting whistler message %v failed; type %T not supported", wstlrNode.msg, wstlrNode.msg)
+		return ancestorCollection{}, &UnsupportedMessageError{ErrorInfo{Name: messageType(wstlrNode.msg), Context: wstlrEnv.context()}}
 	}
 }
 
 func fieldMappingAncestors(msg *mbp.FieldMapping, wstlrEnv *env, projectors map[string]*mbp.ProjectorDefinition) (ancestorCollection, error) {
 	source := msg.GetValueSource()
 	if source == nil {
-		return ancestorCollection{}, fmt.Errorf("expected field mapping {%v} to have a source set", msg)
+		return ancestorCollection{}, &UnsupportedMessageError{ErrorInfo{Name: "FieldMapping without a value source", Context: wstlrEnv.context()}}
 	}
 
 	mainAncestors, err := whistlerNodesFromValueSource(source, wstlrEnv, true, projectors)
 	if err != nil {
-		return ancestorCollection{}, fmt.Errorf("failed to make wstlrNode for msg {%v}:\n%w", msg, err)
+		return ancestorCollection{}, fmt.Errorf("failed to read the value of the mapping:\n%w", err)
 	}
 
 	conditions, err := fieldMappingConditions(msg, wstlrEnv, projectors)
 	if err != nil {
-		return ancestorCollection{}, fmt.Errorf("failed to get conditions for msg {%v}:\n%w", msg, err)
+		return ancestorCollection{}, fmt.Errorf("failed to read the conditions of the mapping:\n%w", err)
 	}
 	return ancestorCollection{
 		mainAncestors: mainAncestors,
@@ -593,13 +977,13 @@ func fieldMappingConditions(msg *mbp.FieldMapping, wstlrEnv *env, projectors map
 	if rootCondition.GetProjector() == and_keyword { // skip the $And node and directly return its ancestors
 		conditions, err := projectorArgs(rootCondition, wstlrEnv, projectors)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get ancestors of the $And condition message {%v}:\n%w", rootCondition, err)
+			return nil, fmt.Errorf("failed to read the operands of the $And condition:\n%w", err)
 		}
 		return flatten(conditions), nil // the nested structure isn't important, since these aren't treated as indexed arguments
 	} else {
 		wstlrNodes, err := whistlerNodesFromValueSource(rootCondition, wstlrEnv, true, projectors)
 		if err != nil {
-			return nil, fmt.Errorf("failed to make a whistler node from condition {%v}:\n%w", rootCondition, err)
+			return nil, fmt.Errorf("failed to read the condition:\n%w", err)
 		}
 		return wstlrNodes, nil
 	}
@@ -626,11 +1010,11 @@ func valueSourceAncestors(msg *mbp.ValueSource, e *env) ([]whistlerNode, error)
 	case *mbp.ValueSource_FromInput:
 		ancestors, err := fromInputAncestor(m.FromInput, e)
 		if err != nil {
-			return nil, fmt.Errorf("getting ancestors for msg %v failed with error:\n%w", msg, err)
+			return nil, fmt.Errorf("getting the ancestors of the input failed:\n%w", err)
 		}
 		return ancestors, nil
 	default:
-		return nil, fmt.Errorf("interpreting whistler message %v failed; type %T not supported", m, m)
+		return nil, &UnsupportedMessageError{ErrorInfo{Name: messageType(m), Context: e.context()}}
 	}
 }
 
@@ -646,7 +1030,11 @@ func fromInputAncestor(msg *mbp.ValueSource_InputSource, e *env) ([]whistlerNode
 func argumentAncestor(msg *mbp.ValueSource_InputSource, e *env) ([]whistlerNode, error) {
 	index := int(msg.GetArg()) - 1 // whistler arguments are 1-based
 	if index < 0 || index >= len(e.args) {
-		return nil, fmt.Errorf("msg %v requested out-of-bounds argument %v from arguments %v", msg, index, e.args)
+		return nil, &ArgumentOutOfRangeError{
+			ErrorInfo: ErrorInfo{Path: msg.GetField(), Context: e.context()},
+			Index:     index + 1,
+			NumArgs:   len(e.args),
+		}
 	}
 
 	wstlrNodes := make([]whistlerNode, 0)
@@ -658,14 +1046,16 @@ func argumentAncestor(msg *mbp.ValueSource_InputSource, e *env) ([]whistlerNode,
 				projSource:  nil,
 				nodeInGraph: argNode.node,
 			})
+		} else if isInput(argNode.node) { // the fields of an input aren't in the graph, so a field is read from the input itself
+			wstlrNodes = append(wstlrNodes, whistlerNode{nodeInGraph: argNode.node})
 		} else { // the argument refers to a child of a target in the graph; we must search the graph for the child
-			path := strings.Split(strings.TrimLeft(msg.GetField(), "."), ".") // trim the leading "." and split into target names
+			path := fieldNames(msg.GetField()) // the target names, without the leading "." and array indices
 			if argNode.childTargets == nil {
-				return nil, fmt.Errorf("lineage for node %v was not cached", argNode)
+				return nil, fmt.Errorf("lineage for %v was not cached", Describe(argNode.node))
 			}
 			nodesInGraph, err := findNodesInGraph(path, argNode.node, argNode.childTargets)
 			if err != nil {
-				return nil, fmt.Errorf("failed to find node %v with path %v in the environment:\n%w", argNode.node, path, err)
+				return nil, fmt.Errorf("failed to find the field %v of %v:\n%w", msg.GetField(), Describe(argNode.node), err)
 			}
 			for _, node := range nodesInGraph {
 				wstlrNodes = append(wstlrNodes, whistlerNode{
@@ -679,15 +1069,38 @@ func argumentAncestor(msg *mbp.ValueSource_InputSource, e *env) ([]whistlerNode,
 	return wstlrNodes, nil
 }
 
+// isInput returns whether the node is the input, or an argument bound to it.
+func isInput(node Node) bool {
+	switch n := node.(type) {
+	case *RootNode:
+		return true
+	case *ArgumentNode:
+		return n.Index > 0
+	default:
+		return false
+	}
+}
+
+// isBinding returns whether the ancestor of an argument binds a summarised template's argument
+// to a call, in which case the binding already reads the argument's field.
+func isBinding(arg *ArgumentNode, ancestor Node) bool {
+	a, ok := ancestor.(*ArgumentNode)
+	return ok && a.Context == arg.Context
+}
+
 func projectorAncestors(msg *mbp.ProjectorDefinition, projValueSource *mbp.ValueSource, wstlrEnv *env, projectors map[string]*mbp.ProjectorDefinition) (ancestorCollection, error) {
 	mappings := projectorMappings(msg)
 	args, err := projectorArgs(projValueSource, wstlrEnv, projectors)
 	if err != nil {
-		return ancestorCollection{}, fmt.Errorf("adding arguments for projector {%v} failed:\n%w", msg, err)
+		return ancestorCollection{}, fmt.Errorf("adding arguments for projector %v failed:\n%w", msg.GetName(), err)
+	}
+	if plugin, ok := wstlrEnv.pluginOf(msg); ok && len(args) != plugin.NumArgs {
+		return ancestorCollection{}, &ArgumentCountError{ErrorInfo: ErrorInfo{Name: plugin.Name, Context: wstlrEnv.context()}, NumArgs: len(args), Want: plugin.NumArgs}
 	}
 	return ancestorCollection{
 		mainAncestors: mappings,
 		projectorArgs: args,
+		argTexts:      wstlrEnv.argumentTexts(projValueSource),
 	}, nil
 }
 
@@ -707,12 +1120,12 @@ func projectorArgs(projValueSource *mbp.ValueSource, wstlrEnv *env, projectors m
 	var err error
 	args[0], err = whistlerNodesFromValueSource(projValueSource, wstlrEnv, false, projectors)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get whistler node from message %v:\n%w", projValueSource, err)
+		return nil, fmt.Errorf("failed to read argument 1:\n%w", err)
 	}
 	for i, arg := range projValueSource.GetAdditionalArg() {
 		args[i+1], err = whistlerNodesFromValueSource(arg, wstlrEnv, false, projectors)
 		if err != nil {
-			return nil, fmt.Errorf("failed to process argument message %v:\n%w", arg, err)
+			return nil, fmt.Errorf("failed to read argument %v:\n%w", i+2, err)
 		}
 	}
 	return args, nil
@@ -724,7 +1137,7 @@ func whistlerNodesFromValueSource(source *mbp.ValueSource, wstlrEnv *env, fromMa
 	if projName := source.GetProjector(); fromMapping && projName != "" {
 		projDef, ok := projectors[projName]
 		if !ok {
-			return nil, fmt.Errorf("projector %v could not be found", projName)
+			return nil, &UnknownProjectorError{ErrorInfo{Name: projName, Context: wstlrEnv.context()}}
 		}
 		return []whistlerNode{whistlerNode{
 			msg:        projDef,
@@ -734,9 +1147,9 @@ func whistlerNodesFromValueSource(source *mbp.ValueSource, wstlrEnv *env, fromMa
 
 	switch msg := source.GetSource().(type) {
 	case *mbp.ValueSource_FromDestination:
-		nodesInGraph, err := findNodesInGraph(strings.Split(msg.FromDestination, "."), nil, wstlrEnv.targets)
+		nodesInGraph, err := readDestFromEnv(destinationPath(msg.FromDestination), wstlrEnv)
 		if err != nil {
-			return nil, fmt.Errorf("failed to find dest target %v in the environment:\n%w", msg.FromDestination, err)
+			return nil, &UnresolvedDestinationError{ErrorInfo{Name: strings.Split(msg.FromDestination, ".")[0], Path: msg.FromDestination, Context: wstlrEnv.context()}}
 		}
 
 		wstlrNodes := make([]whistlerNode, len(nodesInGraph))
@@ -748,9 +1161,9 @@ func whistlerNodesFromValueSource(source *mbp.ValueSource, wstlrEnv *env, fromMa
 		}
 		return wstlrNodes, nil
 	case *mbp.ValueSource_FromLocalVar:
-		nodesInGraph, err := findNodesInGraph(strings.Split(msg.FromLocalVar, "."), nil, wstlrEnv.vars)
+		nodesInGraph, err := readVarFromEnv(fieldNames(msg.FromLocalVar), wstlrEnv)
 		if err != nil {
-			return nil, fmt.Errorf("failed to find local variable %v in the environment:\n%w", msg.FromLocalVar, err)
+			return nil, &UnresolvedLocalVarError{ErrorInfo{Name: strings.Split(msg.FromLocalVar, ".")[0], Path: msg.FromLocalVar, Context: wstlrEnv.context()}}
 		}
 
 		wstlrNodes := make([]whistlerNode, len(nodesInGraph))
@@ -763,7 +1176,7 @@ func whistlerNodesFromValueSource(source *mbp.ValueSource, wstlrEnv *env, fromMa
 		return wstlrNodes, nil
 	case *mbp.ValueSource_ProjectedValue:
 		if msg.ProjectedValue == nil {
-			return nil, fmt.Errorf("expected projected value source {%v} to have a projected value, but it did not", msg)
+			return nil, &UnsupportedMessageError{ErrorInfo{Name: "ProjectedValue without a value", Context: wstlrEnv.context()}}
 		}
 
 		// in condition else blocks, the argument is always a ProjectedValue even if it has no projector
@@ -773,7 +1186,7 @@ func whistlerNodesFromValueSource(source *mbp.ValueSource, wstlrEnv *env, fromMa
 
 		projDef, ok := projectors[msg.ProjectedValue.GetProjector()]
 		if !ok {
-			return nil, fmt.Errorf("failed to look up projector '%v'", msg.ProjectedValue.GetProjector())
+			return nil, &UnknownProjectorError{ErrorInfo{Name: msg.ProjectedValue.GetProjector(), Context: wstlrEnv.context()}}
 		}
 		return []whistlerNode{whistlerNode{
 			msg:        projDef,
@@ -784,14 +1197,69 @@ func whistlerNodesFromValueSource(source *mbp.ValueSource, wstlrEnv *env, fromMa
 	}
 }
 
-func readVarFromEnv(varName string, e *env) ([]targetLineage, error) {
-	if targetLineages, ok := e.vars[varName]; ok {
-		return targetLineages, nil
-	} else if e.parent != nil {
-		return readVarFromEnv(varName, e.parent)
-	} else {
-		return nil, fmt.Errorf("couldn't find the local variable '%v' in the environment", varName)
+// destinationPath returns the field names of a destination read, without array indices.
+func destinationPath(path string) []string {
+	return fieldNames(trimThis(path))
+}
+
+// trimThis removes a leading $this from a destination path, since it refers to the output of the
+// enclosing projector, like an empty path.
+func trimThis(path string) string {
+	if path == this_keyword || strings.HasPrefix(path, this_keyword+".") {
+		return strings.TrimPrefix(path, this_keyword)
+	}
+	return path
+}
+
+// readDestFromEnv returns the targets written to a path of the output of the env, or, in an
+// anonymous block that doesn't write the path's first field, of the output of the projector
+// enclosing it. An empty path reads every target of the output.
+func readDestFromEnv(path []string, e *env) ([]Node, error) {
+	if len(path) == 0 {
+		nodes := []Node{}
+		for _, lineages := range e.targets {
+			for _, lineage := range lineages {
+				nodes = append(nodes, lineage.node)
+			}
+		}
+		if len(nodes) > 0 {
+			sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID() < nodes[j].ID() })
+			return nodes, nil
+		}
+	} else if declares(e.targets, path[0]) {
+		return findNodesInGraph(path, nil, e.targets)
+	}
+	if e.parent != nil {
+		return readDestFromEnv(path, e.parent)
 	}
+	return nil, fmt.Errorf("couldn't find path %v in the environment", path)
+}
+
+// readVarFromEnv returns the targets of a path of a variable. Like in the mapping engine, an
+// anonymous block sees the variables of the envs enclosing it, and a variable it writes shadows
+// one of the same name in them.
+func readVarFromEnv(path []string, e *env) ([]Node, error) {
+	for scope := e; scope != nil; scope = scope.parent {
+		if len(path) > 0 && declares(scope.vars, path[0]) {
+			return findNodesInGraph(path, nil, scope.vars)
+		}
+	}
+	return nil, fmt.Errorf("couldn't find path %v in the environment", path)
+}
+
+// declares returns whether a target or variable of the given name is written in the lineages.
+func declares(lineages map[string][]targetLineage, name string) bool {
+	for targetName := range lineages {
+		if names := fieldNames(targetName); len(names) > 0 && names[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isThis returns whether a target is the whole output of its projector, such as "$this" or ".".
+func isThis(name string) bool {
+	return len(destinationPath(name)) == 0
 }
 
 // return previously-generated nodes in the graph based on a path of target names
@@ -804,7 +1272,7 @@ func findNodesInGraph(path []string, currNode Node, lineages map[string][]target
 	}
 	matchingNodes := make([]Node, 0)
 	for targetName, lineages := range lineages {
-		numMatchingNodes := matchUpToDiff(strings.Split(targetName, "."), path)
+		numMatchingNodes := matchUpToDiff(fieldNames(targetName), path)
 		if numMatchingNodes > 0 {
 			for _, childLineage := range lineages {
 				nodes, _ := findNodesInGraph(path[numMatchingNodes:], childLineage.node, childLineage.childTargets)
@@ -0,0 +1,46 @@
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+)
+
+// syntheticMapping makes a mapping with numTargets root targets, each calling a chain of depth nested projectors.
+func syntheticMapping(numTargets int, depth int) *mbp.MappingConfig {
+	projectors := []*mbp.ProjectorDefinition{
+		makeProjDefMsg("proj0", []*mbp.FieldMapping{makeMappingMsg("y", makeArgMsg(1, ""), nil)}),
+	}
+	for i := 1; i < depth; i++ {
+		next := makeProjSourceMsg(fmt.Sprintf("proj%v", i-1), makeArgMsg(1, ""), nil)
+		projectors = append(projectors, makeProjDefMsg(fmt.Sprintf("proj%v", i), []*mbp.FieldMapping{makeMappingMsg("y", next, nil)}))
+	}
+	mappings := make([]*mbp.FieldMapping, numTargets)
+	for i := range mappings {
+		value := makeProjSourceMsg(fmt.Sprintf("proj%v", depth-1), makeIntMsg(i), nil)
+		mappings[i] = makeMappingMsg(fmt.Sprintf("target%v", i), value, nil)
+	}
+	return makeMappingConfigMsg(projectors, mappings)
+}
+
+func BenchmarkNew(b *testing.B) {
+	for _, summarise := range []bool{false, true} {
+		for _, depth := range []int{1, 10} {
+			for _, numTargets := range []int{100, 1000, 5000} {
+				mpc := syntheticMapping(numTargets, depth)
+				b.Run(fmt.Sprintf("summarise=%v/targets=%v/depth=%v", summarise, numTargets, depth), func(b *testing.B) {
+					var numNodes int
+					for i := 0; i < b.N; i++ {
+						g, err := NewWithOptions(mpc, Options{Summarise: summarise})
+						if err != nil {
+							b.Fatalf("building graph failed:\n%v", err)
+						}
+						numNodes = len(g.Nodes)
+					}
+					b.ReportMetric(float64(numNodes), "nodes")
+				})
+			}
+		}
+	}
+}
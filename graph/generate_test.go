@@ -1,6 +1,11 @@
 package graph
 
 import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
@@ -552,6 +557,36 @@ func TestNew_Whistle(t *testing.T) {
 	}
 }
 
+func TestNew_Concurrent(t *testing.T) {
+	mpc := makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+		makeMappingMsg("x", makeBoolMsg(true), nil),
+		makeMappingMsg("y", makeIntMsg(1), nil),
+	})
+	const numGraphs = 20
+	graphs := make([]Graph, numGraphs)
+	errs := make([]error, numGraphs)
+	var wg sync.WaitGroup
+	for i := 0; i < numGraphs; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			graphs[i], errs[i] = New(mpc)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, g := range graphs {
+		if errs[i] != nil {
+			t.Fatalf("building graph %v failed:\n%v", i, errs[i])
+		}
+		for id := 0; id < len(g.Nodes); id++ {
+			if _, ok := g.Nodes[id]; !ok {
+				t.Errorf("expected graph %v to number its nodes from 0, but node %v is missing; %v", i, id, g)
+			}
+		}
+	}
+}
+
 /*
 func TestNew_WhistlerProto(t *testing.T) {
 	tests := []struct {
@@ -773,6 +808,358 @@ func TestNew_WhistlerProto(t *testing.T) {
 }
 */
 
+func TestNewWithOptions_Summarise(t *testing.T) {
+	helper := makeProjDefMsg("helper", []*mbp.FieldMapping{makeMappingMsg("y", makeArgMsg(1, ""), nil)})
+	mpc := makeMappingConfigMsg([]*mbp.ProjectorDefinition{helper}, []*mbp.FieldMapping{
+		makeMappingMsg("x1", makeProjSourceMsg("helper", makeIntMsg(1), nil), nil),
+		makeMappingMsg("x2", makeProjSourceMsg("helper", makeIntMsg(2), nil), nil),
+		makeMappingMsg("x3", makeProjSourceMsg("helper", makeIntMsg(3), nil), nil),
+	})
+	tests := []struct {
+		name          string
+		summarise     bool
+		wantTargets   int
+		wantArguments int
+		// wantSources gives the constants each output derives from, by the constant it is called with
+		wantSources map[int][]int
+	}{
+		{
+			name:          "inlined",
+			summarise:     false,
+			wantTargets:   3,
+			wantArguments: 3,
+			wantSources:   map[int][]int{1: {1}, 2: {2}, 3: {3}},
+		},
+		{
+			name:          "summarised",
+			summarise:     true,
+			wantTargets:   1,
+			wantArguments: 4, // the template's argument and one binding per call
+			// the shared template merges the bindings of every call
+			wantSources: map[int][]int{1: {1, 2, 3}, 2: {1, 2, 3}, 3: {1, 2, 3}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := NewWithOptions(mpc, Options{Summarise: test.summarise})
+			if err != nil {
+				t.Fatalf("building graph failed:\n%v", err)
+			}
+			targets, arguments := []int{}, 0
+			for id, node := range g.Nodes {
+				switch n := node.(type) {
+				case *TargetNode:
+					if n.Name == "y" {
+						targets = append(targets, id)
+					}
+				case *ArgumentNode:
+					arguments++
+				}
+			}
+			if len(targets) != test.wantTargets {
+				t.Errorf("expected %v expansions of the projector body, but got %v; %v", test.wantTargets, len(targets), g)
+			}
+			if arguments != test.wantArguments {
+				t.Errorf("expected %v argument nodes, but got %v; %v", test.wantArguments, arguments, g)
+			}
+			constValues := func(ids []int) []int {
+				values := []int{}
+				for _, id := range ids {
+					if c, ok := g.Nodes[id].(*ConstIntNode); ok {
+						values = append(values, c.Value)
+					}
+				}
+				sort.Ints(values)
+				return values
+			}
+			gotSources := map[int][]int{}
+			for name, ids := range g.Outputs() {
+				call, err := strconv.Atoi(strings.TrimPrefix(name, "x"))
+				if err != nil {
+					t.Fatalf("unexpected output %v", name)
+				}
+				gotSources[call] = constValues(g.Upstream(ids[0], false))
+
+				// whether summarised or not, the call binds its own argument only
+				projID := g.Edges[ids[0]][0]
+				bindings := []int{}
+				for _, id := range g.ArgumentEdges[projID] {
+					bindings = append(bindings, append(g.Upstream(id, false), id)...)
+				}
+				if diff := cmp.Diff([]int{call}, constValues(bindings)); diff != "" {
+					t.Errorf("unexpected arguments bound by the call of %v (-want +got):\n%v", name, diff)
+				}
+			}
+			if diff := cmp.Diff(test.wantSources, gotSources); diff != "" {
+				t.Errorf("unexpected sources of the outputs (-want +got):\n%v", diff)
+			}
+		},
+		)
+	}
+}
+
+func TestNew_FieldOfInputArgument(t *testing.T) {
+	// def F(a) {
+	//   y: a.name
+	// }
+	// def G(a) {
+	//   z: F(a)
+	// }
+	// def H() {
+	//   name: 1
+	// }
+	f := makeProjDefMsg("F", []*mbp.FieldMapping{makeMappingMsg("y", makeArgMsg(1, ".name"), nil)})
+	g := makeProjDefMsg("G", []*mbp.FieldMapping{makeMappingMsg("z", makeProjSourceMsg("F", makeArgMsg(1, ""), nil), nil)})
+	h := makeProjDefMsg("H", []*mbp.FieldMapping{makeMappingMsg("name", makeIntMsg(1), nil)})
+	tests := []struct {
+		name     string
+		mappings []*mbp.FieldMapping
+		want     []string // the first ancestors from y down
+	}{
+		{
+			name: "field of an input passed as argument",
+			// x: F($root.patient)
+			mappings: []*mbp.FieldMapping{makeMappingMsg("x", makeProjSourceMsg("F", makeArgMsg(1, ".patient"), nil), nil)},
+			want:     []string{"target y", "argument 1.name", "$root.patient"},
+		},
+		{
+			name: "field of an input passed on by another projector",
+			// x: G($root.patient)
+			mappings: []*mbp.FieldMapping{makeMappingMsg("x", makeProjSourceMsg("G", makeArgMsg(1, ".patient"), nil), nil)},
+			want:     []string{"target y", "argument 1.name", "argument 1", "$root.patient"},
+		},
+		{
+			name: "field of a target passed as argument",
+			// var v: H()
+			// x: F(v)
+			mappings: []*mbp.FieldMapping{
+				makeVarMappingMsg("v", makeProjValMsg("H"), nil),
+				makeMappingMsg("x", makeProjSourceMsg("F", makeLocalVarMsg("v"), nil), nil),
+			},
+			want: []string{"target y", "argument 1.name", "target name", "1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			graph, err := New(makeMappingConfigMsg([]*mbp.ProjectorDefinition{f, g, h}, test.mappings))
+			if err != nil {
+				t.Fatalf("building graph failed:\n%v", err)
+			}
+			ids := []int{}
+			for id, node := range graph.Nodes {
+				if target, ok := node.(*TargetNode); ok && target.Name == "y" {
+					ids = append(ids, id)
+				}
+			}
+			if len(ids) != 1 {
+				t.Fatalf("expected one target y, but got %v; %v", ids, graph)
+			}
+			got := []string{}
+			for id := ids[0]; ; id = graph.Edges[id][0] {
+				if c, ok := graph.Nodes[id].(*ConstIntNode); ok {
+					got = append(got, fmt.Sprint(c.Value))
+				} else {
+					got = append(got, Describe(graph.Nodes[id]))
+				}
+				if len(graph.Edges[id]) == 0 {
+					break
+				}
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("unexpected lineage of y (-want +got):\n%v", diff)
+			}
+		},
+		)
+	}
+}
+
+func TestNewWithOptions_Tolerant(t *testing.T) {
+	recursive := makeProjDefMsg("recursive", []*mbp.FieldMapping{makeMappingMsg("z", makeProjValMsg("recursive"), nil)})
+	tests := []struct {
+		name     string
+		mpc      *mbp.MappingConfig
+		wantKind DiagnosticKind
+	}{
+		{
+			name: "unknown projector",
+			mpc: makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+				makeMappingMsg("x", makeProjValMsg("missing"), nil),
+				makeMappingMsg("y", makeIntMsg(1), nil),
+			}),
+			wantKind: UnresolvedReference,
+		},
+		{
+			name: "unsupported value",
+			mpc: makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+				makeMappingMsg("x", &mbp.ValueSource{Source: &mbp.ValueSource_FromSource{FromSource: "a"}}, nil),
+				makeMappingMsg("y", makeIntMsg(1), nil),
+			}),
+			wantKind: UnsupportedConstruct,
+		},
+		{
+			name: "recursion",
+			mpc: makeMappingConfigMsg([]*mbp.ProjectorDefinition{recursive}, []*mbp.FieldMapping{
+				makeMappingMsg("x", makeProjValMsg("recursive"), nil),
+				makeMappingMsg("y", makeIntMsg(1), nil),
+			}),
+			wantKind: Recursion,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := New(test.mpc); err == nil {
+				t.Errorf("expected error building graph outside of tolerant mode")
+			}
+
+			g, err := NewWithOptions(test.mpc, Options{Tolerant: true})
+			if err != nil {
+				t.Fatalf("building graph in tolerant mode failed:\n%v", err)
+			}
+			if len(g.Diagnostics) != 1 {
+				t.Fatalf("expected 1 diagnostic, but got %v", g.Diagnostics)
+			}
+			diagnostic := g.Diagnostics[0]
+			if diagnostic.Kind != test.wantKind {
+				t.Errorf("expected a diagnostic of kind %v, but got %v", test.wantKind, diagnostic)
+			}
+			if _, ok := g.Nodes[diagnostic.NodeID].(*UnknownNode); !ok {
+				t.Errorf("expected diagnostic %v to point at an unknown node, but got %v", diagnostic, g.Nodes[diagnostic.NodeID])
+			}
+			for _, name := range []string{"x", "y"} {
+				if _, ok := g.Outputs()[name]; !ok {
+					t.Errorf("expected target %v to be in the graph; %v", name, g)
+				}
+			}
+		},
+		)
+	}
+}
+
+func TestNew_Destination(t *testing.T) {
+	tests := []struct {
+		name     string
+		mpc      *mbp.MappingConfig
+		wantRead map[string][]string // the targets each target reading a destination links to, as context.name
+		wantErr  bool
+	}{
+		{
+			name: "array suffixes",
+			mpc: makeMappingConfigMsg(
+				[]*mbp.ProjectorDefinition{
+					makeProjDefMsg("proj1", []*mbp.FieldMapping{makeMappingMsg("b", makeIntMsg(1), nil)}),
+				},
+				[]*mbp.FieldMapping{
+					makeMappingMsg("a[]", makeProjValMsg("proj1"), nil),
+					makeMappingMsg("c[0]", makeIntMsg(2), nil),
+					makeMappingMsg("x", makeDestValSourceMsg("a[0].b"), nil),
+					makeMappingMsg("y", makeDestValSourceMsg("c"), nil),
+				}),
+			wantRead: map[string][]string{
+				"root.x": {"proj1.b"},
+				"root.y": {"root.c[0]"},
+			},
+		},
+		{
+			name: "written after the read",
+			mpc: makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+				makeMappingMsg("x", makeDestValSourceMsg("y"), nil),
+				makeMappingMsg("y", makeDestValSourceMsg("z"), nil),
+				makeMappingMsg("z", makeIntMsg(1), nil),
+			}),
+			wantRead: map[string][]string{
+				"root.x": {"root.y"},
+				"root.y": {"root.z"},
+			},
+		},
+		{
+			name: "written before and after the read",
+			mpc: makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+				makeMappingMsg("y", makeIntMsg(1), nil),
+				makeMappingMsg("x", makeDestValSourceMsg("y"), nil),
+				makeMappingMsg("y", makeIntMsg(2), nil),
+			}),
+			wantRead: map[string][]string{
+				"root.x": {"root.y"},
+			},
+		},
+		{
+			name: "$this",
+			mpc: makeMappingConfigMsg(
+				[]*mbp.ProjectorDefinition{
+					makeProjDefMsg("proj1", []*mbp.FieldMapping{
+						makeMappingMsg("all", makeDestValSourceMsg("$this"), nil),
+						makeMappingMsg("a", makeIntMsg(1), nil),
+						makeMappingMsg("b", makeDestValSourceMsg("$this.a"), nil),
+					}),
+				},
+				[]*mbp.FieldMapping{
+					makeMappingMsg("x", makeProjValMsg("proj1"), nil),
+				}),
+			wantRead: map[string][]string{
+				"proj1.all": {"proj1.a", "proj1.b"},
+				"proj1.b":   {"proj1.a"},
+			},
+		},
+		{
+			name: "enclosing projector's output",
+			mpc: makeMappingConfigMsg(
+				[]*mbp.ProjectorDefinition{
+					makeProjDefMsg(anon_prefix+"1", []*mbp.FieldMapping{
+						makeMappingMsg("y", makeDestValSourceMsg("a"), nil),
+					}),
+				},
+				[]*mbp.FieldMapping{
+					makeMappingMsg("a", makeIntMsg(1), nil),
+					makeMappingMsg("x", makeProjValMsg(anon_prefix+"1"), nil),
+				}),
+			wantRead: map[string][]string{
+				anon_prefix + "1.y": {"root.a"},
+			},
+		},
+		{
+			name: "unresolved",
+			mpc: makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+				makeMappingMsg("x", makeDestValSourceMsg("y"), nil),
+			}),
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := New(test.mpc)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected error building graph")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("building graph failed:\n%v", err)
+			}
+			read := map[string][]string{}
+			for id, node := range g.Nodes {
+				target, ok := node.(*TargetNode)
+				if !ok {
+					continue
+				}
+				for _, ancestorID := range g.Edges[id] {
+					if ancestor, ok := g.Nodes[ancestorID].(*TargetNode); ok {
+						key := target.Context + "." + target.Name
+						read[key] = append(read[key], ancestor.Context+"."+ancestor.Name)
+					}
+				}
+			}
+			for key := range read {
+				sort.Strings(read[key])
+			}
+			if diff := cmp.Diff(test.wantRead, read); diff != "" {
+				t.Errorf("unexpected destination reads (-want +got):\n%v", diff)
+			}
+		},
+		)
+	}
+}
+
 func TestAddArgLineages(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -937,8 +1324,8 @@ func TestAddArgLineages(t *testing.T) {
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			setIncID(test.startID)
-			e, err := test.graph.addArgLineages(test.args, test.e, test.projNode, test.projectors)
+			test.e.ids = &idGenerator{next: test.startID}
+			e, err := test.graph.addArgLineages(test.args, nil, test.e, test.projNode, test.projectors)
 			if test.wantErrors && err == nil {
 				t.Errorf("expected error getting argument lineages")
 			} else if !test.wantErrors && err != nil {
@@ -1185,80 +1572,81 @@ func TestAddNode(t *testing.T) {
 	}
 }
 
-func TestIsRecursive(t *testing.T) {
+func TestCallStackIsRecursive(t *testing.T) {
+	type frame struct {
+		node  Node
+		isArg bool
+	}
 	tests := []struct {
-		name       string
-		graph      Graph
-		node       Node
-		want       bool
-		wantErrors bool
+		name  string
+		stack []frame
+		node  Node
+		want  bool
 	}{
 		{
 			name: "not recursive",
-			graph: Graph{
-				Edges: map[int][]int{
-					0: ids1(1),
-					1: ids0(),
-				},
-				Nodes: map[int]Node{
-					0: makeTargetNode("x", "root", 0),
-					1: makeBoolNode(true, "root", 1),
-				},
+			stack: []frame{
+				{node: makeTargetNode("x", "root", 0)},
 			},
-			node:       makeBoolNode(true, "root", 1),
-			want:       false,
-			wantErrors: false,
+			node: makeBoolNode(true, "root", 1),
+			want: false,
 		},
 		{
 			name: "recursive",
-			graph: Graph{
-				Edges: map[int][]int{
-					0: ids1(1), // foo() -> x
-					1: ids1(2), // x -> bar()
-					2: ids1(3), // bar() -> yn
-					3: ids1(1), // y -> foo'()
-					4: ids1(0), // foo'()
-				},
-				Nodes: map[int]Node{
-					0: makeProjNode("foo", "root", 0),
-					1: makeTargetNode("x", "foo", 1),
-					2: makeProjNode("bar", "foo", 2),
-					3: makeTargetNode("y", "bar", 3),
-					4: makeProjNode("foo", "bar", 4),
-				},
+			stack: []frame{
+				{node: makeProjNode("foo", "root", 0)},
+				{node: makeTargetNode("x", "foo", 1)},
+				{node: makeProjNode("bar", "foo", 2)},
+				{node: makeTargetNode("y", "bar", 3)},
 			},
-			node:       makeProjNode("foo", "bar", 4),
-			want:       true,
-			wantErrors: false,
+			node: makeProjNode("foo", "bar", 4),
+			want: true,
 		},
 		{
-			name: "badly-formed graph",
-			graph: Graph{
-				Edges: map[int][]int{},
-				Nodes: map[int]Node{
-					0: makeBoolNode(true, "root", 0),
-				},
+			name: "projector taking a call to itself as an argument",
+			stack: []frame{
+				{node: makeProjNode("foo", "root", 0)},
+				{node: makeProjNode("bar", "root", 1), isArg: true},
 			},
-			node:       makeBoolNode(true, "root", 0),
-			wantErrors: true,
+			node: makeProjNode("foo", "bar", 2),
+			want: false,
+		},
+		{
+			name: "recursive inside an argument",
+			stack: []frame{
+				{node: makeProjNode("foo", "root", 0)},
+				{node: makeProjNode("bar", "root", 1), isArg: true},
+				{node: makeTargetNode("y", "bar", 2)},
+			},
+			node: makeProjNode("bar", "bar", 3),
+			want: true,
+		},
+		{
+			name: "through an argument node",
+			stack: []frame{
+				{node: makeProjNode("foo", "root", 0)},
+				{node: makeArgNode(1, "", "foo", 1)},
+			},
+			node: makeProjNode("foo", "foo", 2),
+			want: false,
+		},
+		{
+			name: "argument node",
+			stack: []frame{
+				{node: makeArgNode(1, "", "foo", 0)},
+			},
+			node: makeArgNode(1, "", "foo", 1),
+			want: false,
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			recursive, err := isRecursive(test.graph, test.node)
-			if test.wantErrors && err == nil {
-				t.Errorf("expected errors checking graph {%v}", test.graph)
-			} else if !test.wantErrors && err != nil {
-				t.Errorf("checking graph {%v} failed:\n%w", test.graph, err)
+			e := &env{}
+			for _, f := range test.stack {
+				e.callStack().push(f.node, f.isArg)
 			}
-
-			if !test.wantErrors {
-				if !test.want && recursive {
-					t.Errorf("expected graph %v to be recursive", test.graph)
-				}
-				if test.want && !recursive {
-					t.Errorf("didn't expect graph %v to be recursive", test.graph)
-				}
+			if got := e.callStack().isRecursive(test.node); got != test.want {
+				t.Errorf("expected isRecursive(%v) to be %v, but got %v", test.node, test.want, got)
 			}
 		},
 		)
@@ -12,12 +12,31 @@ import (
 // For projector argument edges, it contains the ArgumentEdges adjacency list.
 // It also contains a lookup dictionary of all nodes in the graph.
 type Graph struct {
-	Edges             map[int][]int
-	ArgumentEdges     map[int][]int
+	Edges         map[int][]int
+	ArgumentEdges map[int][]int
+	// ArgumentSlots holds the 1-based call-site argument each of a call's ArgumentEdges fills, in
+	// the same order. An argument read from a variable written several times fills a slot with
+	// several nodes.
+	ArgumentSlots     map[int][]int
 	ConditionEdges    map[int][]int
 	RootAndOutTargets map[string][]int
 	Nodes             map[int]Node
-	targetLineages    map[int]targetLineage
+	// Diagnostics lists the problems worked around when the graph was built in tolerant mode, and
+	// the fields missing from its schemas.
+	Diagnostics []Diagnostic
+	// Labels holds the sensitivity labels reaching each labelled node, if labels were applied.
+	Labels map[int]NodeLabels
+	// Values holds the value of each node of an instance graph, traced from a run of the mapping.
+	Values map[int]interface{}
+	// Provenance holds the input fields each output field of a traced run was computed from, by
+	// their paths without array indices. It is tracked from the values of the run rather than from
+	// the nodes, and isn't saved in protobufs.
+	Provenance map[string][]string
+	// Samples holds the values of each $root input field in the sample inputs, and Branches how
+	// often the conditions of each guarded target are met on them, if samples were applied.
+	Samples        map[int]FieldSamples
+	Branches       map[int]BranchCounts
+	targetLineages map[int]targetLineage
 }
 
 func (g Graph) String() string {
@@ -53,23 +72,14 @@ func (g Graph) String() string {
 	return strings.Join(nodeStrings, "\n")
 }
 
-var autoIncID *int
-
-func setIncID(id int) {
-	if autoIncID == nil {
-		autoIncID = &id
-	} else {
-		*autoIncID = id
-	}
+// idGenerator allocates increasing node IDs. Each graph has its own, so graphs can be built concurrently.
+type idGenerator struct {
+	next int
 }
 
-func newIncID() int {
-	if autoIncID == nil {
-		startID := 0
-		autoIncID = &startID
-	}
-	id := *autoIncID
-	*autoIncID++
+func (ids *idGenerator) newID() int {
+	id := ids.next
+	ids.next++
 	return id
 }
 
@@ -84,11 +94,13 @@ type FileMetaData struct {
 
 /*
 Node represents a node in the lineage graph. Implementations are:
- * TargetNode
- * ConstNodes (ConstBoolNode, ConstStringNode, ConstIntNode, ConstFloatNode)
- * ProjectorNode
- * ArgumentNode
- * RootNode
+  - TargetNode
+  - ConstNodes (ConstBoolNode, ConstStringNode, ConstIntNode, ConstFloatNode)
+  - ProjectorNode
+  - ArgumentNode
+  - RootNode
+  - ConceptMapNode, a concept map or lookup table read by a plugin or harmonization builtin
+  - UnknownNode, a placeholder used in tolerant mode
 */
 type Node interface {
 	ID() int
@@ -108,7 +120,9 @@ type TargetNode struct {
 	IsRoot      bool
 	IsOut       bool
 	FileData    FileMetaData
-	msg         proto.Message
+	// Schema is the output schema element the target writes, if the graph was given an output schema.
+	Schema SchemaElement
+	msg    proto.Message
 }
 
 // ID returns the node ID
@@ -230,6 +244,9 @@ type ProjectorNode struct {
 	Name      string
 	Context   string
 	IsBuiltin bool
+	IsPlugin  bool             // a Go projector given in Options.Plugins
+	IsLookup  bool             // a plugin or harmonization builtin looking its arguments up in an external table
+	Semantics BuiltinSemantics // how the value of a builtin relates to its arguments
 	FileData  FileMetaData
 	msg       proto.Message
 }
@@ -255,6 +272,8 @@ type ArgumentNode struct {
 	Index    int
 	Field    string
 	Context  string
+	Name     string // the declared name of the parameter, if the projector's source is known
+	Binding  string // the text of the call-site argument bound to the parameter, such as $root.subject
 	FileData FileMetaData
 	msg      proto.Message
 }
@@ -279,7 +298,9 @@ type RootNode struct {
 	Field    string
 	Context  string
 	FileData FileMetaData
-	msg      proto.Message
+	// Schema is the input schema element of the field, if the graph was given an input schema.
+	Schema SchemaElement
+	msg    proto.Message
 }
 
 // ID returns the node ID
@@ -299,6 +320,57 @@ func (n *RootNode) Equals(n2 Node) bool {
 	return false
 }
 
+// ConceptMapNode is a concept map or lookup table read by a call to the plugin or harmonization
+// builtin Projector, such as the code translations of OMOP and FHIR mappings. Map is the file the
+// table is read from, if the mapping names it with a constant string.
+type ConceptMapNode struct {
+	id        int
+	Map       string
+	Projector string
+	Context   string
+	FileData  FileMetaData
+	msg       proto.Message
+}
+
+// ID returns the node ID
+func (n *ConceptMapNode) ID() int      { return n.id }
+func (n *ConceptMapNode) setID(id int) { n.id = id }
+
+// Equals returns whether the nodes are equal
+func (n *ConceptMapNode) Equals(n2 Node) bool {
+	if m, ok := n2.(*ConceptMapNode); ok {
+		return *n == *m
+	}
+	return false
+}
+
+func (n *ConceptMapNode) protoMsg() proto.Message     { return n.msg }
+func (n *ConceptMapNode) setProtoMsg(m proto.Message) { n.msg = m }
+
+// UnknownNode is a placeholder for a whistler message that couldn't be added to the graph in tolerant mode
+type UnknownNode struct {
+	id       int
+	Reason   string
+	Context  string
+	FileData FileMetaData
+	msg      proto.Message
+}
+
+// ID returns the node ID
+func (n *UnknownNode) ID() int      { return n.id }
+func (n *UnknownNode) setID(id int) { n.id = id }
+
+// Equals returns whether the nodes are equal
+func (n *UnknownNode) Equals(n2 Node) bool {
+	if m, ok := n2.(*UnknownNode); ok {
+		return *n == *m
+	}
+	return false
+}
+
+func (n *UnknownNode) protoMsg() proto.Message     { return n.msg }
+func (n *UnknownNode) setProtoMsg(m proto.Message) { n.msg = m }
+
 func (n *TargetNode) String() string {
 	return fmt.Sprintf("%v)   Target: %v", n.ID(), n.Name)
 }
@@ -327,6 +399,29 @@ func (n *ArgumentNode) String() string {
 	return fmt.Sprintf("%v)   Arg: %v%v", n.ID(), n.Index, n.Field)
 }
 
+// DescribeArgument describes an argument by the parameter it reads and the call-site argument bound
+// to it, such as foo.patient ← $root.subject, naming the parameter by its index if its name isn't
+// known.
+func DescribeArgument(n *ArgumentNode) string {
+	param := fmt.Sprintf("%v.%v%v", n.Context, n.Name, n.Field)
+	if n.Name == "" {
+		param = fmt.Sprintf("arg %v%v of %v", n.Index, n.Field, n.Context)
+	}
+	if n.Binding == "" {
+		return param
+	}
+	return param + " ← " + n.Binding
+}
+
+// ArgumentSlot returns the 1-based call-site argument the i-th of a call's ArgumentEdges fills, or
+// 0 if it isn't known, as in graphs read from protobufs written without slots.
+func (g Graph) ArgumentSlot(id int, i int) int {
+	if slots := g.ArgumentSlots[id]; i < len(slots) {
+		return slots[i]
+	}
+	return 0
+}
+
 func (n *RootNode) String() string {
 	fieldStr := ""
 	if n.Field != "" {
@@ -334,3 +429,11 @@ func (n *RootNode) String() string {
 	}
 	return fmt.Sprintf("%v)   $Root%v", n.ID(), fieldStr)
 }
+
+func (n *ConceptMapNode) String() string {
+	return fmt.Sprintf("%v)   ConceptMap: %v", n.ID(), n.Map)
+}
+
+func (n *UnknownNode) String() string {
+	return fmt.Sprintf("%v)   Unknown: %v", n.ID(), n.Reason)
+}
@@ -0,0 +1,212 @@
+package graph
+
+import (
+	"sort"
+	"strings"
+)
+
+// LabelRule tags the input fields matching Field with sensitivity labels, such as PHI, PII or
+// QUASI_ID. Field is a $root path whose fields may be "*" to match any one field, as in
+// "$root.*.address"; see MatchField.
+type LabelRule struct {
+	Field  string   `json:"field"`
+	Labels []string `json:"labels"`
+}
+
+// NodeLabels are the labels reaching a node. A node derives from the labelled fields its value is
+// computed from, through Edges and ArgumentEdges, and is influenced by the labelled fields its
+// conditions are computed from, if conditions are followed.
+type NodeLabels struct {
+	Derived    []string
+	Influenced []string
+}
+
+// ApplyLabels tags the input fields matching the rules, and propagates their labels forward to
+// every node their values flow into, as given by DataFlows, setting the graph's Labels. If
+// conditions is true, the labels of a condition influence the targets it guards and the nodes
+// computed from them.
+func (g *Graph) ApplyLabels(rules []LabelRule, conditions bool) {
+	if len(rules) == 0 {
+		return
+	}
+	derived := map[int]map[string]bool{}
+	influenced := map[int]map[string]bool{}
+	add := func(labels map[int]map[string]bool, id int, label string) bool {
+		if labels[id] == nil {
+			labels[id] = map[string]bool{}
+		}
+		if labels[id][label] {
+			return false
+		}
+		labels[id][label] = true
+		return true
+	}
+
+	queue := []int{}
+	for _, path := range g.InputPaths() {
+		for _, rule := range rules {
+			if !MatchField(rule.Field, path.Path) {
+				continue
+			}
+			for _, label := range rule.Labels {
+				if add(derived, path.ID, label) {
+					queue = append(queue, path.ID)
+				}
+			}
+		}
+	}
+
+	descendants := g.DataFlows()
+	conditionDescendants := map[int][]int{}
+	if conditions {
+		for id, ancestorIDs := range g.ConditionEdges {
+			for _, ancestorID := range ancestorIDs {
+				conditionDescendants[ancestorID] = append(conditionDescendants[ancestorID], id)
+			}
+		}
+	}
+
+	for len(queue) > 0 {
+		var id int
+		id, queue = queue[0], queue[1:]
+		for _, d := range descendants[id] {
+			changed := false
+			for label := range derived[id] {
+				changed = add(derived, d, label) || changed
+			}
+			for label := range influenced[id] {
+				changed = add(influenced, d, label) || changed
+			}
+			if changed {
+				queue = append(queue, d)
+			}
+		}
+		for _, d := range conditionDescendants[id] {
+			changed := false
+			for _, labels := range []map[string]bool{derived[id], influenced[id]} {
+				for label := range labels {
+					changed = add(influenced, d, label) || changed
+				}
+			}
+			if changed {
+				queue = append(queue, d)
+			}
+		}
+	}
+
+	g.Labels = map[int]NodeLabels{}
+	for id := range g.Nodes {
+		labels := NodeLabels{Derived: []string{}, Influenced: []string{}}
+		for label := range derived[id] {
+			labels.Derived = append(labels.Derived, label)
+		}
+		for label := range influenced[id] {
+			if !derived[id][label] {
+				labels.Influenced = append(labels.Influenced, label)
+			}
+		}
+		if len(labels.Derived) == 0 && len(labels.Influenced) == 0 {
+			continue
+		}
+		sort.Strings(labels.Derived)
+		sort.Strings(labels.Influenced)
+		g.Labels[id] = labels
+	}
+}
+
+// DataFlows maps each node to the nodes its value flows into, through Edges and ArgumentEdges.
+// No flow leads into a Generating or Discarding builtin, whose value doesn't derive from its
+// arguments, or into a projector defined in the mapping, which is fed by its body rather than by
+// its arguments. A projector's argument reading an input field is fed by its own path rather than
+// by the whole input, so no flow leads to it.
+func (g Graph) DataFlows() map[int][]int {
+	inputs := map[int]bool{}
+	for _, path := range g.InputPaths() {
+		inputs[path.ID] = true
+	}
+	flows := map[int][]int{}
+	for id, ancestorIDs := range g.Edges {
+		for _, ancestorID := range ancestorIDs {
+			if !inputs[id] {
+				flows[ancestorID] = append(flows[ancestorID], id)
+			}
+		}
+	}
+	for id, ancestorIDs := range g.LineageArgumentEdges() {
+		if p, ok := g.Nodes[id].(*ProjectorNode); ok && !p.IsBuiltin {
+			continue
+		}
+		for _, ancestorID := range ancestorIDs {
+			flows[ancestorID] = append(flows[ancestorID], id)
+		}
+	}
+	for id := range flows {
+		sort.Ints(flows[id])
+	}
+	return flows
+}
+
+// MatchField returns whether a $root field pattern, whose fields may be "*" to match any one
+// field, matches an input path as returned by InputPaths. It matches the path, the fields of the
+// path, and the objects named in the pattern that contain the path when they are read whole. The
+// pattern "$root" is the whole input, so it matches every path.
+func MatchField(pattern string, path []string) bool {
+	field := schemaPath(strings.TrimPrefix(pattern, "$root"))
+	for i := 0; i < len(field) && i < len(path); i++ {
+		if field[i] == "*" && len(path) < len(field) {
+			return false // the object read may not be one containing the field
+		}
+		if field[i] != "*" && field[i] != path[i] {
+			return false
+		}
+	}
+	return len(field) > 0 || pattern == "$root"
+}
+
+// OutputLabels are the labels of an output field.
+type OutputLabels struct {
+	Path       string   `json:"path"`
+	Derived    []string `json:"derived"`
+	Influenced []string `json:"influenced,omitempty"`
+}
+
+// OutputLabels returns the labelled output fields, by their full path, in path order. A field
+// written by several targets has the labels of all of them.
+func (g Graph) OutputLabels() []OutputLabels {
+	derived := map[string]map[string]bool{}
+	influenced := map[string]map[string]bool{}
+	for _, path := range g.OutputPaths() {
+		labels, ok := g.Labels[path.ID]
+		if !ok {
+			continue
+		}
+		key := strings.Join(path.Path, ".")
+		if derived[key] == nil {
+			derived[key] = map[string]bool{}
+			influenced[key] = map[string]bool{}
+		}
+		for _, label := range labels.Derived {
+			derived[key][label] = true
+		}
+		for _, label := range labels.Influenced {
+			influenced[key][label] = true
+		}
+	}
+	outputs := []OutputLabels{}
+	for path := range derived {
+		output := OutputLabels{Path: path, Derived: []string{}}
+		for label := range derived[path] {
+			output.Derived = append(output.Derived, label)
+		}
+		for label := range influenced[path] {
+			if !derived[path][label] {
+				output.Influenced = append(output.Influenced, label)
+			}
+		}
+		sort.Strings(output.Derived)
+		sort.Strings(output.Influenced)
+		outputs = append(outputs, output)
+	}
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].Path < outputs[j].Path })
+	return outputs
+}
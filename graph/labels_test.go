@@ -0,0 +1,142 @@
+package graph
+
+import (
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestApplyLabels(t *testing.T) {
+	// Patient: PatientP($root.patient), with the name, ssn and address mapped in PatientP,
+	// and an id written only if the patient has an ssn.
+	mpc := makeMappingConfigMsg(
+		[]*mbp.ProjectorDefinition{
+			makeProjDefMsg("PatientP", []*mbp.FieldMapping{
+				makeMappingMsg("name", makeArgMsg(1, ".name"), nil),
+				makeMappingMsg("identifier", makeProjSourceMsg("$Hash", makeArgMsg(1, ".ssn"), nil), nil),
+				makeMappingMsg("address", makeArgMsg(1, ".address"), nil),
+				makeMappingMsg("id", makeStringMsg("1"), makeProjSourceMsg("$IsNotNil", makeArgMsg(1, ".ssn"), nil)),
+			}),
+		},
+		[]*mbp.FieldMapping{
+			makeMappingMsg("Patient", makeProjSourceMsg("PatientP", makeArgMsg(1, ".patient"), nil), nil),
+			makeMappingMsg("status", makeArgMsg(1, ".status"), nil),
+		})
+	rules := []LabelRule{
+		{Field: "$root.patient.ssn", Labels: []string{"PHI"}},
+		{Field: "$root.*.address", Labels: []string{"PII"}},
+	}
+	tests := []struct {
+		name       string
+		conditions bool
+		summarise  bool
+		want       []OutputLabels
+	}{
+		{
+			name: "values",
+			want: []OutputLabels{
+				{Path: "Patient", Derived: []string{"PHI", "PII"}},
+				{Path: "Patient.address", Derived: []string{"PII"}},
+				{Path: "Patient.identifier", Derived: []string{"PHI"}},
+			},
+		},
+		{
+			name:       "conditions",
+			conditions: true,
+			want: []OutputLabels{
+				{Path: "Patient", Derived: []string{"PHI", "PII"}},
+				{Path: "Patient.address", Derived: []string{"PII"}},
+				{Path: "Patient.id", Derived: []string{}, Influenced: []string{"PHI"}},
+				{Path: "Patient.identifier", Derived: []string{"PHI"}},
+			},
+		},
+		{
+			name:      "summarised",
+			summarise: true,
+			want: []OutputLabels{
+				{Path: "Patient", Derived: []string{"PHI", "PII"}},
+				{Path: "Patient.address", Derived: []string{"PII"}},
+				{Path: "Patient.identifier", Derived: []string{"PHI"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := NewWithOptions(mpc, Options{Summarise: test.summarise, Labels: rules, LabelConditions: test.conditions})
+			if err != nil {
+				t.Fatalf("building the graph failed:\n%v", err)
+			}
+			if diff := cmp.Diff(test.want, g.OutputLabels()); diff != "" {
+				t.Errorf("unexpected output labels (-want +got):\n%v", diff)
+			}
+		},
+		)
+	}
+}
+
+func TestMatchField(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		path    []string
+		want    bool
+	}{
+		{
+			name:    "field",
+			pattern: "$root.patient.ssn",
+			path:    []string{"patient", "ssn"},
+			want:    true,
+		},
+		{
+			name:    "field of the field",
+			pattern: "$root.patient",
+			path:    []string{"patient", "ssn"},
+			want:    true,
+		},
+		{
+			name:    "object containing the field",
+			pattern: "$root.patient.ssn",
+			path:    []string{"patient"},
+			want:    true,
+		},
+		{
+			name:    "other field",
+			pattern: "$root.patient.ssn",
+			path:    []string{"patient", "name"},
+			want:    false,
+		},
+		{
+			name:    "wildcard",
+			pattern: "$root.*.address",
+			path:    []string{"contact", "address", "city"},
+			want:    true,
+		},
+		{
+			name:    "object that may not contain the wildcard field",
+			pattern: "$root.*.address",
+			path:    []string{"contact"},
+			want:    false,
+		},
+		{
+			name:    "whole input",
+			pattern: "$root",
+			path:    []string{"status"},
+			want:    true,
+		},
+		{
+			name:    "empty pattern",
+			pattern: "",
+			path:    []string{"status"},
+			want:    false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := MatchField(test.pattern, test.path); got != test.want {
+				t.Errorf("MatchField(%q, %v) = %v, want %v", test.pattern, test.path, got, test.want)
+			}
+		},
+		)
+	}
+}
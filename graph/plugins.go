@@ -0,0 +1,119 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/builtins"
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+)
+
+// Plugin is the signature of a projector implemented in Go and registered with the mapping engine,
+// such as a terminology lookup, which a mapping can call like a projector it defines.
+type Plugin struct {
+	Name    string `json:"name"`
+	NumArgs int    `json:"numArgs"`
+	// Outputs maps each field of the plugin's value to the 1-based indices of the arguments it is
+	// derived from, with "" for the whole value. If it is empty, the whole value is derived from
+	// every argument but the table.
+	Outputs map[string][]int `json:"outputs,omitempty"`
+	// Lookup is true if the plugin looks its arguments up in an external table.
+	Lookup bool `json:"lookup,omitempty"`
+	// Table is the 1-based index of the argument giving the file of the concept map or lookup table
+	// the plugin reads, if any. A plugin with a table is a lookup.
+	Table int `json:"table,omitempty"`
+	// Func is the Go function implementing the plugin, which Trace registers with the mapping
+	// engine. Plugins read from a config file have none, so calls to them can't be traced.
+	Func interface{} `json:"-"`
+}
+
+// Validate checks that the plugin's signature is consistent. A plugin can't replace a builtin.
+func (plugin Plugin) Validate() error {
+	if plugin.Name == "" {
+		return fmt.Errorf("a plugin needs a name")
+	}
+	if _, ok := builtins.BuiltinFunctions[plugin.Name]; ok {
+		return fmt.Errorf("the plugin %v can't replace the builtin of the same name", plugin.Name)
+	}
+	if plugin.NumArgs < 0 {
+		return fmt.Errorf("the plugin %v can't take %v arguments", plugin.Name, plugin.NumArgs)
+	}
+	for field, args := range plugin.Outputs {
+		for _, arg := range args {
+			if arg < 1 || arg > plugin.NumArgs {
+				return fmt.Errorf("the output %q of plugin %v is derived from argument %v, but the plugin takes %v arguments", field, plugin.Name, arg, plugin.NumArgs)
+			}
+		}
+	}
+	if plugin.Table < 0 || plugin.Table > plugin.NumArgs {
+		return fmt.Errorf("the table of plugin %v is argument %v, but the plugin takes %v arguments", plugin.Name, plugin.Table, plugin.NumArgs)
+	}
+	return nil
+}
+
+// pluginDefinitions validates the plugins of a graph's options and builds the projector
+// definitions standing for them. Calls to a plugin are added to the graph as ProjectorNodes whose
+// body writes each output field from the arguments it is derived from. A plugin replaces an
+// earlier one of the same name.
+func pluginDefinitions(plugins []Plugin) (map[*mbp.ProjectorDefinition]Plugin, error) {
+	defs := map[*mbp.ProjectorDefinition]Plugin{}
+	byName := map[string]*mbp.ProjectorDefinition{}
+	for _, plugin := range plugins {
+		if err := plugin.Validate(); err != nil {
+			return nil, err
+		}
+		if plugin.Table > 0 {
+			plugin.Lookup = true
+		}
+		if def, ok := byName[plugin.Name]; ok {
+			delete(defs, def)
+		}
+		def := pluginDefinition(plugin)
+		defs[def] = plugin
+		byName[plugin.Name] = def
+	}
+	return defs, nil
+}
+
+// pluginDefinition builds the projector definition standing for a plugin. Each output field is
+// written once from each argument it is derived from, like a target written by several mappings.
+func pluginDefinition(plugin Plugin) *mbp.ProjectorDefinition {
+	outputs := plugin.Outputs
+	if len(outputs) == 0 {
+		outputs = map[string][]int{"": {}}
+		for i := 1; i <= plugin.NumArgs; i++ {
+			if i != plugin.Table {
+				outputs[""] = append(outputs[""], i)
+			}
+		}
+	}
+	fields := []string{}
+	for field := range outputs {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	def := &mbp.ProjectorDefinition{Name: plugin.Name}
+	for _, field := range fields {
+		target := field
+		if target == "" {
+			target = this_keyword
+		}
+		for _, arg := range outputs[field] {
+			def.Mapping = append(def.Mapping, &mbp.FieldMapping{
+				Target:      &mbp.FieldMapping_TargetField{TargetField: target},
+				ValueSource: &mbp.ValueSource{Source: &mbp.ValueSource_FromInput{FromInput: &mbp.ValueSource_InputSource{Arg: int32(arg)}}},
+			})
+		}
+	}
+	return def
+}
+
+// pluginOf returns the plugin a projector definition stands for, if any.
+func (e *env) pluginOf(def *mbp.ProjectorDefinition) (Plugin, bool) {
+	if e == nil {
+		return Plugin{}, false
+	}
+	plugin, ok := e.plugins[def]
+	return plugin, ok
+}
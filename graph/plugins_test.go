@@ -0,0 +1,132 @@
+package graph
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPlugin_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		plugin  Plugin
+		wantErr bool
+	}{
+		{
+			name:   "plugin",
+			plugin: Plugin{Name: "LookupCode", NumArgs: 2, Outputs: map[string][]int{"code": {1}, "display": {1, 2}}, Lookup: true},
+		},
+		{
+			name:    "no name",
+			plugin:  Plugin{NumArgs: 1},
+			wantErr: true,
+		},
+		{
+			name:    "builtin",
+			plugin:  Plugin{Name: "$StrCat", NumArgs: 1},
+			wantErr: true,
+		},
+		{
+			name:    "output derived from a missing argument",
+			plugin:  Plugin{Name: "LookupCode", NumArgs: 1, Outputs: map[string][]int{"code": {2}}},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.plugin.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("Validate() returned %v, want error %v", err, test.wantErr)
+			}
+			if _, err := NewWithOptions(makeMappingConfigMsg(nil, nil), Options{Plugins: []Plugin{test.plugin}}); (err != nil) != test.wantErr {
+				t.Errorf("NewWithOptions() returned %v, want error %v", err, test.wantErr)
+			}
+		},
+		)
+	}
+}
+
+func TestPlugin_Lineage(t *testing.T) {
+	opts := Options{Plugins: []Plugin{{Name: "LookupCode", NumArgs: 2, Outputs: map[string][]int{"code": {1}, "display": {1, 2}}, Lookup: true}}}
+	call := makeMappingMsg("x", makeProjSourceMsg("LookupCode", makeArgMsg(1, ".a"), []*mbp.ValueSource{makeArgMsg(1, ".b")}), nil)
+	tests := []struct {
+		name       string
+		mappings   []*mbp.FieldMapping
+		projectors []*mbp.ProjectorDefinition
+		path       string
+		wantFields []string // the input fields the path derives from
+		wantProj   ProjectorNode
+		wantErr    error
+	}{
+		{
+			name:       "output derived from one argument",
+			mappings:   []*mbp.FieldMapping{call},
+			path:       "x.code",
+			wantFields: []string{".a"},
+			wantProj:   ProjectorNode{Name: "LookupCode", Context: "root", IsPlugin: true, IsLookup: true},
+		},
+		{
+			name:       "output derived from both arguments",
+			mappings:   []*mbp.FieldMapping{call},
+			path:       "x.display",
+			wantFields: []string{".a", ".b"},
+			wantProj:   ProjectorNode{Name: "LookupCode", Context: "root", IsPlugin: true, IsLookup: true},
+		},
+		{
+			name:       "mapping projector takes precedence",
+			mappings:   []*mbp.FieldMapping{call},
+			projectors: []*mbp.ProjectorDefinition{makeProjDefMsg("LookupCode", []*mbp.FieldMapping{makeMappingMsg("code", makeArgMsg(2, ""), nil)})},
+			path:       "x.code",
+			wantFields: []string{".b"},
+			wantProj:   ProjectorNode{Name: "LookupCode", Context: "root"},
+		},
+		{
+			name:     "wrong argument count",
+			mappings: []*mbp.FieldMapping{makeMappingMsg("x", makeProjSourceMsg("LookupCode", makeArgMsg(1, ".a"), nil), nil)},
+			wantErr:  &ArgumentCountError{},
+		},
+		{
+			name:     "plugin not in the options",
+			mappings: []*mbp.FieldMapping{makeMappingMsg("x", makeProjSourceMsg("LookupName", makeArgMsg(1, ".a"), nil), nil)},
+			wantErr:  &UnknownProjectorError{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := NewWithOptions(makeMappingConfigMsg(test.projectors, test.mappings), opts)
+			if test.wantErr != nil {
+				if err == nil || !errors.As(err, &test.wantErr) {
+					t.Fatalf("building graph returned %v, want %T", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("building graph failed:\n%v", err)
+			}
+			fields := []string{}
+			for _, id := range g.FindTargets(test.path) {
+				for _, ancestorID := range g.Upstream(id, false) {
+					if root, ok := g.Nodes[ancestorID].(*RootNode); ok {
+						fields = append(fields, root.Field)
+					}
+				}
+			}
+			sort.Strings(fields)
+			if diff := cmp.Diff(test.wantFields, fields); diff != "" {
+				t.Errorf("unexpected input fields (-want +got):\n%v", diff)
+			}
+			for _, node := range g.Nodes {
+				if p, ok := node.(*ProjectorNode); ok {
+					got := ProjectorNode{Name: p.Name, Context: p.Context, IsBuiltin: p.IsBuiltin, IsPlugin: p.IsPlugin, IsLookup: p.IsLookup}
+					if got != test.wantProj {
+						t.Errorf("unexpected projector node %+v, want %+v", got, test.wantProj)
+					}
+				}
+			}
+		},
+		)
+	}
+}
@@ -1,6 +1,7 @@
 package graph
 
 import (
+	"encoding/json"
 	"fmt"
 
 	gpb "github.com/googleinterns/healthcare-data-harmonization-lineage/graph/proto"
@@ -11,6 +12,7 @@ func WriteProtobuf(g Graph) (*gpb.Graph, error) {
 	pbGraph := gpb.Graph{
 		Edges:             map[int32]*gpb.EdgeList{},
 		ArgumentEdges:     map[int32]*gpb.EdgeList{},
+		ArgumentSlots:     map[int32]*gpb.EdgeList{},
 		ConditionEdges:    map[int32]*gpb.EdgeList{},
 		RootAndOutTargets: map[string]*gpb.EdgeList{},
 		Nodes:             map[int32]*gpb.Node{},
@@ -30,16 +32,239 @@ func WriteProtobuf(g Graph) (*gpb.Graph, error) {
 	for id, idList := range g.ArgumentEdges {
 		pbGraph.ArgumentEdges[int32(id)] = newEdgeList(idList)
 	}
+	for id, slots := range g.ArgumentSlots {
+		pbGraph.ArgumentSlots[int32(id)] = newEdgeList(slots)
+	}
 	for id, idList := range g.ConditionEdges {
 		pbGraph.ConditionEdges[int32(id)] = newEdgeList(idList)
 	}
 	for name, idList := range g.RootAndOutTargets {
 		pbGraph.RootAndOutTargets[name] = newEdgeList(idList)
 	}
+	if len(g.Values) > 0 {
+		pbGraph.Values = map[int32]string{}
+	}
+	for id, value := range g.Values {
+		out, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal the value of node %v:\n%w", id, err)
+		}
+		pbGraph.Values[int32(id)] = string(out)
+	}
+	if len(g.Samples) > 0 {
+		pbGraph.Samples = map[int32]*gpb.FieldSamples{}
+	}
+	for id, stats := range g.Samples {
+		pbStats := &gpb.FieldSamples{Samples: int32(stats.Samples), Nulls: int32(stats.Nulls)}
+		for _, example := range stats.Examples {
+			out, err := json.Marshal(example)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal an example value of node %v:\n%w", id, err)
+			}
+			pbStats.Examples = append(pbStats.Examples, string(out))
+		}
+		pbGraph.Samples[int32(id)] = pbStats
+	}
+	if len(g.Branches) > 0 {
+		pbGraph.Branches = map[int32]*gpb.BranchCounts{}
+	}
+	for id, counts := range g.Branches {
+		pbGraph.Branches[int32(id)] = &gpb.BranchCounts{
+			Taken:    int32(counts.Taken),
+			NotTaken: int32(counts.NotTaken),
+			Unknown:  int32(counts.Unknown),
+		}
+	}
 
 	return &pbGraph, nil
 }
 
+// ReadProtobuf takes a protobuf representation of a graph and recreates the graph.
+// The whistler messages the nodes were generated from are not part of the protobuf, so the graph
+// can be queried and rendered but not extended.
+func ReadProtobuf(pbGraph *gpb.Graph) (Graph, error) {
+	g := Graph{
+		Edges:             readEdgeLists(pbGraph.GetEdges()),
+		ArgumentEdges:     readEdgeLists(pbGraph.GetArgumentEdges()),
+		ArgumentSlots:     readEdgeLists(pbGraph.GetArgumentSlots()),
+		ConditionEdges:    readEdgeLists(pbGraph.GetConditionEdges()),
+		RootAndOutTargets: map[string][]int{},
+		Nodes:             map[int]Node{},
+		targetLineages:    map[int]targetLineage{},
+	}
+	for name, edgeList := range pbGraph.GetRootAndOutTargets() {
+		g.RootAndOutTargets[name] = readEdgeList(edgeList)
+	}
+	for id, pbNode := range pbGraph.GetNodes() {
+		node, err := readNode(pbNode)
+		if err != nil {
+			return Graph{}, fmt.Errorf("failed to read node %v from protobuf:\n%w", id, err)
+		}
+		g.Nodes[int(id)] = node
+	}
+	if len(pbGraph.GetValues()) > 0 {
+		g.Values = map[int]interface{}{}
+	}
+	for id, value := range pbGraph.GetValues() {
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return Graph{}, fmt.Errorf("failed to read the value of node %v from protobuf:\n%w", id, err)
+		}
+		g.Values[int(id)] = v
+	}
+	if len(pbGraph.GetSamples()) > 0 {
+		g.Samples = map[int]FieldSamples{}
+	}
+	for id, pbStats := range pbGraph.GetSamples() {
+		stats := FieldSamples{Samples: int(pbStats.GetSamples()), Nulls: int(pbStats.GetNulls()), Examples: []interface{}{}}
+		for _, example := range pbStats.GetExamples() {
+			var v interface{}
+			if err := json.Unmarshal([]byte(example), &v); err != nil {
+				return Graph{}, fmt.Errorf("failed to read an example value of node %v from protobuf:\n%w", id, err)
+			}
+			stats.Examples = append(stats.Examples, v)
+		}
+		g.Samples[int(id)] = stats
+	}
+	if len(pbGraph.GetBranches()) > 0 {
+		g.Branches = map[int]BranchCounts{}
+	}
+	for id, counts := range pbGraph.GetBranches() {
+		g.Branches[int(id)] = BranchCounts{
+			Taken:    int(counts.GetTaken()),
+			NotTaken: int(counts.GetNotTaken()),
+			Unknown:  int(counts.GetUnknown()),
+		}
+	}
+	return g, nil
+}
+
+// NodeProtobuf returns the protobuf representation of a single node.
+func NodeProtobuf(node Node) (*gpb.Node, error) {
+	return convertNode(node)
+}
+
+func readEdgeLists(pbEdgeLists map[int32]*gpb.EdgeList) map[int][]int {
+	edgeLists := map[int][]int{}
+	for id, edgeList := range pbEdgeLists {
+		edgeLists[int(id)] = readEdgeList(edgeList)
+	}
+	return edgeLists
+}
+
+func readEdgeList(edgeList *gpb.EdgeList) []int {
+	idList := make([]int, len(edgeList.GetEdges()))
+	for i, id := range edgeList.GetEdges() {
+		idList[i] = int(id)
+	}
+	return idList
+}
+
+func readNode(pbNode *gpb.Node) (Node, error) {
+	switch n := pbNode.GetNode().(type) {
+	case *gpb.Node_TargetNode:
+		return &TargetNode{
+			id:          int(n.TargetNode.GetId()),
+			Name:        n.TargetNode.GetName(),
+			Context:     n.TargetNode.GetContext(),
+			IsVariable:  n.TargetNode.GetIsVariable(),
+			IsOverwrite: n.TargetNode.GetIsOverwrite(),
+			IsRoot:      n.TargetNode.GetIsRoot(),
+			IsOut:       n.TargetNode.GetIsOut(),
+			FileData:    readFileData(n.TargetNode.GetFileData()),
+			Schema:      readSchemaElement(n.TargetNode.GetSchema()),
+		}, nil
+	case *gpb.Node_ConstIntNode:
+		return &ConstIntNode{
+			id:       int(n.ConstIntNode.GetId()),
+			Value:    int(n.ConstIntNode.GetValue()),
+			Context:  n.ConstIntNode.GetContext(),
+			FileData: readFileData(n.ConstIntNode.GetFileData()),
+		}, nil
+	case *gpb.Node_ConstFloatNode:
+		return &ConstFloatNode{
+			id:       int(n.ConstFloatNode.GetId()),
+			Value:    n.ConstFloatNode.GetValue(),
+			Context:  n.ConstFloatNode.GetContext(),
+			FileData: readFileData(n.ConstFloatNode.GetFileData()),
+		}, nil
+	case *gpb.Node_ConstBoolNode:
+		return &ConstBoolNode{
+			id:       int(n.ConstBoolNode.GetId()),
+			Value:    n.ConstBoolNode.GetValue(),
+			Context:  n.ConstBoolNode.GetContext(),
+			FileData: readFileData(n.ConstBoolNode.GetFileData()),
+		}, nil
+	case *gpb.Node_ConstStringNode:
+		return &ConstStringNode{
+			id:       int(n.ConstStringNode.GetId()),
+			Value:    n.ConstStringNode.GetValue(),
+			Context:  n.ConstStringNode.GetContext(),
+			FileData: readFileData(n.ConstStringNode.GetFileData()),
+		}, nil
+	case *gpb.Node_ProjectorNode:
+		semantics := BuiltinSemantics(n.ProjectorNode.GetSemantics())
+		if semantics == "" && n.ProjectorNode.GetIsBuiltin() { // written before builtins were classified
+			semantics = SemanticsOf(n.ProjectorNode.GetName())
+		}
+		return &ProjectorNode{
+			id:        int(n.ProjectorNode.GetId()),
+			Name:      n.ProjectorNode.GetName(),
+			IsBuiltin: n.ProjectorNode.GetIsBuiltin(),
+			IsPlugin:  n.ProjectorNode.GetIsPlugin(),
+			IsLookup:  n.ProjectorNode.GetIsLookup(),
+			Semantics: semantics,
+			Context:   n.ProjectorNode.GetContext(),
+			FileData:  readFileData(n.ProjectorNode.GetFileData()),
+		}, nil
+	case *gpb.Node_ArgumentNode:
+		return &ArgumentNode{
+			id:       int(n.ArgumentNode.GetId()),
+			Index:    int(n.ArgumentNode.GetIndex()),
+			Field:    n.ArgumentNode.GetField(),
+			Context:  n.ArgumentNode.GetContext(),
+			Name:     n.ArgumentNode.GetName(),
+			Binding:  n.ArgumentNode.GetBinding(),
+			FileData: readFileData(n.ArgumentNode.GetFileData()),
+		}, nil
+	case *gpb.Node_RootNode:
+		return &RootNode{
+			id:       int(n.RootNode.GetId()),
+			Field:    n.RootNode.GetField(),
+			Context:  n.RootNode.GetContext(),
+			FileData: readFileData(n.RootNode.GetFileData()),
+			Schema:   readSchemaElement(n.RootNode.GetSchema()),
+		}, nil
+	case *gpb.Node_ConceptMapNode:
+		return &ConceptMapNode{
+			id:        int(n.ConceptMapNode.GetId()),
+			Map:       n.ConceptMapNode.GetMap(),
+			Projector: n.ConceptMapNode.GetProjector(),
+			Context:   n.ConceptMapNode.GetContext(),
+			FileData:  readFileData(n.ConceptMapNode.GetFileData()),
+		}, nil
+	case *gpb.Node_UnknownNode:
+		return &UnknownNode{
+			id:       int(n.UnknownNode.GetId()),
+			Reason:   n.UnknownNode.GetReason(),
+			Context:  n.UnknownNode.GetContext(),
+			FileData: readFileData(n.UnknownNode.GetFileData()),
+		}, nil
+	default:
+		return nil, fmt.Errorf("protobuf node of type %T is not supported", n)
+	}
+}
+
+func readFileData(data *gpb.FileMetaData) FileMetaData {
+	return FileMetaData{
+		FileName:  data.GetFileName(),
+		LineStart: int(data.GetLineStart()),
+		LineEnd:   int(data.GetLineEnd()),
+		CharStart: int(data.GetCharStart()),
+		CharEnd:   int(data.GetCharEnd()),
+	}
+}
+
 func newEdgeList(idList []int) *gpb.EdgeList {
 	pbIDlist := make([]int32, len(idList))
 	for i, id := range idList {
@@ -64,6 +289,7 @@ func convertNode(node Node) (*gpb.Node, error) {
 					IsRoot:      n.IsRoot,
 					IsOut:       n.IsOut,
 					FileData:    convertFileData(n.FileData),
+					Schema:      convertSchemaElement(n.Schema),
 				},
 			},
 		}, nil
@@ -118,6 +344,9 @@ func convertNode(node Node) (*gpb.Node, error) {
 					Id:        int32(n.ID()),
 					Name:      n.Name,
 					IsBuiltin: n.IsBuiltin,
+					IsPlugin:  n.IsPlugin,
+					IsLookup:  n.IsLookup,
+					Semantics: string(n.Semantics),
 					Context:   n.Context,
 					FileData:  convertFileData(n.FileData),
 				},
@@ -131,6 +360,8 @@ func convertNode(node Node) (*gpb.Node, error) {
 					Index:    int32(n.Index),
 					Field:    n.Field,
 					Context:  n.Context,
+					Name:     n.Name,
+					Binding:  n.Binding,
 					FileData: convertFileData(n.FileData),
 				},
 			},
@@ -143,6 +374,30 @@ func convertNode(node Node) (*gpb.Node, error) {
 					Field:    n.Field,
 					Context:  n.Context,
 					FileData: convertFileData(n.FileData),
+					Schema:   convertSchemaElement(n.Schema),
+				},
+			},
+		}, nil
+	case *ConceptMapNode:
+		return &gpb.Node{
+			Node: &gpb.Node_ConceptMapNode{
+				ConceptMapNode: &gpb.ConceptMapNode{
+					Id:        int32(n.ID()),
+					Map:       n.Map,
+					Projector: n.Projector,
+					Context:   n.Context,
+					FileData:  convertFileData(n.FileData),
+				},
+			},
+		}, nil
+	case *UnknownNode:
+		return &gpb.Node{
+			Node: &gpb.Node_UnknownNode{
+				UnknownNode: &gpb.UnknownNode{
+					Id:       int32(n.ID()),
+					Reason:   n.Reason,
+					Context:  n.Context,
+					FileData: convertFileData(n.FileData),
 				},
 			},
 		}, nil
@@ -160,3 +415,24 @@ func convertFileData(data FileMetaData) *gpb.FileMetaData {
 		CharEnd:   int32(data.CharEnd),
 	}
 }
+
+func convertSchemaElement(element SchemaElement) *gpb.SchemaElement {
+	if element == (SchemaElement{}) {
+		return nil
+	}
+	return &gpb.SchemaElement{
+		Path: element.Path,
+		Type: element.Type,
+		Min:  int32(element.Min),
+		Max:  element.Max,
+	}
+}
+
+func readSchemaElement(element *gpb.SchemaElement) SchemaElement {
+	return SchemaElement{
+		Path: element.GetPath(),
+		Type: element.GetType(),
+		Min:  int(element.GetMin()),
+		Max:  element.GetMax(),
+	}
+}
@@ -0,0 +1,166 @@
+package graph
+
+import (
+	"sort"
+	"strings"
+)
+
+// provenance holds the input fields each field of a traced value was computed from, by the path
+// of the field relative to the value, without array indices; the whole value is at "". It is
+// tracked from the values of a run rather than from the nodes of the graph, so that the flows of a
+// trace can check those of a static graph built by the same node constructors.
+type provenance struct {
+	// copied holds the input fields a field is a copy of, so that its own fields are copies of the
+	// input fields' fields. derived holds the input fields it is otherwise computed from.
+	copied  map[string]map[string]bool
+	derived map[string]map[string]bool
+}
+
+func newProvenance() provenance {
+	return provenance{copied: map[string]map[string]bool{}, derived: map[string]map[string]bool{}}
+}
+
+// inputProvenance is the provenance of an input field read whole.
+func inputProvenance(path []string) provenance {
+	p := newProvenance()
+	addInput(p.copied, "", strings.Join(path, "."))
+	return p
+}
+
+func addInput(fields map[string]map[string]bool, field string, input string) {
+	if fields[field] == nil {
+		fields[field] = map[string]bool{}
+	}
+	fields[field][input] = true
+}
+
+// field returns the provenance of the field at a path of the value. The fields of a copy of an
+// input field are copies of the input field's fields.
+func (p provenance) field(path []string) provenance {
+	result := newProvenance()
+	prefix := strings.Join(path, ".")
+	for _, kind := range []struct {
+		from, to map[string]map[string]bool
+		copied   bool
+	}{{p.copied, result.copied, true}, {p.derived, result.derived, false}} {
+		for field, inputs := range kind.from {
+			for input := range inputs {
+				switch {
+				case prefix == "":
+					addInput(kind.to, field, input)
+				case field == prefix || strings.HasPrefix(field, prefix+"."):
+					addInput(kind.to, strings.TrimPrefix(strings.TrimPrefix(field, prefix), "."), input)
+				case field == "" || strings.HasPrefix(prefix, field+"."):
+					if kind.copied {
+						addInput(kind.to, "", joinPath(input, strings.TrimPrefix(strings.TrimPrefix(prefix, field), ".")))
+					} else {
+						addInput(kind.to, "", input)
+					}
+				}
+			}
+		}
+	}
+	return result
+}
+
+// under returns the provenance of the value written to the field at a path of an object.
+func (p provenance) under(path []string) provenance {
+	result := newProvenance()
+	prefix := strings.Join(path, ".")
+	for field, inputs := range p.copied {
+		for input := range inputs {
+			addInput(result.copied, joinPath(prefix, field), input)
+		}
+	}
+	for field, inputs := range p.derived {
+		for input := range inputs {
+			addInput(result.derived, joinPath(prefix, field), input)
+		}
+	}
+	return result
+}
+
+// add adds the provenance of another value written to the same object.
+func (p provenance) add(other provenance) {
+	for field, inputs := range other.copied {
+		for input := range inputs {
+			addInput(p.copied, field, input)
+		}
+	}
+	for field, inputs := range other.derived {
+		for input := range inputs {
+			addInput(p.derived, field, input)
+		}
+	}
+}
+
+// inputs returns every input field the value was computed from.
+func (p provenance) inputs() map[string]bool {
+	result := map[string]bool{}
+	for _, fields := range []map[string]map[string]bool{p.copied, p.derived} {
+		for _, inputs := range fields {
+			for input := range inputs {
+				result[input] = true
+			}
+		}
+	}
+	return result
+}
+
+// derivedFrom returns the provenance of a value computed from the given input fields.
+func derivedFrom(inputs map[string]bool) provenance {
+	p := newProvenance()
+	for input := range inputs {
+		addInput(p.derived, "", input)
+	}
+	return p
+}
+
+// flows returns the input fields each field of the value was computed from, sorted.
+func (p provenance) flows() map[string][]string {
+	result := map[string][]string{}
+	for _, fields := range []map[string]map[string]bool{p.copied, p.derived} {
+		for field, inputs := range fields {
+			for input := range inputs {
+				result[field] = append(result[field], input)
+			}
+		}
+	}
+	for field, inputs := range result {
+		sort.Strings(inputs)
+		result[field] = sortedUniqueStrings(inputs)
+	}
+	return result
+}
+
+func sortedUniqueStrings(strs []string) []string {
+	unique := []string{}
+	for i, s := range strs {
+		if i == 0 || s != strs[i-1] {
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
+
+func joinPath(prefix, field string) string {
+	if prefix == "" {
+		return field
+	}
+	if field == "" {
+		return prefix
+	}
+	return prefix + "." + field
+}
+
+// tracePath returns the path of a field read or written by a mapping, without array indices and
+// without $this, which stands for the whole value.
+func tracePath(name string) []string {
+	path := []string{}
+	for _, field := range schemaPath(name) {
+		if field != this_keyword {
+			path = append(path, field)
+		}
+	}
+	return path
+}
@@ -0,0 +1,257 @@
+package graph
+
+import (
+	"sort"
+	"strings"
+)
+
+// Upstream returns the IDs of every node the given node derives from, following Edges and
+// ArgumentEdges. The arguments of Generating and Discarding builtins aren't followed, since their
+// value doesn't derive from them; see LineageArgumentEdges. If withConditions is true, the
+// conditions guarding nodes are followed too. The IDs are sorted and do not include the starting
+// node.
+func (g Graph) Upstream(id int, withConditions bool) []int {
+	adjLists := []map[int][]int{g.Edges, g.LineageArgumentEdges()}
+	if withConditions {
+		adjLists = append(adjLists, g.ConditionEdges)
+	}
+	return reachable(id, adjLists)
+}
+
+// Downstream returns the IDs of every node that derives from the given node, following Edges and
+// ArgumentEdges in reverse, but not into Generating and Discarding builtins, like Upstream. If
+// withConditions is true, nodes guarded by a condition derived from the node are included too.
+// The IDs are sorted and do not include the starting node.
+func (g Graph) Downstream(id int, withConditions bool) []int {
+	adjLists := []map[int][]int{reverse(g.Edges), reverse(g.LineageArgumentEdges())}
+	if withConditions {
+		adjLists = append(adjLists, reverse(g.ConditionEdges))
+	}
+	return reachable(id, adjLists)
+}
+
+// Outputs returns the IDs of the 'root' and 'out' targets and of the targets written by root
+// mappings, keyed by target name.
+func (g Graph) Outputs() map[string][]int {
+	outputs := map[string][]int{}
+	for name, ids := range g.RootAndOutTargets {
+		outputs[name] = append(outputs[name], ids...)
+	}
+	for id, node := range g.Nodes {
+		if t, ok := node.(*TargetNode); ok && t.Context == rootContext && !t.IsVariable && !t.IsRoot && !t.IsOut {
+			outputs[t.Name] = append(outputs[t.Name], id)
+		}
+	}
+	for name := range outputs {
+		sort.Ints(outputs[name])
+	}
+	return outputs
+}
+
+// FindTargets returns the IDs of the output targets matching a dotted path like "x.y.z".
+// The first path segment is looked up among the outputs, and the remaining segments among the
+// targets written by the projectors each matching target derives from.
+func (g Graph) FindTargets(path string) []int {
+	segments := splitPath(path)
+	if len(segments) == 0 {
+		return nil
+	}
+	matches := []int{}
+	for name, ids := range g.Outputs() {
+		numMatching := matchUpToDiff(splitPath(name), segments)
+		if numMatching == 0 {
+			continue
+		}
+		for _, id := range ids {
+			matches = append(matches, g.findChildTargets(id, segments[numMatching:], map[int]bool{})...)
+		}
+	}
+	return sortedUnique(matches)
+}
+
+// findChildTargets follows the primary edges of a target until the rest of the path is matched,
+// and the argument edges of builtins that keep the elements of their arguments.
+func (g Graph) findChildTargets(id int, path []string, visited map[int]bool) []int {
+	if len(path) == 0 {
+		return []int{id}
+	}
+	ancestorIDs := g.Edges[id]
+	if keepsElements(g.Nodes[id]) {
+		ancestorIDs = append(append([]int{}, ancestorIDs...), g.ArgumentEdges[id]...)
+	}
+	matches := []int{}
+	for _, ancestorID := range ancestorIDs {
+		if visited[ancestorID] {
+			continue
+		}
+		visited[ancestorID] = true
+		if t, ok := g.Nodes[ancestorID].(*TargetNode); ok {
+			if numMatching := matchUpToDiff(splitPath(t.Name), path); numMatching > 0 {
+				matches = append(matches, g.findChildTargets(ancestorID, path[numMatching:], visited)...)
+			}
+			continue
+		}
+		matches = append(matches, g.findChildTargets(ancestorID, path, visited)...)
+	}
+	return matches
+}
+
+// FindRootNodes returns the IDs of the $root nodes reading the dotted input path, a parent of it,
+// or a field nested below it. A leading "$root" in the path is ignored.
+func (g Graph) FindRootNodes(path string) []int {
+	segments := splitPath(strings.TrimPrefix(path, "$root"))
+	matches := []int{}
+	for id, node := range g.Nodes {
+		if r, ok := node.(*RootNode); ok {
+			field := splitPath(r.Field)
+			if len(field) == 0 || len(segments) == 0 || matchUpToDiff(field, segments) > 0 {
+				matches = append(matches, id)
+			}
+		}
+	}
+	return sortedUnique(matches)
+}
+
+// Subgraph returns a copy of the graph restricted to the given nodes and the edges between them.
+func (g Graph) Subgraph(ids []int) Graph {
+	keep := map[int]bool{}
+	for _, id := range ids {
+		if _, ok := g.Nodes[id]; ok {
+			keep[id] = true
+		}
+	}
+	sub := Graph{
+		Edges:             subAdjList(g.Edges, keep),
+		ArgumentEdges:     subAdjList(g.ArgumentEdges, keep),
+		ArgumentSlots:     subSlots(g, keep),
+		ConditionEdges:    subAdjList(g.ConditionEdges, keep),
+		RootAndOutTargets: map[string][]int{},
+		Nodes:             map[int]Node{},
+		targetLineages:    map[int]targetLineage{},
+	}
+	for id := range keep {
+		sub.Nodes[id] = g.Nodes[id]
+		if labels, ok := g.Labels[id]; ok {
+			if sub.Labels == nil {
+				sub.Labels = map[int]NodeLabels{}
+			}
+			sub.Labels[id] = labels
+		}
+		if value, ok := g.Values[id]; ok {
+			if sub.Values == nil {
+				sub.Values = map[int]interface{}{}
+			}
+			sub.Values[id] = value
+		}
+		if stats, ok := g.Samples[id]; ok {
+			if sub.Samples == nil {
+				sub.Samples = map[int]FieldSamples{}
+			}
+			sub.Samples[id] = stats
+		}
+		if counts, ok := g.Branches[id]; ok {
+			if sub.Branches == nil {
+				sub.Branches = map[int]BranchCounts{}
+			}
+			sub.Branches[id] = counts
+		}
+	}
+	for name, targetIDs := range g.RootAndOutTargets {
+		for _, id := range targetIDs {
+			if keep[id] {
+				appendOrAddID(sub.RootAndOutTargets, id, name)
+			}
+		}
+	}
+	return sub
+}
+
+func subAdjList(adjList map[int][]int, keep map[int]bool) map[int][]int {
+	sub := map[int][]int{}
+	for id, ancestorIDs := range adjList {
+		if !keep[id] {
+			continue
+		}
+		sub[id] = []int{}
+		for _, ancestorID := range ancestorIDs {
+			if keep[ancestorID] {
+				sub[id] = append(sub[id], ancestorID)
+			}
+		}
+	}
+	return sub
+}
+
+// subSlots returns the ArgumentSlots of the argument edges subAdjList keeps.
+func subSlots(g Graph, keep map[int]bool) map[int][]int {
+	sub := map[int][]int{}
+	for id, ancestorIDs := range g.ArgumentEdges {
+		if !keep[id] || len(g.ArgumentSlots[id]) == 0 {
+			continue
+		}
+		sub[id] = []int{}
+		for i, ancestorID := range ancestorIDs {
+			if keep[ancestorID] {
+				sub[id] = append(sub[id], g.ArgumentSlot(id, i))
+			}
+		}
+	}
+	return sub
+}
+
+// reachable does a breadth-first search from the start node over the union of the adjacency lists.
+func reachable(start int, adjLists []map[int][]int) []int {
+	visited := map[int]bool{start: true}
+	queue := []int{start}
+	found := []int{}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, adjList := range adjLists {
+			for _, nextID := range adjList[id] {
+				if !visited[nextID] {
+					visited[nextID] = true
+					found = append(found, nextID)
+					queue = append(queue, nextID)
+				}
+			}
+		}
+	}
+	sort.Ints(found)
+	return found
+}
+
+// reverse turns an ancestor adjacency list into a descendant adjacency list.
+func reverse(adjList map[int][]int) map[int][]int {
+	reversed := map[int][]int{}
+	for id, ancestorIDs := range adjList {
+		for _, ancestorID := range ancestorIDs {
+			reversed[ancestorID] = append(reversed[ancestorID], id)
+		}
+	}
+	return reversed
+}
+
+// splitPath splits a dotted field path, ignoring leading dots and empty segments.
+func splitPath(path string) []string {
+	segments := []string{}
+	for _, segment := range strings.Split(path, ".") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+func sortedUnique(ids []int) []int {
+	seen := map[int]bool{}
+	unique := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	sort.Ints(unique)
+	return unique
+}
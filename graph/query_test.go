@@ -0,0 +1,230 @@
+package graph
+
+import (
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+)
+
+// queryTestGraph is the graph of
+//
+//	x: proj($root.a)
+//	def proj(arg) {
+//	  y: arg
+//	}
+func queryTestGraph() Graph {
+	return Graph{
+		Edges: map[int][]int{
+			0: ids1(1), // x -> proj
+			1: ids1(2), // proj -> y
+			2: ids1(3), // y -> arg 1
+			3: ids1(4), // arg 1 -> $root.a
+			4: ids0(),
+			5: ids0(),
+		},
+		ArgumentEdges: map[int][]int{
+			1: ids1(4), // proj -> $root.a
+		},
+		ConditionEdges: map[int][]int{
+			0: ids1(5), // x -> true
+			2: ids0(),
+		},
+		RootAndOutTargets: map[string][]int{},
+		Nodes: map[int]Node{
+			0: makeTargetNode("x", "root", 0),
+			1: makeProjNode("proj", "root", 1),
+			2: makeTargetNode("y", "proj", 2),
+			3: makeArgNode(1, "", "proj", 3),
+			4: &RootNode{id: 4, Field: ".a", Context: "root"},
+			5: makeBoolNode(true, "root", 5),
+		},
+	}
+}
+
+func TestUpstream(t *testing.T) {
+	tests := []struct {
+		name           string
+		id             int
+		withConditions bool
+		want           []int
+	}{
+		{
+			name: "output",
+			id:   0,
+			want: []int{1, 2, 3, 4},
+		},
+		{
+			name:           "output with conditions",
+			id:             0,
+			withConditions: true,
+			want:           []int{1, 2, 3, 4, 5},
+		},
+		{
+			name: "input",
+			id:   4,
+			want: []int{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := queryTestGraph().Upstream(test.id, test.withConditions)
+			if !cmp.Equal(test.want, got) {
+				t.Errorf("expected upstream nodes %v, but got %v", test.want, got)
+			}
+		},
+		)
+	}
+}
+
+func TestDownstream(t *testing.T) {
+	tests := []struct {
+		name           string
+		id             int
+		withConditions bool
+		want           []int
+	}{
+		{
+			name: "input",
+			id:   4,
+			want: []int{0, 1, 2, 3},
+		},
+		{
+			name: "condition",
+			id:   5,
+			want: []int{},
+		},
+		{
+			name:           "condition with conditions",
+			id:             5,
+			withConditions: true,
+			want:           []int{0},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := queryTestGraph().Downstream(test.id, test.withConditions)
+			if !cmp.Equal(test.want, got) {
+				t.Errorf("expected downstream nodes %v, but got %v", test.want, got)
+			}
+		},
+		)
+	}
+}
+
+// TestDownstream_DiscardingBuiltin checks that an input passed to $Void, whose value is always
+// null, doesn't reach the output, nor do its labels.
+func TestDownstream_DiscardingBuiltin(t *testing.T) {
+	g, err := New(makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+		makeMappingMsg("x", makeProjSourceMsg("$Void", makeArgMsg(1, ".ssn"), nil), nil),
+	}))
+	if err != nil {
+		t.Fatalf("building graph failed:\n%v", err)
+	}
+	ssnIDs := g.FindRootNodes("$root.ssn")
+	xIDs := g.Outputs()["x"]
+	if len(ssnIDs) != 1 || len(xIDs) != 1 {
+		t.Fatalf("expected a node for $root.ssn and for x, but got %v and %v", ssnIDs, xIDs)
+	}
+	for _, id := range g.Downstream(ssnIDs[0], true) {
+		if id == xIDs[0] {
+			t.Errorf("expected $root.ssn not to reach x through $Void, but got downstream nodes %v", g.Downstream(ssnIDs[0], true))
+		}
+	}
+	for _, id := range g.Upstream(xIDs[0], true) {
+		if id == ssnIDs[0] {
+			t.Errorf("expected x not to derive from $root.ssn through $Void, but got upstream nodes %v", g.Upstream(xIDs[0], true))
+		}
+	}
+	g.ApplyLabels([]LabelRule{{Field: "$root.ssn", Labels: []string{"PHI"}}}, true)
+	if labels := g.Labels[xIDs[0]]; len(labels.Derived) > 0 || len(labels.Influenced) > 0 {
+		t.Errorf("expected no labels on x, but got %+v", labels)
+	}
+}
+
+func TestFindTargets(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want []int
+	}{
+		{
+			name: "output",
+			path: "x",
+			want: []int{0},
+		},
+		{
+			name: "nested target",
+			path: "x.y",
+			want: []int{2},
+		},
+		{
+			name: "missing target",
+			path: "x.z",
+			want: []int{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := queryTestGraph().FindTargets(test.path)
+			if !cmp.Equal(test.want, got) {
+				t.Errorf("expected targets %v for path %v, but got %v", test.want, test.path, got)
+			}
+		},
+		)
+	}
+}
+
+func TestFindRootNodes(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want []int
+	}{
+		{
+			name: "field",
+			path: "$root.a",
+			want: []int{4},
+		},
+		{
+			name: "nested field",
+			path: "a.b",
+			want: []int{4},
+		},
+		{
+			name: "other field",
+			path: "$root.b",
+			want: []int{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := queryTestGraph().FindRootNodes(test.path)
+			if !cmp.Equal(test.want, got) {
+				t.Errorf("expected root nodes %v for path %v, but got %v", test.want, test.path, got)
+			}
+		},
+		)
+	}
+}
+
+func TestSubgraph(t *testing.T) {
+	sub := queryTestGraph().Subgraph([]int{0, 1, 5})
+	if len(sub.Nodes) != 3 {
+		t.Errorf("expected 3 nodes in the subgraph, but got %v", sub.Nodes)
+	}
+	wantEdges := map[int][]int{
+		0: ids1(1),
+		1: ids0(),
+		5: ids0(),
+	}
+	if !cmp.Equal(wantEdges, sub.Edges) {
+		t.Errorf("expected subgraph edges %v, but got %v", wantEdges, sub.Edges)
+	}
+	wantConditions := map[int][]int{
+		0: ids1(5),
+	}
+	if !cmp.Equal(wantConditions, sub.ConditionEdges) {
+		t.Errorf("expected subgraph condition edges %v, but got %v", wantConditions, sub.ConditionEdges)
+	}
+}
@@ -0,0 +1,113 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// maxExamples is the number of distinct example values kept for each input field.
+const maxExamples = 3
+
+// FieldSamples summarises the values of an input field in a set of sample inputs.
+type FieldSamples struct {
+	Samples  int
+	Nulls    int
+	Examples []interface{} // the first distinct values that aren't null
+}
+
+// NullRate returns the fraction of the samples in which the field is null or missing.
+func (s FieldSamples) NullRate() float64 {
+	if s.Samples == 0 {
+		return 0
+	}
+	return float64(s.Nulls) / float64(s.Samples)
+}
+
+// BranchCounts counts the sample inputs on which the conditions of a target are all met, on which
+// one isn't, and on which they can't be evaluated, such as when they call a projector.
+type BranchCounts struct {
+	Taken    int
+	NotTaken int
+	Unknown  int
+}
+
+// ApplySamples evaluates the graph on sample inputs, decoded from JSON. It sets the graph's Samples
+// to the values of each $root input field, and its Branches to how often the conditions of each
+// guarded target are met, as evaluated by EvaluateOn.
+func (g *Graph) ApplySamples(samples []interface{}) {
+	if len(samples) == 0 {
+		return
+	}
+	g.Samples = map[int]FieldSamples{}
+	g.Branches = map[int]BranchCounts{}
+	for id, node := range g.Nodes {
+		root, ok := node.(*RootNode)
+		if !ok {
+			continue
+		}
+		stats := FieldSamples{Examples: []interface{}{}}
+		seen := map[string]bool{}
+		for _, sample := range samples {
+			stats.Samples++
+			value, _ := readField(sample, root.Field)
+			if isNil(value) {
+				stats.Nulls++
+				continue
+			}
+			key, err := json.Marshal(value)
+			if err != nil || seen[string(key)] || len(stats.Examples) == maxExamples {
+				continue
+			}
+			seen[string(key)] = true
+			stats.Examples = append(stats.Examples, value)
+		}
+		g.Samples[id] = stats
+	}
+
+	for id, conditionIDs := range g.ConditionEdges {
+		if len(conditionIDs) == 0 {
+			continue
+		}
+		counts := BranchCounts{}
+		for _, sample := range samples {
+			met, notMet := true, false
+			for _, conditionID := range conditionIDs {
+				value, ok := g.EvaluateOn(conditionID, sample)
+				met = met && ok && value == true
+				notMet = notMet || ok && value != true
+			}
+			switch {
+			case met:
+				counts.Taken++
+			case notMet:
+				counts.NotTaken++
+			default:
+				counts.Unknown++
+			}
+		}
+		g.Branches[id] = counts
+	}
+}
+
+// samplesLabel is the line showing the null rate and example values of an input field.
+func samplesLabel(stats FieldSamples) string {
+	examples := []string{}
+	for _, example := range stats.Examples {
+		examples = append(examples, shortJSON(example))
+	}
+	label := fmt.Sprintf("\n%.0f%% null", stats.NullRate()*100)
+	if len(examples) > 0 {
+		label += ", e.g. " + strings.Join(examples, ", ")
+	}
+	return label
+}
+
+// branchLabel is the line showing how often the branch a condition guards is taken.
+func branchLabel(counts BranchCounts) string {
+	label := fmt.Sprintf("\ntaken %v/%v", counts.Taken, counts.Taken+counts.NotTaken+counts.Unknown)
+	if counts.Unknown > 0 {
+		label += fmt.Sprintf(" (%v unknown)", counts.Unknown)
+	}
+	return label
+}
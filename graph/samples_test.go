@@ -0,0 +1,90 @@
+package graph
+
+import (
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestApplySamples(t *testing.T) {
+	// final: "yes" if $Eq($root.status, "final"), and Patient: PatientP($root.patient), where
+	// PatientP writes the name only if it isn't nil, and the id if $HasID($root.patient.id).
+	mpc := makeMappingConfigMsg(
+		[]*mbp.ProjectorDefinition{
+			makeProjDefMsg("PatientP", []*mbp.FieldMapping{
+				makeMappingMsg("name", makeArgMsg(1, ".name"), makeProjSourceMsg("$IsNotNil", makeArgMsg(1, ".name"), nil)),
+			}),
+			makeProjDefMsg("HasID", []*mbp.FieldMapping{
+				makeMappingMsg("x", makeBoolMsg(true), nil),
+			}),
+		},
+		[]*mbp.FieldMapping{
+			makeMappingMsg("final", makeStringMsg("yes"), makeProjSourceMsg("$Eq", makeArgMsg(1, ".status"), []*mbp.ValueSource{makeStringMsg("final")})),
+			makeMappingMsg("Patient", makeProjSourceMsg("PatientP", makeArgMsg(1, ".patient"), nil), nil),
+			makeMappingMsg("id", makeStringMsg("1"), makeProjSourceMsg("HasID", makeArgMsg(1, ".patient.id"), nil)),
+		})
+	samples := []interface{}{
+		map[string]interface{}{"status": "draft", "patient": map[string]interface{}{"name": "Ann"}},
+		map[string]interface{}{"status": "draft", "patient": map[string]interface{}{}},
+		map[string]interface{}{"status": "amended"},
+		map[string]interface{}{"status": "draft", "patient": map[string]interface{}{"name": "Bob"}},
+	}
+	tests := []struct {
+		name         string
+		summarise    bool
+		wantSamples  map[string]FieldSamples
+		wantBranches map[string]BranchCounts
+	}{
+		{
+			name: "inlined",
+			wantSamples: map[string]FieldSamples{
+				".status":     {Samples: 4, Examples: []interface{}{"draft", "amended"}},
+				".patient":    {Samples: 4, Nulls: 2, Examples: []interface{}{map[string]interface{}{"name": "Ann"}, map[string]interface{}{"name": "Bob"}}},
+				".patient.id": {Samples: 4, Nulls: 4, Examples: []interface{}{}},
+			},
+			wantBranches: map[string]BranchCounts{
+				"final": {NotTaken: 4},
+				"name":  {Taken: 2, NotTaken: 2},
+				"id":    {Unknown: 4},
+			},
+		},
+		{
+			name:      "summarised",
+			summarise: true,
+			wantSamples: map[string]FieldSamples{
+				".status":     {Samples: 4, Examples: []interface{}{"draft", "amended"}},
+				".patient":    {Samples: 4, Nulls: 2, Examples: []interface{}{map[string]interface{}{"name": "Ann"}, map[string]interface{}{"name": "Bob"}}},
+				".patient.id": {Samples: 4, Nulls: 4, Examples: []interface{}{}},
+			},
+			wantBranches: map[string]BranchCounts{
+				"final": {NotTaken: 4},
+				"name":  {Taken: 2, NotTaken: 2},
+				"id":    {Unknown: 4},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := NewWithOptions(mpc, Options{Summarise: test.summarise, Samples: samples})
+			if err != nil {
+				t.Fatalf("NewWithOptions() returned unexpected error: %v", err)
+			}
+			gotSamples := map[string]FieldSamples{}
+			for id, stats := range g.Samples {
+				gotSamples[g.Nodes[id].(*RootNode).Field] = stats
+			}
+			if diff := cmp.Diff(test.wantSamples, gotSamples); diff != "" {
+				t.Errorf("Samples mismatch (-want +got):\n%s", diff)
+			}
+			gotBranches := map[string]BranchCounts{}
+			for id, counts := range g.Branches {
+				gotBranches[g.Nodes[id].(*TargetNode).Name] = counts
+			}
+			if diff := cmp.Diff(test.wantBranches, gotBranches); diff != "" {
+				t.Errorf("Branches mismatch (-want +got):\n%s", diff)
+			}
+		},
+		)
+	}
+}
@@ -0,0 +1,173 @@
+package graph
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Schema describes the fields of an input or output, such as a JSON Schema or a set of FHIR
+// StructureDefinitions. See the schema package for loaders.
+type Schema interface {
+	// Lookup returns the element at a path of field names, without array indices.
+	Lookup(path []string) (SchemaElement, bool)
+}
+
+// SchemaElement is a field of a schema. Max is "*" for a repeated field. An empty Type means the
+// node hasn't been resolved against a schema.
+type SchemaElement struct {
+	Path string
+	Type string
+	Min  int
+	Max  string
+}
+
+func (e SchemaElement) String() string {
+	return fmt.Sprintf("%v [%v..%v]", e.Type, e.Min, e.Max)
+}
+
+var indexPattern = regexp.MustCompile(`\[[^\]]*\]`)
+
+// schemaPath splits a target name or input field into field names, dropping array indices and
+// the overwrite suffix.
+func schemaPath(name string) []string {
+	path := []string{}
+	for _, field := range strings.Split(indexPattern.ReplaceAllString(name, ""), ".") {
+		if field = strings.TrimSuffix(field, "!"); field != "" {
+			path = append(path, field)
+		}
+	}
+	return path
+}
+
+// ApplySchemas resolves the $root input fields against the input schema and the output targets
+// against the output schema, setting their Schema. Output targets are resolved by their full
+// path, as returned by OutputPaths. Paths missing from a schema are reported as UnknownField diagnostics. Either schema may be nil.
+func (g *Graph) ApplySchemas(input Schema, output Schema) {
+	if input != nil {
+		ids := []int{}
+		for id, node := range g.Nodes {
+			if _, ok := node.(*RootNode); ok {
+				ids = append(ids, id)
+			}
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			root := g.Nodes[id].(*RootNode)
+			if path := schemaPath(root.Field); len(path) > 0 {
+				g.resolve(id, path, input, &root.Schema, "input field")
+			}
+		}
+	}
+	if output != nil {
+		for _, path := range g.OutputPaths() {
+			target := g.Nodes[path.ID].(*TargetNode)
+			g.resolve(path.ID, path.Path, output, &target.Schema, "output field")
+		}
+	}
+}
+
+func (g *Graph) resolve(id int, path []string, schema Schema, element *SchemaElement, kind string) {
+	if found, ok := schema.Lookup(path); ok {
+		*element = found
+		return
+	}
+	g.Diagnostics = append(g.Diagnostics, Diagnostic{
+		Kind:    UnknownField,
+		Message: fmt.Sprintf("%v %v is not in the schema", kind, strings.Join(path, ".")),
+		Context: Context(g.Nodes[id]),
+		NodeID:  id,
+	})
+}
+
+// FieldPath is the full path of an input field or output target, without array indices.
+type FieldPath struct {
+	ID   int
+	Path []string
+}
+
+// OutputPaths returns the full output path of every target written to the output, in node order.
+// The path of a target written in a projector is the path of the target the projector's result
+// is written to followed by its own name. A target reached through more than one path, as in a
+// summarised graph, is listed with the first.
+func (g Graph) OutputPaths() []FieldPath {
+	paths := []FieldPath{}
+	visited := map[int]bool{}
+	var visit func(id int, parent []string)
+	visit = func(id int, parent []string) {
+		target, ok := g.Nodes[id].(*TargetNode)
+		if !ok || target.IsVariable || visited[id] {
+			return
+		}
+		visited[id] = true
+		path := append(append([]string{}, parent...), schemaPath(target.Name)...)
+		paths = append(paths, FieldPath{ID: id, Path: path})
+		for _, ancestorID := range g.Edges[id] {
+			if _, ok := g.Nodes[ancestorID].(*ProjectorNode); ok {
+				for _, bodyID := range g.Edges[ancestorID] {
+					visit(bodyID, path)
+				}
+			}
+		}
+	}
+	outputIDs := []int{}
+	for _, ids := range g.Outputs() {
+		outputIDs = append(outputIDs, ids...)
+	}
+	sort.Ints(outputIDs)
+	for _, id := range outputIDs {
+		visit(id, nil)
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i].ID < paths[j].ID })
+	return paths
+}
+
+// InputPaths returns the full input path of every $root field read, and of every field read from
+// an argument bound to one, in node order. The path of an argument's field is the path of the
+// input passed to the projector followed by the field. An argument bound to several inputs is
+// listed once per input.
+func (g Graph) InputPaths() []FieldPath {
+	paths := []FieldPath{}
+	var inputPaths func(id int, visiting map[int]bool) [][]string
+	inputPaths = func(id int, visiting map[int]bool) [][]string {
+		if visiting[id] {
+			return nil
+		}
+		visiting[id] = true
+		defer delete(visiting, id)
+		switch n := g.Nodes[id].(type) {
+		case *RootNode:
+			return [][]string{schemaPath(n.Field)}
+		case *ArgumentNode:
+			result := [][]string{}
+			for _, ancestorID := range g.Edges[id] {
+				field := schemaPath(n.Field)
+				if isBinding(n, g.Nodes[ancestorID]) {
+					field = nil
+				}
+				for _, path := range inputPaths(ancestorID, visiting) {
+					result = append(result, append(append([]string{}, path...), field...))
+				}
+			}
+			return result
+		default:
+			return nil
+		}
+	}
+	ids := []int{}
+	for id := range g.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		seen := map[string]bool{}
+		for _, path := range inputPaths(id, map[int]bool{}) {
+			if key := strings.Join(path, "."); len(path) > 0 && !seen[key] {
+				seen[key] = true
+				paths = append(paths, FieldPath{ID: id, Path: path})
+			}
+		}
+	}
+	return paths
+}
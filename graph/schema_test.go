@@ -0,0 +1,132 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeSchema maps a dotted path to its element.
+type fakeSchema map[string]SchemaElement
+
+func (s fakeSchema) Lookup(path []string) (SchemaElement, bool) {
+	element, ok := s[strings.Join(path, ".")]
+	return element, ok
+}
+
+func TestApplySchemas(t *testing.T) {
+	input := fakeSchema{"gender": {Path: "gender", Type: "string", Min: 0, Max: "1"}}
+	output := fakeSchema{
+		"Patient":        {Path: "Patient", Type: "Patient", Min: 0, Max: "1"},
+		"Patient.gender": {Path: "Patient.gender", Type: "code", Min: 0, Max: "1"},
+	}
+	tests := []struct {
+		name            string
+		target          string
+		field           string
+		wantSchema      SchemaElement
+		wantDiagnostics []string
+	}{
+		{
+			name:       "known fields",
+			target:     "gender",
+			field:      ".gender",
+			wantSchema: output["Patient.gender"],
+		},
+		{
+			name:            "misspelt target",
+			target:          "gendr",
+			field:           ".gender",
+			wantDiagnostics: []string{"output field Patient.gendr is not in the schema"},
+		},
+		{
+			name:            "misspelt input",
+			target:          "gender[]",
+			field:           ".sex",
+			wantSchema:      output["Patient.gender"],
+			wantDiagnostics: []string{"input field sex is not in the schema"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mpc := makeMappingConfigMsg(
+				[]*mbp.ProjectorDefinition{makeProjDefMsg("Patient", []*mbp.FieldMapping{
+					makeMappingMsg(test.target, makeArgMsg(1, ""), nil),
+				})},
+				[]*mbp.FieldMapping{
+					makeMappingMsg("Patient", makeProjSourceMsg("Patient", makeArgMsg(1, test.field), nil), nil),
+				})
+			g, err := NewWithOptions(mpc, Options{InputSchema: input, OutputSchema: output})
+			if err != nil {
+				t.Fatalf("building the graph failed:\n%v", err)
+			}
+			for _, node := range g.Nodes {
+				if target, ok := node.(*TargetNode); ok && target.Context == "Patient" {
+					if diff := cmp.Diff(test.wantSchema, target.Schema); diff != "" {
+						t.Errorf("unexpected schema of %v (-want +got):\n%v", target.Name, diff)
+					}
+				}
+			}
+			var messages []string
+			for _, diagnostic := range g.Diagnostics {
+				if diagnostic.Kind != UnknownField {
+					t.Errorf("expected an unknown field diagnostic, but got %v", diagnostic)
+				}
+				messages = append(messages, diagnostic.Message)
+			}
+			if diff := cmp.Diff(test.wantDiagnostics, messages); diff != "" {
+				t.Errorf("unexpected diagnostics (-want +got):\n%v", diff)
+			}
+		},
+		)
+	}
+}
+
+func TestFieldPaths(t *testing.T) {
+	mpc := makeMappingConfigMsg(
+		[]*mbp.ProjectorDefinition{
+			makeProjDefMsg("PatientP", []*mbp.FieldMapping{
+				makeMappingMsg("name.family", makeArgMsg(1, ".name.last"), nil),
+				makeMappingMsg("contact", makeProjSourceMsg("ContactP", makeArgMsg(1, ".contact"), nil), nil),
+			}),
+			makeProjDefMsg("ContactP", []*mbp.FieldMapping{
+				makeMappingMsg("telecom[]", makeArgMsg(1, ".phone"), nil),
+			}),
+		},
+		[]*mbp.FieldMapping{
+			makeMappingMsg("Patient", makeProjSourceMsg("PatientP", makeArgMsg(1, ".patient"), nil), nil),
+		})
+	for _, summarise := range []bool{false, true} {
+		t.Run(fmt.Sprintf("summarise %v", summarise), func(t *testing.T) {
+			g, err := NewWithOptions(mpc, Options{Summarise: summarise})
+			if err != nil {
+				t.Fatalf("building the graph failed:\n%v", err)
+			}
+			paths := func(fieldPaths []FieldPath) []string {
+				joined := []string{}
+				seen := map[string]bool{} // a summarised template and its bindings read the same fields
+				for _, path := range fieldPaths {
+					if p := strings.Join(path.Path, "."); !seen[p] {
+						seen[p] = true
+						joined = append(joined, p)
+					}
+				}
+				sort.Strings(joined)
+				return joined
+			}
+			wantInputs := []string{"patient", "patient.contact", "patient.contact.phone", "patient.name.last"}
+			if diff := cmp.Diff(wantInputs, paths(g.InputPaths())); diff != "" {
+				t.Errorf("unexpected input paths (-want +got):\n%v", diff)
+			}
+			wantOutputs := []string{"Patient", "Patient.contact", "Patient.contact.telecom", "Patient.name.family"}
+			if diff := cmp.Diff(wantOutputs, paths(g.OutputPaths())); diff != "" {
+				t.Errorf("unexpected output paths (-want +got):\n%v", diff)
+			}
+		},
+		)
+	}
+}
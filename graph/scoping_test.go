@@ -0,0 +1,334 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestScoping checks that variables, destinations and anonymous blocks are scoped like in the
+// mapping engine. Each case is built from the whistle mapping it describes, with $anon_block_N
+// standing for the N-th anonymous block, and states the output the mapping engine writes, which is
+// checked by running the mapping with the engine through Trace, the lineage of the static graph,
+// and the lineage of the engine's run, with the values it read.
+func TestScoping(t *testing.T) {
+	input := map[string]interface{}{"a": 1.0, "b": 2.0}
+	block := func(n string, mappings ...*mbp.FieldMapping) *mbp.ProjectorDefinition {
+		return makeProjDefMsg(anon_prefix+n, mappings)
+	}
+	tests := []struct {
+		name        string
+		whistle     string
+		mpc         *mbp.MappingConfig
+		wantLineage map[string][]string // the inputs and constants each target derives from, by context.name
+		wantTraced  map[string][]string // the same for the engine's run, with their values
+		wantOutput  interface{}
+		wantErr     bool // the engine fails to run the mapping, and the graph can't be built
+	}{
+		{
+			name: "variable visible in a block",
+			whistle: `
+			var v: $root.a
+			x: { y: v }`,
+			mpc: makeMappingConfigMsg(
+				[]*mbp.ProjectorDefinition{block("1", makeMappingMsg("y", makeLocalVarMsg("v"), nil))},
+				[]*mbp.FieldMapping{
+					makeVarMappingMsg("v", makeArgMsg(1, ".a"), nil),
+					makeMappingMsg("x", makeProjValMsg(anon_prefix+"1"), nil),
+				}),
+			wantLineage: map[string][]string{
+				"root.x":            {"$root field .a"},
+				anon_prefix + "1.y": {"$root field .a"},
+			},
+			wantTraced: map[string][]string{
+				"root.x":            {"$root field .a = 1"},
+				anon_prefix + "1.y": {"$root field .a = 1"},
+			},
+			wantOutput: map[string]interface{}{"x": map[string]interface{}{"y": 1.0}},
+		},
+		{
+			name: "variable visible in a nested block",
+			whistle: `
+			var v: $root.a
+			x: { y: { z: v } }`,
+			mpc: makeMappingConfigMsg(
+				[]*mbp.ProjectorDefinition{
+					block("1", makeMappingMsg("y", makeProjValMsg(anon_prefix+"2"), nil)),
+					block("2", makeMappingMsg("z", makeLocalVarMsg("v"), nil)),
+				},
+				[]*mbp.FieldMapping{
+					makeVarMappingMsg("v", makeArgMsg(1, ".a"), nil),
+					makeMappingMsg("x", makeProjValMsg(anon_prefix+"1"), nil),
+				}),
+			wantLineage: map[string][]string{
+				"root.x":            {"$root field .a"},
+				anon_prefix + "1.y": {"$root field .a"},
+				anon_prefix + "2.z": {"$root field .a"},
+			},
+			wantTraced: map[string][]string{
+				"root.x":            {"$root field .a = 1"},
+				anon_prefix + "1.y": {"$root field .a = 1"},
+				anon_prefix + "2.z": {"$root field .a = 1"},
+			},
+			wantOutput: map[string]interface{}{"x": map[string]interface{}{"y": map[string]interface{}{"z": 1.0}}},
+		},
+		{
+			name: "variable shadowed in a block",
+			whistle: `
+			var v: $root.a
+			x: {
+				y: v
+				var v: $root.b
+				z: v
+			}
+			w: v`,
+			mpc: makeMappingConfigMsg(
+				[]*mbp.ProjectorDefinition{block("1",
+					makeMappingMsg("y", makeLocalVarMsg("v"), nil),
+					makeVarMappingMsg("v", makeArgMsg(1, ".b"), nil),
+					makeMappingMsg("z", makeLocalVarMsg("v"), nil),
+				)},
+				[]*mbp.FieldMapping{
+					makeVarMappingMsg("v", makeArgMsg(1, ".a"), nil),
+					makeMappingMsg("x", makeProjValMsg(anon_prefix+"1"), nil),
+					makeMappingMsg("w", makeLocalVarMsg("v"), nil),
+				}),
+			wantLineage: map[string][]string{
+				"root.x":            {"$root field .a", "$root field .b"},
+				"root.w":            {"$root field .a"},
+				anon_prefix + "1.y": {"$root field .a"},
+				anon_prefix + "1.z": {"$root field .b"},
+			},
+			wantTraced: map[string][]string{
+				"root.x":            {"$root field .a = 1", "$root field .b = 2"},
+				"root.w":            {"$root field .a = 1"},
+				anon_prefix + "1.y": {"$root field .a = 1"},
+				anon_prefix + "1.z": {"$root field .b = 2"},
+			},
+			wantOutput: map[string]interface{}{"x": map[string]interface{}{"y": 1.0, "z": 2.0}, "w": 1.0},
+		},
+		{
+			name: "variable of a block not visible outside it",
+			whistle: `
+			x: {
+				var v: 1
+				y: v
+			}
+			z: v`,
+			mpc: makeMappingConfigMsg(
+				[]*mbp.ProjectorDefinition{block("1",
+					makeVarMappingMsg("v", makeIntMsg(1), nil),
+					makeMappingMsg("y", makeLocalVarMsg("v"), nil),
+				)},
+				[]*mbp.FieldMapping{
+					makeMappingMsg("x", makeProjValMsg(anon_prefix+"1"), nil),
+					makeMappingMsg("z", makeLocalVarMsg("v"), nil),
+				}),
+			wantErr: true,
+		},
+		{
+			name: "variable of the caller not visible in a projector",
+			whistle: `
+			var v: 1
+			x: proj1()
+			def proj1() {
+				y: v
+			}`,
+			mpc: makeMappingConfigMsg(
+				[]*mbp.ProjectorDefinition{makeProjDefMsg("proj1", []*mbp.FieldMapping{makeMappingMsg("y", makeLocalVarMsg("v"), nil)})},
+				[]*mbp.FieldMapping{
+					makeVarMappingMsg("v", makeIntMsg(1), nil),
+					makeMappingMsg("x", makeProjValMsg("proj1"), nil),
+				}),
+			wantErr: true,
+		},
+		{
+			name: "destination of the enclosing output read in a block",
+			whistle: `
+			a: $root.a
+			x: { y: dest a }`,
+			mpc: makeMappingConfigMsg(
+				[]*mbp.ProjectorDefinition{block("1", makeMappingMsg("y", makeDestValSourceMsg("a"), nil))},
+				[]*mbp.FieldMapping{
+					makeMappingMsg("a", makeArgMsg(1, ".a"), nil),
+					makeMappingMsg("x", makeProjValMsg(anon_prefix+"1"), nil),
+				}),
+			wantLineage: map[string][]string{
+				"root.a":            {"$root field .a"},
+				"root.x":            {"$root field .a"},
+				anon_prefix + "1.y": {"$root field .a"},
+			},
+			wantTraced: map[string][]string{
+				"root.a":            {"$root field .a = 1"},
+				"root.x":            {"$root field .a = 1"},
+				anon_prefix + "1.y": {"$root field .a = 1"},
+			},
+			wantOutput: map[string]interface{}{"a": 1.0, "x": map[string]interface{}{"y": 1.0}},
+		},
+		{
+			name: "destination shadowed in a block",
+			whistle: `
+			a: $root.a
+			x: {
+				a: $root.b
+				y: dest a
+			}`,
+			mpc: makeMappingConfigMsg(
+				[]*mbp.ProjectorDefinition{block("1",
+					makeMappingMsg("a", makeArgMsg(1, ".b"), nil),
+					makeMappingMsg("y", makeDestValSourceMsg("a"), nil),
+				)},
+				[]*mbp.FieldMapping{
+					makeMappingMsg("a", makeArgMsg(1, ".a"), nil),
+					makeMappingMsg("x", makeProjValMsg(anon_prefix+"1"), nil),
+				}),
+			wantLineage: map[string][]string{
+				"root.a":            {"$root field .a"},
+				"root.x":            {"$root field .b"},
+				anon_prefix + "1.a": {"$root field .b"},
+				anon_prefix + "1.y": {"$root field .b"},
+			},
+			wantTraced: map[string][]string{
+				"root.a":            {"$root field .a = 1"},
+				"root.x":            {"$root field .b = 2"},
+				anon_prefix + "1.a": {"$root field .b = 2"},
+				anon_prefix + "1.y": {"$root field .b = 2"},
+			},
+			wantOutput: map[string]interface{}{"a": 1.0, "x": map[string]interface{}{"a": 2.0, "y": 2.0}},
+		},
+		{
+			name: "block written into the enclosing output",
+			whistle: `
+			x: proj1()
+			def proj1() {
+				$this: { a: $root.a }
+				b: dest a
+			}`,
+			mpc: makeMappingConfigMsg(
+				[]*mbp.ProjectorDefinition{
+					block("1", makeMappingMsg("a", makeArgMsg(1, ".a"), nil)),
+					makeProjDefMsg("proj1", []*mbp.FieldMapping{
+						makeMappingMsg(this_keyword, makeProjValMsg(anon_prefix+"1"), nil),
+						makeMappingMsg("b", makeDestValSourceMsg("a"), nil),
+					}),
+				},
+				[]*mbp.FieldMapping{
+					makeMappingMsg("x", makeProjValMsg("proj1"), nil),
+				}),
+			wantLineage: map[string][]string{
+				"root.x":            {"$root field .a"},
+				"proj1.$this":       {"$root field .a"},
+				"proj1.b":           {"$root field .a"},
+				anon_prefix + "1.a": {"$root field .a"},
+			},
+			wantTraced: map[string][]string{
+				"root.x":            {"$root field .a = 1"},
+				"proj1.$this":       {"$root field .a = 1"},
+				"proj1.b":           {"$root field .a = 1"},
+				anon_prefix + "1.a": {"$root field .a = 1"},
+			},
+			wantOutput: map[string]interface{}{"x": map[string]interface{}{"a": 1.0, "b": 1.0}},
+		},
+		{
+			name: "destination written by a block, as in examples/whistle/4.wstl",
+			whistle: `
+			a: {
+				b: "false value"
+			}
+			x: dest a.b`,
+			mpc: makeMappingConfigMsg(
+				[]*mbp.ProjectorDefinition{block("1", makeMappingMsg("b", makeStringMsg("false value"), nil))},
+				[]*mbp.FieldMapping{
+					makeMappingMsg("a", makeProjValMsg(anon_prefix+"1"), nil),
+					makeMappingMsg("x", makeDestValSourceMsg("a.b"), nil),
+				}),
+			wantLineage: map[string][]string{
+				"root.a":            {`"false value"`},
+				"root.x":            {`"false value"`},
+				anon_prefix + "1.b": {`"false value"`},
+			},
+			wantTraced: map[string][]string{
+				"root.a":            {`"false value" = "false value"`},
+				"root.x":            {`"false value" = "false value"`},
+				anon_prefix + "1.b": {`"false value" = "false value"`},
+			},
+			wantOutput: map[string]interface{}{"a": map[string]interface{}{"b": "false value"}, "x": "false value"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := New(test.mpc)
+			_, _, traceErr := Trace(test.mpc, input, Options{})
+			if test.wantErr {
+				if err == nil || traceErr == nil {
+					t.Errorf("expected errors building the graph and running the mapping, but got %v and %v", err, traceErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("building the graph failed:\n%v", err)
+			}
+			if diff := cmp.Diff(test.wantLineage, leafLineage(t, g, getNodeLabel)); diff != "" {
+				t.Errorf("unexpected lineage (-want +got):\n%v", diff)
+			}
+
+			traced, output, err := Trace(test.mpc, input, Options{})
+			if err != nil {
+				t.Fatalf("running the mapping failed:\n%v", err)
+			}
+			if diff := cmp.Diff(test.wantOutput, output); diff != "" {
+				t.Errorf("unexpected output of the mapping engine (-want +got):\n%v", diff)
+			}
+			valueLabel := func(node Node) (string, error) {
+				label, err := getNodeLabel(node)
+				if err != nil {
+					return "", err
+				}
+				value, err := json.Marshal(traced.Values[node.ID()])
+				return fmt.Sprintf("%v = %s", label, value), err
+			}
+			if diff := cmp.Diff(test.wantTraced, leafLineage(t, traced, valueLabel)); diff != "" {
+				t.Errorf("unexpected traced lineage (-want +got):\n%v", diff)
+			}
+		},
+		)
+	}
+}
+
+// leafLineage returns the labels of the nodes without ancestors that each target, other than a
+// variable, derives from, keyed by the target's context and name.
+func leafLineage(t *testing.T, g Graph, nodeLabel func(Node) (string, error)) map[string][]string {
+	lineage := map[string][]string{}
+	for id, node := range g.Nodes {
+		target, ok := node.(*TargetNode)
+		if !ok || target.IsVariable {
+			continue
+		}
+		key := target.Context + "." + target.Name
+		seen := map[string]bool{}
+		for _, ancestor := range lineage[key] {
+			seen[ancestor] = true
+		}
+		for _, ancestorID := range g.Upstream(id, false) {
+			if len(g.Edges[ancestorID]) > 0 || len(g.ArgumentEdges[ancestorID]) > 0 {
+				continue
+			}
+			label, err := nodeLabel(g.Nodes[ancestorID])
+			if err != nil {
+				t.Fatalf("labelling %v returned unexpected error: %v", g.Nodes[ancestorID], err)
+			}
+			label = strings.ReplaceAll(label, "\n", " ")
+			if !seen[label] {
+				seen[label] = true
+				lineage[key] = append(lineage[key], label)
+			}
+		}
+		sort.Strings(lineage[key])
+	}
+	return lineage
+}
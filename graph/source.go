@@ -0,0 +1,381 @@
+package graph
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Source is an index of where the projectors, targets and inputs of a whistle mapping appear in its
+// source text. The transpiled MappingConfig carries no positions, so the index is built by
+// scanning the text; it is best-effort and is used to fill in the FileMetaData of nodes.
+// Lines and characters are 1-based.
+type Source struct {
+	FileName string
+	// Defs holds the position of each projector definition's name.
+	Defs map[string]FileMetaData
+	// Params holds the declared parameter names of each projector definition.
+	Params map[string][]string
+
+	lineStarts  []int
+	occurrences map[occurrenceKey][]FileMetaData
+}
+
+// occurrenceKey identifies a construct appearing in the body of a projector ("root" for root mappings).
+type occurrenceKey struct {
+	context string
+	kind    string
+	name    string
+}
+
+const (
+	targetOccurrence    = "target"
+	projectorOccurrence = "projector"
+	rootOccurrence      = "root"
+	argOccurrence       = "arg"
+)
+
+var (
+	defPattern     = regexp.MustCompile(`\bdef\s+([$A-Za-z_][$\w]*)\s*\(([^)]*)\)\s*\{`)
+	targetPattern  = regexp.MustCompile(`(?m)(?:^|[{;,])[ \t]*(?:(?:var|root|out)[ \t]+)?([A-Za-z_][\w.]*(?:\[\])?)[ \t]*:`)
+	callPattern    = regexp.MustCompile(`([$A-Za-z_][$\w]*)\s*\(`)
+	rootPattern    = regexp.MustCompile(`\$root((?:\.[\w\[\]]+)*)`)
+	pathPattern    = regexp.MustCompile(`[$A-Za-z_][$\w]*(?:\.[\w\[\]]+)*`)
+	commentPattern = regexp.MustCompile(`//[^\n]*`)
+	stringPattern  = regexp.MustCompile(`"(?:[^"\\\n]|\\.)*"`)
+)
+
+// ParseSource indexes the whistle source text of the named file.
+func ParseSource(fileName string, whistle string) *Source {
+	s := &Source{
+		FileName:    fileName,
+		Defs:        map[string]FileMetaData{},
+		Params:      map[string][]string{},
+		lineStarts:  []int{0},
+		occurrences: map[occurrenceKey][]FileMetaData{},
+	}
+	for i, c := range whistle {
+		if c == '\n' {
+			s.lineStarts = append(s.lineStarts, i+1)
+		}
+	}
+
+	// blank out comments and string contents so they can't be mistaken for code
+	code := commentPattern.ReplaceAllStringFunc(whistle, blank)
+	code = stringPattern.ReplaceAllStringFunc(code, func(str string) string {
+		return "\"" + blank(str[1:len(str)-1]) + "\""
+	})
+
+	// the root mappings are everything outside of the projector definitions
+	rootMask := []byte(code)
+	for _, match := range defPattern.FindAllStringSubmatchIndex(code, -1) {
+		name := code[match[2]:match[3]]
+		s.Defs[name] = s.position(match[2], match[3])
+		params := []string{}
+		for _, param := range strings.Split(code[match[4]:match[5]], ",") {
+			if param = strings.TrimSpace(param); param != "" {
+				params = append(params, param)
+			}
+		}
+		s.Params[name] = params
+
+		bodyStart := match[1]
+		bodyEnd := matchingBrace(code, bodyStart-1)
+		body := blankOutside(code, bodyStart, bodyEnd)
+		s.indexBody(name, body, params)
+		for i := match[0]; i < bodyEnd && i < len(rootMask); i++ {
+			if rootMask[i] != '\n' {
+				rootMask[i] = ' '
+			}
+		}
+	}
+	s.indexBody(rootContext, string(rootMask), nil)
+	return s
+}
+
+// indexBody records the occurrences in the body of a projector. The body is the full text with
+// everything outside the projector blanked out, so offsets are offsets into the file.
+func (s *Source) indexBody(context string, body string, params []string) {
+	for _, match := range targetPattern.FindAllStringSubmatchIndex(body, -1) {
+		s.addOccurrence(context, targetOccurrence, body[match[2]:match[3]], match[2], match[3])
+	}
+	for _, match := range callPattern.FindAllStringSubmatchIndex(body, -1) {
+		name := body[match[2]:match[3]]
+		if name == "def" || name == "if" || strings.HasSuffix(strings.TrimRight(body[:match[2]], " \t"), "def") {
+			continue
+		}
+		s.addOccurrence(context, projectorOccurrence, name, match[2], match[3])
+	}
+	for _, match := range rootPattern.FindAllStringSubmatchIndex(body, -1) {
+		s.addOccurrence(context, rootOccurrence, normalizeField(body[match[2]:match[3]]), match[0], match[1])
+	}
+	paramIndices := map[string]int{}
+	for i, param := range params {
+		paramIndices[param] = i + 1
+	}
+	for _, match := range pathPattern.FindAllStringIndex(body, -1) {
+		path := body[match[0]:match[1]]
+		head := strings.SplitN(path, ".", 2)[0]
+		index, ok := paramIndices[head]
+		if !ok {
+			continue
+		}
+		if next := strings.TrimLeft(body[match[1]:], " \t"); strings.HasPrefix(next, "(") || strings.HasPrefix(next, ":") {
+			continue // a projector call or a target named like the parameter
+		}
+		s.addOccurrence(context, argOccurrence, argName(index, path[len(head):]), match[0], match[1])
+	}
+}
+
+func (s *Source) addOccurrence(context string, kind string, name string, start int, end int) {
+	key := occurrenceKey{context: context, kind: kind, name: name}
+	s.occurrences[key] = append(s.occurrences[key], s.position(start, end))
+}
+
+// position converts a range of byte offsets into the file to a FileMetaData.
+func (s *Source) position(start int, end int) FileMetaData {
+	startLine, startChar := s.lineAndChar(start)
+	endLine, endChar := s.lineAndChar(end)
+	return FileMetaData{
+		FileName:  s.FileName,
+		LineStart: startLine,
+		LineEnd:   endLine,
+		CharStart: startChar,
+		CharEnd:   endChar,
+	}
+}
+
+func (s *Source) lineAndChar(offset int) (int, int) {
+	line := sort.Search(len(s.lineStarts), func(i int) bool { return s.lineStarts[i] > offset }) - 1
+	return line + 1, offset - s.lineStarts[line] + 1
+}
+
+// Annotate fills in the FileMetaData of the targets, projectors, arguments and $root inputs of the
+// graph, and the names of the arguments that haven't got one.
+// Nodes inside anonymous blocks are located in the projector the block is written in. When a
+// projector is expanded more than once, every expansion shares the positions of the definition.
+// Nodes that can't be matched to a position are left without one.
+func (s *Source) Annotate(g Graph) {
+	// anonymous blocks are named after the projector they are called from
+	blockContexts := map[string]string{}
+	for _, node := range g.Nodes {
+		if p, ok := node.(*ProjectorNode); ok && strings.HasPrefix(p.Name, anon_prefix) {
+			blockContexts[p.Name] = p.Context
+		}
+	}
+	sourceContext := func(context string) string {
+		for i := 0; i < len(blockContexts) && strings.HasPrefix(context, anon_prefix); i++ {
+			context = blockContexts[context]
+		}
+		return context
+	}
+
+	groups := map[occurrenceKey][]int{}
+	for id, node := range g.Nodes {
+		var key occurrenceKey
+		switch n := node.(type) {
+		case *TargetNode:
+			key = occurrenceKey{context: sourceContext(n.Context), kind: targetOccurrence, name: n.Name}
+		case *ProjectorNode:
+			key = occurrenceKey{context: sourceContext(n.Context), kind: projectorOccurrence, name: n.Name}
+		case *RootNode:
+			key = occurrenceKey{context: sourceContext(n.Context), kind: rootOccurrence, name: normalizeField(n.Field)}
+		case *ArgumentNode:
+			key = occurrenceKey{context: sourceContext(n.Context), kind: argOccurrence, name: argName(n.Index, normalizeField(n.Field))}
+			if params := s.Params[sourceContext(n.Context)]; n.Name == "" && n.Index > 0 && n.Index <= len(params) {
+				n.Name = params[n.Index-1]
+			}
+		default:
+			continue
+		}
+		groups[key] = append(groups[key], id)
+	}
+
+	// a node takes a position with the same name and context. When the name appears more than once
+	// there, the nodes are matched to the positions in order, which is only known to be right if each
+	// expansion of the projector has a node for every appearance; a trace, which leaves out the
+	// branches not taken, may not, and its nodes are left without a position
+	for key, ids := range groups {
+		positions := s.occurrences[key]
+		if len(positions) == 0 || len(ids)%len(positions) != 0 {
+			continue
+		}
+		sort.Ints(ids) // nodes are numbered in the order they are generated, which follows the source
+		for i, id := range ids {
+			setFileData(g.Nodes[id], positions[i%len(positions)])
+		}
+	}
+
+	// unknown nodes and concept maps take the position of the nearest descendant with one, which
+	// for a concept map is the call reading it
+	descendants := []map[int][]int{reverse(g.Edges), reverse(g.ArgumentEdges), reverse(g.ConditionEdges)}
+	for id, node := range g.Nodes {
+		if _, ok := node.(*ConceptMapNode); ok {
+			setFileData(node, nearestFileData(g, id, descendants))
+		}
+	}
+	for i, diagnostic := range g.Diagnostics {
+		data := nearestFileData(g, diagnostic.NodeID, descendants)
+		setFileData(g.Nodes[diagnostic.NodeID], data)
+		g.Diagnostics[i].Position = data
+	}
+}
+
+// nearestFileData does a breadth-first search from the node for the first node with a position.
+func nearestFileData(g Graph, id int, adjLists []map[int][]int) FileMetaData {
+	visited := map[int]bool{id: true}
+	queue := []int{id}
+	for len(queue) > 0 {
+		id, queue = queue[0], queue[1:]
+		if data := FileData(g.Nodes[id]); data.LineStart > 0 {
+			return data
+		}
+		for _, adjList := range adjLists {
+			for _, nextID := range adjList[id] {
+				if !visited[nextID] {
+					visited[nextID] = true
+					queue = append(queue, nextID)
+				}
+			}
+		}
+	}
+	return FileMetaData{}
+}
+
+// Locate fills in the position of a graph construction error that hasn't got one, from where its
+// name first appears in the projector it occurred in, or else from the projector's definition.
+func (s *Source) Locate(err error) {
+	var gerr Error
+	if !errors.As(err, &gerr) {
+		return
+	}
+	info := gerr.Info()
+	if info.Position.LineStart > 0 {
+		return
+	}
+	var keys []occurrenceKey
+	switch e := gerr.(type) {
+	case *UnknownProjectorError, *ArgumentCountError:
+		keys = []occurrenceKey{{context: info.Context, kind: projectorOccurrence, name: info.Name}}
+	case *RecursionError:
+		keys = []occurrenceKey{
+			{context: info.Context, kind: projectorOccurrence, name: info.Name},
+			{context: info.Context, kind: targetOccurrence, name: info.Name},
+		}
+	case *UnresolvedDestinationError, *UnresolvedLocalVarError:
+		keys = []occurrenceKey{{context: info.Context, kind: targetOccurrence, name: info.Path}}
+	case *ArgumentOutOfRangeError:
+		keys = []occurrenceKey{{context: info.Context, kind: argOccurrence, name: argName(e.Index, normalizeField(info.Path))}}
+	}
+	for _, key := range keys {
+		if positions := s.occurrences[key]; len(positions) > 0 {
+			info.Position = positions[0]
+			return
+		}
+	}
+	if def, ok := s.Defs[info.Context]; ok {
+		info.Position = def
+	}
+}
+
+// NodesAt returns the IDs of the nodes whose FileMetaData covers the given position.
+func (s *Source) NodesAt(g Graph, line int, char int) []int {
+	ids := []int{}
+	for id, node := range g.Nodes {
+		data := FileData(node)
+		if data.FileName == s.FileName && data.LineStart <= line && line <= data.LineEnd &&
+			(line != data.LineStart || data.CharStart <= char) && (line != data.LineEnd || char <= data.CharEnd) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// FileData returns the FileMetaData of any node.
+func FileData(node Node) FileMetaData {
+	switch n := node.(type) {
+	case *TargetNode:
+		return n.FileData
+	case *ConstBoolNode:
+		return n.FileData
+	case *ConstIntNode:
+		return n.FileData
+	case *ConstFloatNode:
+		return n.FileData
+	case *ConstStringNode:
+		return n.FileData
+	case *ProjectorNode:
+		return n.FileData
+	case *ArgumentNode:
+		return n.FileData
+	case *RootNode:
+		return n.FileData
+	case *ConceptMapNode:
+		return n.FileData
+	case *UnknownNode:
+		return n.FileData
+	default:
+		return FileMetaData{}
+	}
+}
+
+func setFileData(node Node, data FileMetaData) {
+	switch n := node.(type) {
+	case *TargetNode:
+		n.FileData = data
+	case *ProjectorNode:
+		n.FileData = data
+	case *ArgumentNode:
+		n.FileData = data
+	case *RootNode:
+		n.FileData = data
+	case *ConceptMapNode:
+		n.FileData = data
+	case *UnknownNode:
+		n.FileData = data
+	}
+}
+
+// matchingBrace returns the offset just past the brace closing the one at the given offset.
+func matchingBrace(code string, open int) int {
+	depth := 0
+	for i := open; i < len(code); i++ {
+		switch code[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(code)
+}
+
+// blankOutside blanks out the text outside of [start, end), keeping newlines so offsets are preserved.
+func blankOutside(code string, start int, end int) string {
+	return blank(code[:start]) + code[start:end] + blank(code[end:])
+}
+
+// blank replaces every byte but newlines with a space, preserving byte offsets.
+func blank(str string) string {
+	blanked := []byte(str)
+	for i := range blanked {
+		if blanked[i] != '\n' {
+			blanked[i] = ' '
+		}
+	}
+	return string(blanked)
+}
+
+func normalizeField(field string) string {
+	return strings.TrimLeft(field, ".")
+}
+
+func argName(index int, field string) string {
+	return strconv.Itoa(index) + "." + normalizeField(field)
+}
@@ -0,0 +1,153 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const sourceTestWhistle = `// a comment mentioning foo(
+x: foo($root.a.b)
+
+def foo(arg) {
+  var v: "foo: bar"
+  y: arg.c
+}
+`
+
+func TestParseSource(t *testing.T) {
+	s := ParseSource("test.wstl", sourceTestWhistle)
+	wantDefs := map[string]FileMetaData{
+		"foo": FileMetaData{FileName: "test.wstl", LineStart: 4, LineEnd: 4, CharStart: 5, CharEnd: 8},
+	}
+	if !cmp.Equal(wantDefs, s.Defs) {
+		t.Errorf("expected definitions %v, but got %v", wantDefs, s.Defs)
+	}
+	wantParams := map[string][]string{
+		"foo": []string{"arg"},
+	}
+	if !cmp.Equal(wantParams, s.Params) {
+		t.Errorf("expected parameters %v, but got %v", wantParams, s.Params)
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	g := Graph{
+		Nodes: map[int]Node{
+			0: makeTargetNode("x", "root", 0),
+			1: makeProjNode("foo", "root", 1),
+			2: &RootNode{id: 2, Field: ".a.b", Context: "root"},
+			3: makeVarNode("v", "foo", 3),
+			4: makeStringNode("foo: bar", "foo", 4),
+			5: makeTargetNode("y", "foo", 5),
+			6: makeArgNode(1, ".c", "foo", 6),
+		},
+	}
+	ParseSource("test.wstl", sourceTestWhistle).Annotate(g)
+
+	tests := []struct {
+		id   int
+		want FileMetaData
+	}{
+		{
+			id:   0,
+			want: FileMetaData{FileName: "test.wstl", LineStart: 2, LineEnd: 2, CharStart: 1, CharEnd: 2},
+		},
+		{
+			id:   1,
+			want: FileMetaData{FileName: "test.wstl", LineStart: 2, LineEnd: 2, CharStart: 4, CharEnd: 7},
+		},
+		{
+			id:   2,
+			want: FileMetaData{FileName: "test.wstl", LineStart: 2, LineEnd: 2, CharStart: 8, CharEnd: 17},
+		},
+		{
+			id:   3,
+			want: FileMetaData{FileName: "test.wstl", LineStart: 5, LineEnd: 5, CharStart: 7, CharEnd: 8},
+		},
+		{
+			id:   4,
+			want: FileMetaData{},
+		},
+		{
+			id:   5,
+			want: FileMetaData{FileName: "test.wstl", LineStart: 6, LineEnd: 6, CharStart: 3, CharEnd: 4},
+		},
+		{
+			id:   6,
+			want: FileMetaData{FileName: "test.wstl", LineStart: 6, LineEnd: 6, CharStart: 6, CharEnd: 11},
+		},
+	}
+	for _, test := range tests {
+		if got := FileData(g.Nodes[test.id]); !cmp.Equal(test.want, got) {
+			t.Errorf("expected node %v to be at %v, but got %v", g.Nodes[test.id], test.want, got)
+		}
+	}
+}
+
+func TestAnnotate_Unmatched(t *testing.T) {
+	whistle := `x: 1
+if $root.a {
+  z: 1
+} else {
+  z: 2
+}
+`
+	g := Graph{
+		Nodes: map[int]Node{
+			0: makeTargetNode("x", "root", 0),
+			1: makeTargetNode("x", "root", 1),
+			2: makeTargetNode("z", "root", 2), // one branch of two, as traced
+			3: makeTargetNode("w", "root", 3),
+		},
+	}
+	ParseSource("test.wstl", whistle).Annotate(g)
+
+	x := FileMetaData{FileName: "test.wstl", LineStart: 1, LineEnd: 1, CharStart: 1, CharEnd: 2}
+	want := map[int]FileMetaData{0: x, 1: x, 2: FileMetaData{}, 3: FileMetaData{}}
+	for id, wantData := range want {
+		if got := FileData(g.Nodes[id]); !cmp.Equal(wantData, got) {
+			t.Errorf("expected node %v to be at %v, but got %v", g.Nodes[id], wantData, got)
+		}
+	}
+}
+
+func TestLocate(t *testing.T) {
+	s := ParseSource("test.wstl", sourceTestWhistle)
+	tests := []struct {
+		name string
+		err  error
+		want FileMetaData
+	}{
+		{
+			name: "projector call",
+			err:  fmt.Errorf("wrapped:\n%w", &UnknownProjectorError{ErrorInfo{Name: "foo", Context: "root"}}),
+			want: FileMetaData{FileName: "test.wstl", LineStart: 2, LineEnd: 2, CharStart: 4, CharEnd: 7},
+		},
+		{
+			name: "argument",
+			err:  &ArgumentOutOfRangeError{ErrorInfo: ErrorInfo{Path: ".c", Context: "foo"}, Index: 1},
+			want: FileMetaData{FileName: "test.wstl", LineStart: 6, LineEnd: 6, CharStart: 6, CharEnd: 11},
+		},
+		{
+			name: "falls back to the definition",
+			err:  &UnresolvedLocalVarError{ErrorInfo{Name: "w", Path: "w", Context: "foo"}},
+			want: FileMetaData{FileName: "test.wstl", LineStart: 4, LineEnd: 4, CharStart: 5, CharEnd: 8},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s.Locate(test.err)
+			var gerr Error
+			if !errors.As(test.err, &gerr) {
+				t.Fatalf("expected a graph error, but got %v", test.err)
+			}
+			if got := gerr.Info().Position; !cmp.Equal(test.want, got) {
+				t.Errorf("expected error %v to be at %v, but got %v", test.err, test.want, got)
+			}
+		},
+		)
+	}
+}
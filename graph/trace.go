@@ -0,0 +1,816 @@
+package graph
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/builtins"
+	"github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/mapping"
+	"github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/projector"
+	"github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/types"
+	"github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/util/jsonutil"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+)
+
+// maxTraceDepth bounds the nested projector calls of a trace, in case a projector recurses forever.
+const maxTraceDepth = 200
+
+// tracedValue is a value read or computed while tracing a mapping: the nodes it was read from, and
+// the input fields it was computed from.
+type tracedValue struct {
+	ids  []int
+	prov provenance
+	// count is the number of elements of an array read with [*], which the projector it is passed
+	// to is called on one by one, or -1 for any other value.
+	count int
+}
+
+// plannedCall is a projector call made by a mapping, whose node is added before the mapping engine
+// runs the mapping. The engine's calls to the projector are matched to it in the order it makes
+// them, which records their results and the provenance of the value.
+type plannedCall struct {
+	node      *ProjectorNode
+	args      []tracedValue
+	bindings  []string // the text of each argument at the call site
+	iterated  bool     // the projector is called on each element of the arrays read with [*]
+	remaining int      // the engine's calls still expected
+	results   []interface{}
+	prov      provenance
+}
+
+// traceFrame is the scope of a projector call, or of the root mappings, while tracing.
+type traceFrame struct {
+	name    string
+	parent  *traceFrame // the frame an anonymous block is nested in
+	args    []tracedValue
+	varIDs  map[string][]int
+	targets map[string][]int
+	written []int
+	// varProv and outputProv hold the provenance of the variables and of the output.
+	varProv    provenance
+	outputProv provenance
+	// bindings is the text of each argument at the call site.
+	bindings []string
+	// planned holds the calls the mapping being run is still expected to make.
+	planned []*plannedCall
+}
+
+func newTraceFrame(name string, parent *traceFrame, args []tracedValue, bindings []string) *traceFrame {
+	return &traceFrame{
+		name:     name,
+		parent:   parent,
+		args:     args,
+		varIDs:   map[string][]int{},
+		targets:  map[string][]int{},
+		bindings: bindings,
+
+		varProv:    newProvenance(),
+		outputProv: newProvenance(),
+	}
+}
+
+// expect returns the planned call that a call the engine makes to a projector is one of, dropping
+// the planned calls before it, which the engine didn't make. It returns nil for a call that wasn't
+// planned, such as the $And of a condition, whose operands are linked to the target separately.
+func (f *traceFrame) expect(name string) *plannedCall {
+	for i, call := range f.planned {
+		if call.remaining > 0 && call.node.Name == name {
+			f.planned = f.planned[i:]
+			return call
+		}
+	}
+	return nil
+}
+
+// env returns an env for the node constructors, which only need the frame's name, number of
+// arguments and their text at the call site, and the tracer's options.
+func (t *tracer) env(f *traceFrame) *env {
+	return &env{name: f.name, args: make([][]argLineage, len(f.args)), ids: t.ids, bindings: f.bindings, plugins: t.plugins, semantics: t.semantics}
+}
+
+// engineRun is the state the mapping engine runs the mappings of a projector call, or the root
+// mappings, in.
+type engineRun struct {
+	projector string
+	args      []jsonutil.JSONMetaNode
+	output    *jsonutil.JSONToken
+	pctx      *types.Context
+}
+
+// tracer records a run of the mapping engine. It stands in for the engine in the projectors it
+// registers, so that it sees the mappings of each call, and wraps every registered projector to
+// see the engine's calls.
+type tracer struct {
+	g          Graph
+	engine     *mapping.Whistler
+	projectors map[string]*mbp.ProjectorDefinition
+	ids        *idGenerator
+	plugins    map[*mbp.ProjectorDefinition]Plugin
+	semantics  map[string]BuiltinSemantics
+	frames     []*traceFrame // the calls being run, innermost last
+	output     *jsonutil.JSONToken
+	outputProv provenance
+	failure    *UnsupportedMessageError // the construct the run stopped at, if any
+}
+
+// Trace runs a mapping on an input, decoded from JSON, with the mapping engine and returns the
+// instance graph of the run along with the mapping's output. The instance graph has a node for each
+// value computed, with the value in the graph's Values: a target for each field written, a
+// projector for each call, and the input fields, arguments and constants read. Only the branches
+// taken are in the graph, with the conditions that were met.
+//
+// The engine runs the mapping with the builtins, the mapping's projectors and the Func of each of
+// the Plugins, which are wrapped to record the calls, their results and the mappings each one runs.
+// A mapping is taken to be run if the engine changed its target, so a mapping writing a value its
+// target already holds isn't in the graph. Calling a plugin without a Func fails, since the engine
+// can't run it. If the run stops at a construct the engine can't expose to the trace, the error
+// returned wraps an UnsupportedMessageError. Of the other options, only the BuiltinSemantics are used.
+func Trace(mpc *mbp.MappingConfig, input interface{}, opts Options) (Graph, interface{}, error) {
+	plugins, err := pluginDefinitions(opts.Plugins)
+	if err != nil {
+		return Graph{}, nil, fmt.Errorf("invalid plugin:\n%w", err)
+	}
+	t := &tracer{
+		g: Graph{
+			Edges:             map[int][]int{},
+			ArgumentEdges:     map[int][]int{},
+			ArgumentSlots:     map[int][]int{},
+			ConditionEdges:    map[int][]int{},
+			RootAndOutTargets: map[string][]int{},
+			Nodes:             map[int]Node{},
+			Values:            map[int]interface{}{},
+			targetLineages:    map[int]targetLineage{},
+		},
+		engine:     mapping.NewWhistler(),
+		projectors: map[string]*mbp.ProjectorDefinition{},
+		ids:        &idGenerator{},
+		plugins:    plugins,
+		semantics:  opts.BuiltinSemantics,
+	}
+	for p := range plugins {
+		t.projectors[p.GetName()] = p
+	}
+	for _, p := range mpc.GetProjector() {
+		t.projectors[p.GetName()] = p
+	}
+	for name := range builtins.BuiltinFunctions {
+		t.projectors[name] = &mbp.ProjectorDefinition{Name: name}
+	}
+	for name := range harmonizationTables {
+		t.projectors[name] = &mbp.ProjectorDefinition{Name: name}
+	}
+	registry, err := t.registry(mpc)
+	if err != nil {
+		return Graph{}, nil, err
+	}
+	in, err := jsonutil.TokenToNode(toToken(input))
+	if err != nil {
+		return Graph{}, nil, fmt.Errorf("failed to read the input:\n%w", err)
+	}
+	var output jsonutil.JSONToken = jsonutil.JSONContainer{}
+	root := newTraceFrame(rootContext, nil, nil, nil)
+	t.frames = []*traceFrame{root}
+	t.output = &output
+	t.outputProv = root.outputProv
+	if err := t.ProcessMappings(mpc.GetRootMapping(), rootContext, []jsonutil.JSONMetaNode{in}, &output, types.NewContext(registry)); err != nil {
+		if t.failure != nil { // the engine may not wrap the errors of the projectors it calls
+			return Graph{}, nil, fmt.Errorf("tracing the root mappings failed:\n%w", t.failure)
+		}
+		return Graph{}, nil, fmt.Errorf("tracing the root mappings failed:\n%w", err)
+	}
+	t.g.Provenance = t.outputProv.flows()
+	return t.g, fromToken(output), nil
+}
+
+// registry registers the builtins, the plugins and the mapping's projectors with the mapping
+// engine, each wrapped by record. Harmonization builtins the engine doesn't list with its
+// builtins fail when called. The mapping's projectors take precedence over the plugins.
+func (t *tracer) registry(mpc *mbp.MappingConfig) (*types.Registry, error) {
+	projectors := map[string]types.Projector{}
+	for name, fn := range builtins.BuiltinFunctions {
+		p, err := projector.FromFunction(fn, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to register the builtin %v:\n%w", name, err)
+		}
+		projectors[name] = p
+	}
+	for name := range harmonizationTables {
+		if _, ok := projectors[name]; !ok { // the engine needs a harmonization config to run them
+			projectors[name] = t.unsupported(fmt.Sprintf("tracing harmonization builtin %v", name))
+		}
+	}
+	for _, plugin := range t.plugins {
+		if plugin.Func == nil {
+			projectors[plugin.Name] = t.unsupported(fmt.Sprintf("tracing plugin %v without its Go function", plugin.Name))
+			continue
+		}
+		p, err := projector.FromFunction(plugin.Func, plugin.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to register the plugin %v:\n%w", plugin.Name, err)
+		}
+		projectors[plugin.Name] = p
+	}
+	for _, def := range mpc.GetProjector() {
+		projectors[def.GetName()] = projector.FromDef(def, t)
+	}
+	registry := types.NewRegistry()
+	for name, p := range projectors {
+		if err := registry.RegisterProjector(name, t.record(name, p)); err != nil {
+			return nil, fmt.Errorf("failed to register %v:\n%w", name, err)
+		}
+	}
+	return registry, nil
+}
+
+// unsupported returns a projector failing with an UnsupportedMessageError.
+func (t *tracer) unsupported(name string) types.Projector {
+	return func([]jsonutil.JSONMetaNode, *types.Context) (jsonutil.JSONToken, error) {
+		t.failure = &UnsupportedMessageError{ErrorInfo{Name: name, Context: t.frames[len(t.frames)-1].name}}
+		return nil, t.failure
+	}
+}
+
+// record wraps a projector registered with the mapping engine. Each call the engine makes is
+// matched to the planned call of the mapping being run, and the mappings of a call to one of the
+// mapping's projectors are run in a frame of their own, whose targets become ancestors of the
+// call's node.
+func (t *tracer) record(name string, p types.Projector) types.Projector {
+	return func(args []jsonutil.JSONMetaNode, pctx *types.Context) (jsonutil.JSONToken, error) {
+		caller := t.frames[len(t.frames)-1]
+		if len(t.frames) > maxTraceDepth {
+			return nil, &RecursionError{ErrorInfo{Name: name, Context: caller.name}}
+		}
+		callee := newTraceFrame(name, nil, make([]tracedValue, len(args)), nil)
+		call := caller.expect(name)
+		if call != nil {
+			callee.args, callee.bindings = call.args, call.bindings
+			call.remaining--
+		}
+		if strings.HasPrefix(name, anon_prefix) {
+			callee.parent = caller // only remember the parent if in a closure
+		}
+		t.frames = append(t.frames, callee)
+		result, err := p(args, pctx)
+		t.frames = t.frames[:len(t.frames)-1]
+		if err != nil || call == nil {
+			return result, err
+		}
+
+		call.results = append(call.results, fromToken(result))
+		t.g.Edges[call.node.ID()] = append(t.g.Edges[call.node.ID()], callee.written...)
+		switch {
+		case discardsArguments(call.node): // the value doesn't derive from the arguments
+		case call.node.IsBuiltin || call.node.IsPlugin: // the value is computed from all of the arguments
+			for _, arg := range call.args {
+				call.prov.add(derivedFrom(arg.prov.inputs()))
+			}
+		default:
+			call.prov.add(callee.outputProv)
+		}
+		return result, nil
+	}
+}
+
+// ProcessMappings runs mappings with the mapping engine one at a time, adding the nodes of each
+// mapping that writes its target to the graph.
+func (t *tracer) ProcessMappings(mappings []*mbp.FieldMapping, projectorName string, args []jsonutil.JSONMetaNode, output *jsonutil.JSONToken, pctx *types.Context) error {
+	run := engineRun{projector: projectorName, args: args, output: output, pctx: pctx}
+	f := t.frames[len(t.frames)-1]
+	for _, m := range mappings {
+		if err := t.runMapping(m, f, run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EvaluateValueSource evaluates a value source with the mapping engine.
+func (t *tracer) EvaluateValueSource(vs *mbp.ValueSource, args []jsonutil.JSONMetaNode, output jsonutil.JSONToken, pctx *types.Context) (jsonutil.JSONMetaNode, error) {
+	return t.engine.EvaluateValueSource(vs, args, output, pctx)
+}
+
+// runMapping runs a mapping with the mapping engine. The nodes of the mapping are added before the
+// engine runs it, and are removed again if it doesn't change the target, because a condition isn't
+// met or the value is null, unless the calls the engine made wrote nodes deriving from them.
+func (t *tracer) runMapping(m *mbp.FieldMapping, f *traceFrame, run engineRun) error {
+	mark := t.ids.next
+	conditions := []tracedValue{}
+	for _, operand := range conditionOperands(m.GetCondition()) {
+		condition, err := t.plan(operand, f, run)
+		if err != nil {
+			return fmt.Errorf("failed to read the condition:\n%w", err)
+		}
+		conditions = append(conditions, condition)
+	}
+	value, err := t.plan(m.GetValueSource(), f, run)
+	if err != nil {
+		return fmt.Errorf("failed to read the mapping:\n%w", err)
+	}
+	node, err := targetNode(m, t.env(f))
+	if err != nil {
+		return err
+	}
+	before, _, err := t.target(node, run)
+	if err != nil {
+		return err
+	}
+	planned := t.ids.next
+
+	calls := f.planned
+	err = t.engine.ProcessMappings([]*mbp.FieldMapping{m}, run.projector, run.args, run.output, run.pctx)
+	f.planned = nil
+	if err != nil {
+		return fmt.Errorf("the mapping engine failed to run the mapping of %v:\n%w", node.Name, err)
+	}
+	after, path, err := t.target(node, run)
+	if err != nil {
+		return err
+	}
+	for _, call := range calls {
+		if call.iterated {
+			t.g.Values[call.node.ID()] = append([]interface{}{}, call.results...)
+		} else if len(call.results) > 0 {
+			t.g.Values[call.node.ID()] = call.results[0]
+		}
+	}
+	if reflect.DeepEqual(before, after) {
+		t.rollback(mark, planned, f)
+		return nil
+	}
+
+	if err := t.add(node, writtenValue(after, path)); err != nil {
+		return err
+	}
+	t.g.Edges[node.ID()] = append(t.g.Edges[node.ID()], value.ids...)
+	prov := newProvenance()
+	prov.add(value.prov)
+	for _, condition := range conditions {
+		t.g.ConditionEdges[node.ID()] = append(t.g.ConditionEdges[node.ID()], condition.ids...)
+		prov.add(derivedFrom(condition.prov.inputs()))
+	}
+
+	switch {
+	case node.IsVariable:
+		appendOrAddID(f.varIDs, node.ID(), node.Name)
+		f.varProv.add(prov.under(tracePath(node.Name)))
+	case node.IsRoot || node.IsOut:
+		appendOrAddID(f.targets, node.ID(), node.Name)
+		appendOrAddID(t.g.RootAndOutTargets, node.ID(), node.Name)
+		t.outputProv.add(prov.under(tracePath(node.Name)))
+	case isThis(node.Name):
+		appendOrAddID(f.targets, node.ID(), node.Name)
+		f.outputProv.add(prov)
+	default:
+		appendOrAddID(f.targets, node.ID(), node.Name)
+		f.outputProv.add(prov.under(tracePath(node.Name)))
+	}
+	f.written = append(f.written, node.ID())
+	return nil
+}
+
+// target reads the value holding a mapping's target with the mapping engine: the variable, the
+// root output or the output of the call. It also returns the path of the target in the value.
+func (t *tracer) target(node *TargetNode, run engineRun) (interface{}, string, error) {
+	name := strings.TrimSuffix(node.Name, "!")
+	switch {
+	case node.IsVariable:
+		segments := fieldSegments(name)
+		if len(segments) == 0 {
+			return nil, "", fmt.Errorf("the variable %q has no name", node.Name)
+		}
+		value, err := t.evaluate(&mbp.ValueSource{Source: &mbp.ValueSource_FromLocalVar{FromLocalVar: segments[0].name}}, run)
+		if err != nil {
+			value = nil // the variable isn't written yet
+		}
+		return value, strings.TrimPrefix(name, segments[0].name), nil
+	case node.IsRoot || node.IsOut:
+		return fromToken(*t.output), name, nil
+	default:
+		return fromToken(*run.output), trimThis(name), nil
+	}
+}
+
+// writtenValue returns the value written to the target at a path of the value holding it. A field
+// suffixed by [] is appended to, so the value written is its last element.
+func writtenValue(value interface{}, path string) interface{} {
+	written, _ := readField(value, path)
+	if strings.HasSuffix(path, "[]") {
+		if elements, ok := written.([]interface{}); ok && len(elements) > 0 {
+			return elements[len(elements)-1]
+		}
+	}
+	return written
+}
+
+// conditionOperands returns the conditions a mapping's condition is made of: the operands of an
+// $And, which are linked to the target separately as in New, or the condition itself.
+func conditionOperands(condition *mbp.ValueSource) []*mbp.ValueSource {
+	if condition == nil {
+		return nil
+	}
+	if condition.GetProjector() != and_keyword {
+		return []*mbp.ValueSource{condition}
+	}
+	operands := []*mbp.ValueSource{}
+	if condition.GetSource() != nil {
+		operands = append(operands, &mbp.ValueSource{Source: condition.GetSource()})
+	}
+	return append(operands, condition.GetAdditionalArg()...)
+}
+
+// rollback removes the nodes planned for a mapping the engine didn't write, which have the IDs from
+// the mark up to planned. The nodes written by the calls the engine made while running the mapping
+// have the IDs from planned on and are kept, along with the planned nodes they derive from, such as
+// the arguments of a projector that writes an out target but returns null.
+func (t *tracer) rollback(mark int, planned int, f *traceFrame) {
+	kept := map[int]bool{}
+	queue := []int{}
+	for id := planned; id < t.ids.next; id++ {
+		queue = append(queue, id)
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, edges := range []map[int][]int{t.g.Edges, t.g.ArgumentEdges, t.g.ConditionEdges} {
+			for _, ancestorID := range edges[id] {
+				if ancestorID >= mark && ancestorID < planned && !kept[ancestorID] {
+					kept[ancestorID] = true
+					queue = append(queue, ancestorID)
+				}
+			}
+		}
+	}
+
+	dropped := map[int]bool{}
+	for id := mark; id < planned; id++ {
+		if kept[id] {
+			continue
+		}
+		dropped[id] = true
+		delete(t.g.Nodes, id)
+		delete(t.g.Edges, id)
+		delete(t.g.ArgumentEdges, id)
+		delete(t.g.ArgumentSlots, id)
+		delete(t.g.ConditionEdges, id)
+		delete(t.g.Values, id)
+	}
+	removeIDs(t.g.RootAndOutTargets, dropped)
+	removeIDs(f.targets, dropped)
+	removeIDs(f.varIDs, dropped)
+	written := []int{}
+	for _, id := range f.written {
+		if !dropped[id] {
+			written = append(written, id)
+		}
+	}
+	f.written = written
+	if planned == t.ids.next { // no call wrote a node, so the IDs can be reused
+		t.ids.next = mark
+	}
+}
+
+// removeIDs removes the dropped IDs from the IDs of each name, and the names left without any.
+func removeIDs(idsByName map[string][]int, dropped map[int]bool) {
+	for name, ids := range idsByName {
+		remaining := []int{}
+		for _, id := range ids {
+			if !dropped[id] {
+				remaining = append(remaining, id)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(idsByName, name)
+		} else {
+			idsByName[name] = remaining
+		}
+	}
+}
+
+func (t *tracer) add(node Node, value interface{}) error {
+	if err := addNode(t.g, node, nil, false, false, true); err != nil {
+		return fmt.Errorf("adding node %v to graph failed:\n%w", node, err)
+	}
+	t.g.Values[node.ID()] = value
+	return nil
+}
+
+// plan adds the nodes of a value source before the mapping engine evaluates it: a node for each
+// constant and field read, with the value the engine reads, and a planned call for each projector
+// called. Variables and targets read link to the nodes writing them, if any.
+func (t *tracer) plan(source *mbp.ValueSource, f *traceFrame, run engineRun) (tracedValue, error) {
+	if source == nil {
+		return tracedValue{count: -1}, nil
+	}
+	if name := source.GetProjector(); name != "" {
+		return t.planCall(name, source, f, run)
+	}
+	switch s := source.GetSource().(type) {
+	case nil:
+		return tracedValue{count: -1}, nil
+	case *mbp.ValueSource_ConstBool, *mbp.ValueSource_ConstInt, *mbp.ValueSource_ConstFloat, *mbp.ValueSource_ConstString, *mbp.ValueSource_FromInput:
+		return t.read(source, f, run)
+	case *mbp.ValueSource_FromLocalVar:
+		count, err := t.count(source, s.FromLocalVar, run)
+		read := tracedValue{ids: []int{}, prov: newProvenance(), count: count}
+		for frame := f; frame != nil; frame = frame.parent {
+			if ids := matchingIDs(frame.varIDs, s.FromLocalVar); len(ids) > 0 {
+				read.ids, read.prov = ids, frame.varProv.field(tracePath(s.FromLocalVar))
+				break
+			}
+		}
+		return read, err
+	case *mbp.ValueSource_FromDestination:
+		count, err := t.count(source, s.FromDestination, run)
+		read := tracedValue{ids: []int{}, prov: newProvenance(), count: count}
+		path := trimThis(s.FromDestination)
+		for frame := f; frame != nil; frame = frame.parent {
+			if ids := matchingIDs(frame.targets, path); len(ids) > 0 {
+				read.ids, read.prov = ids, frame.outputProv.field(tracePath(path))
+				break
+			}
+		}
+		return read, err
+	case *mbp.ValueSource_ProjectedValue:
+		return t.plan(s.ProjectedValue, f, run)
+	default:
+		t.failure = &UnsupportedMessageError{ErrorInfo{Name: messageType(s), Context: f.name}}
+		return tracedValue{}, t.failure
+	}
+}
+
+// read adds the node of a constant, or of a field of an argument or of the input, whose value is
+// read by the mapping engine.
+func (t *tracer) read(source *mbp.ValueSource, f *traceFrame, run engineRun) (tracedValue, error) {
+	node, err := valueSourceNode(source, t.env(f))
+	if err != nil {
+		return tracedValue{}, err
+	}
+	read := tracedValue{ids: []int{node.ID()}, prov: newProvenance(), count: -1}
+	var value interface{}
+	var ancestorIDs []int
+	switch n := node.(type) {
+	case *ConstBoolNode:
+		value = n.Value
+	case *ConstIntNode:
+		value = float64(n.Value)
+	case *ConstFloatNode:
+		value = float64(n.Value)
+	case *ConstStringNode:
+		value = n.Value
+	case *ArgumentNode:
+		if n.Index >= 1 && n.Index <= len(f.args) {
+			ancestorIDs = f.args[n.Index-1].ids
+		}
+	}
+	if input := source.GetFromInput(); input != nil { // read from the message, not the node
+		if value, err = t.evaluate(source, run); err != nil {
+			return tracedValue{}, err
+		}
+		if strings.Contains(input.GetField(), "[*]") {
+			elements, _ := value.([]interface{})
+			read.count = len(elements)
+		}
+		if index := int(input.GetArg()); index == len(f.args)+1 {
+			read.prov = inputProvenance(tracePath(input.GetField()))
+		} else if index >= 1 && index <= len(f.args) {
+			read.prov = f.args[index-1].prov.field(tracePath(input.GetField()))
+		}
+	}
+	if err := t.add(node, value); err != nil {
+		return tracedValue{}, err
+	}
+	t.g.Edges[node.ID()] = append(t.g.Edges[node.ID()], ancestorIDs...)
+	return read, nil
+}
+
+// count returns the number of elements of an array read with [*] from a variable or target, as
+// read by the mapping engine, or -1 for any other read.
+func (t *tracer) count(source *mbp.ValueSource, path string, run engineRun) (int, error) {
+	if !strings.Contains(path, "[*]") {
+		return -1, nil
+	}
+	value, err := t.evaluate(source, run)
+	if err != nil {
+		return 0, err
+	}
+	elements, _ := value.([]interface{})
+	return len(elements), nil
+}
+
+// evaluate reads a value source, without its projector, with the mapping engine, as it is before
+// the mapping runs.
+func (t *tracer) evaluate(source *mbp.ValueSource, run engineRun) (interface{}, error) {
+	node, err := t.engine.EvaluateValueSource(&mbp.ValueSource{Source: source.GetSource()}, run.args, *run.output, run.pctx)
+	if err != nil {
+		return nil, fmt.Errorf("the mapping engine failed to read %v:\n%w", messageType(source.GetSource()), err)
+	}
+	if node == nil {
+		return nil, nil
+	}
+	token, err := jsonutil.NodeToToken(node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert the value read:\n%w", err)
+	}
+	return fromToken(token), nil
+}
+
+// planCall adds the node of a projector call, and plans the call, once for each element of the
+// arrays read with [*] among its arguments.
+func (t *tracer) planCall(name string, source *mbp.ValueSource, f *traceFrame, run engineRun) (tracedValue, error) {
+	def, ok := t.projectors[name]
+	if !ok {
+		return tracedValue{}, &UnknownProjectorError{ErrorInfo{Name: name, Context: f.name}}
+	}
+	args := []tracedValue{}
+	count := -1
+	if source.GetSource() != nil {
+		for i, arg := range append([]*mbp.ValueSource{{Source: source.GetSource()}}, source.GetAdditionalArg()...) {
+			value, err := t.plan(arg, f, run)
+			if err != nil {
+				return tracedValue{}, fmt.Errorf("failed to read argument %v of %v:\n%w", i+1, name, err)
+			}
+			args = append(args, value)
+			if value.count >= 0 {
+				count = value.count
+			}
+		}
+	}
+	node := projectorNode(def, t.env(f))
+	if err := t.add(node, nil); err != nil {
+		return tracedValue{}, err
+	}
+	for i, arg := range args {
+		t.g.ArgumentEdges[node.ID()] = append(t.g.ArgumentEdges[node.ID()], arg.ids...)
+		for range arg.ids {
+			t.g.ArgumentSlots[node.ID()] = append(t.g.ArgumentSlots[node.ID()], i+1)
+		}
+	}
+	call := &plannedCall{
+		node:      node,
+		args:      args,
+		bindings:  t.env(f).argumentTexts(source),
+		iterated:  count >= 0,
+		remaining: 1,
+		prov:      newProvenance(),
+	}
+	if call.iterated {
+		call.remaining = count
+	}
+	f.planned = append(f.planned, call)
+	return tracedValue{ids: []int{node.ID()}, prov: call.prov, count: -1}, nil
+}
+
+// toToken converts a value decoded from JSON to the mapping engine's JSON tokens.
+func toToken(value interface{}) jsonutil.JSONToken {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		container := jsonutil.JSONContainer{}
+		for name, field := range v {
+			token := toToken(field)
+			container[name] = &token
+		}
+		return container
+	case []interface{}:
+		array := jsonutil.JSONArr{}
+		for _, element := range v {
+			array = append(array, toToken(element))
+		}
+		return array
+	case string:
+		return jsonutil.JSONStr(v)
+	case float64:
+		return jsonutil.JSONNum(v)
+	case bool:
+		return jsonutil.JSONBool(v)
+	default:
+		return nil
+	}
+}
+
+// fromToken converts the mapping engine's JSON tokens to a value as decoded from JSON.
+func fromToken(token jsonutil.JSONToken) interface{} {
+	switch v := token.(type) {
+	case jsonutil.JSONContainer:
+		object := map[string]interface{}{}
+		for name, field := range v {
+			if field != nil {
+				object[name] = fromToken(*field)
+			}
+		}
+		return object
+	case *jsonutil.JSONContainer:
+		if v == nil {
+			return nil
+		}
+		return fromToken(*v)
+	case jsonutil.JSONArr:
+		array := []interface{}{}
+		for _, element := range v {
+			array = append(array, fromToken(element))
+		}
+		return array
+	case jsonutil.JSONStr:
+		return string(v)
+	case jsonutil.JSONNum:
+		return float64(v)
+	case jsonutil.JSONBool:
+		return bool(v)
+	default:
+		return nil
+	}
+}
+
+// matchingIDs returns the nodes writing the targets that the path reads, or that are written within
+// it. An empty path reads every target.
+func matchingIDs(targets map[string][]int, path string) []int {
+	ids := []int{}
+	for name, targetIDs := range targets {
+		if path == "" || isThis(name) || matchUpToDiff(fieldNames(name), fieldNames(path)) > 0 {
+			ids = append(ids, targetIDs...)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// fieldNames splits a field path into the names of its fields, without their indices.
+func fieldNames(path string) []string {
+	names := []string{}
+	for _, segment := range fieldSegments(path) {
+		names = append(names, segment.name)
+	}
+	return names
+}
+
+// fieldSegment is a field of a path, such as "a", "a[2]", "a[*]" or "a[]".
+type fieldSegment struct {
+	name      string
+	index     string // the index between the brackets, if any: a number or "*"
+	appending bool   // "a[]" appends to the array when written
+}
+
+func fieldSegments(path string) []fieldSegment {
+	path = strings.TrimSuffix(strings.TrimPrefix(path, "."), "!")
+	if path == "" {
+		return nil
+	}
+	segments := []fieldSegment{}
+	for _, part := range strings.Split(path, ".") {
+		segment := fieldSegment{name: part}
+		if i := strings.Index(part, "["); i >= 0 && strings.HasSuffix(part, "]") {
+			segment.name = part[:i]
+			segment.index = part[i+1 : len(part)-1]
+			segment.appending = segment.index == ""
+		}
+		segments = append(segments, segment)
+	}
+	return segments
+}
+
+// readField reads the field at a path of a value. A field read with [*] is read from each element
+// of the array, and the elements are returned as a list, flattened if more than one array is
+// iterated over.
+func readField(value interface{}, path string) (interface{}, bool) {
+	return readSegments(value, fieldSegments(path))
+}
+
+func readSegments(value interface{}, segments []fieldSegment) (interface{}, bool) {
+	if len(segments) == 0 || value == nil {
+		return value, false
+	}
+	segment := segments[0]
+	if segment.name != "" {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value = object[segment.name]
+	}
+	switch segment.index {
+	case "":
+		return readSegments(value, segments[1:])
+	case "*":
+		elements, _ := value.([]interface{})
+		list := []interface{}{}
+		for _, element := range elements {
+			v, expanded := readSegments(element, segments[1:])
+			if nested, ok := v.([]interface{}); ok && expanded {
+				list = append(list, nested...)
+			} else {
+				list = append(list, v)
+			}
+		}
+		return list, true
+	default:
+		elements, _ := value.([]interface{})
+		i, err := strconv.Atoi(segment.index)
+		if err != nil || i < 0 || i >= len(elements) {
+			return nil, false
+		}
+		return readSegments(elements[i], segments[1:])
+	}
+}
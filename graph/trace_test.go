@@ -0,0 +1,219 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/util/jsonutil"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTrace(t *testing.T) {
+	input := map[string]interface{}{
+		"patient": map[string]interface{}{"name": "Ann", "age": 42.0},
+		"codes":   []interface{}{"a", "b"},
+	}
+	tests := []struct {
+		name        string
+		mpc         *mbp.MappingConfig
+		wantOutput  interface{}
+		wantLineage map[string][]string // the nodes each output derives from, with their values
+		wantProv    map[string][]string // the input fields each output field is computed from, if checked
+		plugins     []Plugin
+		wantErr     string
+	}{
+		{
+			name: "constant and input",
+			mpc: makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+				makeMappingMsg("x", makeIntMsg(1), nil),
+				makeMappingMsg("name", makeArgMsg(1, ".patient.name"), nil),
+			}),
+			wantOutput: map[string]interface{}{"x": 1.0, "name": "Ann"},
+			wantLineage: map[string][]string{
+				"x":    {"1 = 1"},
+				"name": {"$root field .patient.name = \"Ann\""},
+			},
+			wantProv: map[string][]string{"name": {"patient.name"}},
+		},
+		{
+			name: "projector",
+			mpc: makeMappingConfigMsg(
+				[]*mbp.ProjectorDefinition{
+					makeProjDefMsg("Patient", []*mbp.FieldMapping{
+						makeMappingMsg("n", makeArgMsg(1, ".name"), nil),
+					}),
+				},
+				[]*mbp.FieldMapping{
+					makeMappingMsg("p", makeProjSourceMsg("Patient", makeArgMsg(1, ".patient"), nil), nil),
+				}),
+			wantOutput: map[string]interface{}{"p": map[string]interface{}{"n": "Ann"}},
+			wantLineage: map[string][]string{
+				"p": {
+					"$root field .patient = {\"age\":42,\"name\":\"Ann\"}",
+					"arg 1.name of Patient ← $root.patient = \"Ann\"",
+					"def Patient = {\"n\":\"Ann\"}",
+					"n = \"Ann\"",
+				},
+			},
+			wantProv: map[string][]string{"p.n": {"patient.name"}},
+		},
+		{
+			name: "branch taken",
+			mpc: makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+				makeMappingMsg("adult", makeBoolMsg(true), makeProjSourceMsg("$Gt", makeArgMsg(1, ".patient.age"), []*mbp.ValueSource{makeIntMsg(17)})),
+				makeMappingMsg("adult", makeBoolMsg(false), makeProjectedSourceMsg(makeProjSourceMsg("$Gt", makeArgMsg(1, ".patient.age"), []*mbp.ValueSource{makeIntMsg(17)}), "$Not", nil)),
+			}),
+			wantOutput: map[string]interface{}{"adult": true},
+			wantLineage: map[string][]string{
+				"adult": {"$root field .patient.age = 42", "17 = 17", "def $Gt = true", "true = true"},
+			},
+			wantProv: map[string][]string{"adult": {"patient.age"}},
+		},
+		{
+			name: "null not written",
+			mpc: makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+				makeMappingMsg("missing", makeArgMsg(1, ".patient.missing"), nil),
+			}),
+			wantOutput:  map[string]interface{}{},
+			wantLineage: map[string][]string{},
+		},
+		{
+			name: "variable, destination and iteration",
+			mpc: makeMappingConfigMsg(
+				[]*mbp.ProjectorDefinition{
+					makeProjDefMsg("Code", []*mbp.FieldMapping{
+						makeMappingMsg("code", makeArgMsg(1, ""), nil),
+					}),
+				},
+				[]*mbp.FieldMapping{
+					makeVarMappingMsg("v", makeArgMsg(1, ".patient.name"), nil),
+					makeMappingMsg("a", makeLocalVarMsg("v"), nil),
+					makeMappingMsg("b", makeDestValSourceMsg("a"), nil),
+					makeMappingMsg("codes", makeProjSourceMsg("Code", makeArgMsg(1, ".codes[*]"), nil), nil),
+				}),
+			wantOutput: map[string]interface{}{
+				"a":     "Ann",
+				"b":     "Ann",
+				"codes": []interface{}{map[string]interface{}{"code": "a"}, map[string]interface{}{"code": "b"}},
+			},
+			wantLineage: map[string][]string{
+				"a": {"$root field .patient.name = \"Ann\"", "var v = \"Ann\""},
+				"b": {"$root field .patient.name = \"Ann\"", "a = \"Ann\"", "var v = \"Ann\""},
+				"codes": {
+					"$root field .codes[*] = [\"a\",\"b\"]",
+					"arg 1 of Code ← $root.codes[*] = \"a\"",
+					"arg 1 of Code ← $root.codes[*] = \"b\"",
+					"code = \"a\"",
+					"code = \"b\"",
+					"def Code = [{\"code\":\"a\"},{\"code\":\"b\"}]",
+				},
+			},
+			wantProv: map[string][]string{"a": {"patient.name"}, "b": {"patient.name"}, "codes.code": {"codes"}},
+		},
+		{
+			name: "builtin run by the mapping engine",
+			mpc: makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+				makeMappingMsg("upper", makeProjSourceMsg("$ToUpper", makeArgMsg(1, ".patient.name"), nil), nil),
+			}),
+			wantOutput: map[string]interface{}{"upper": "ANN"},
+			wantLineage: map[string][]string{
+				"upper": {"$root field .patient.name = \"Ann\"", "def $ToUpper = \"ANN\""},
+			},
+			wantProv: map[string][]string{"upper": {"patient.name"}},
+		},
+		{
+			name: "plugin run by the mapping engine",
+			mpc: makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+				makeMappingMsg("initial", makeProjSourceMsg("Initial", makeArgMsg(1, ".patient.name"), nil), nil),
+			}),
+			plugins: []Plugin{{Name: "Initial", NumArgs: 1, Func: func(name jsonutil.JSONStr) (jsonutil.JSONStr, error) {
+				return name[:1], nil
+			}}},
+			wantOutput: map[string]interface{}{"initial": "A"},
+			wantLineage: map[string][]string{
+				"initial": {"$root field .patient.name = \"Ann\"", "def Initial = \"A\""},
+			},
+			wantProv: map[string][]string{"initial": {"patient.name"}},
+		},
+		{
+			name: "projector returning null but writing out",
+			mpc: makeMappingConfigMsg(
+				[]*mbp.ProjectorDefinition{
+					makeProjDefMsg("Log", []*mbp.FieldMapping{
+						{ValueSource: makeArgMsg(1, ""), Target: &mbp.FieldMapping_TargetObject{TargetObject: "logged"}},
+					}),
+				},
+				[]*mbp.FieldMapping{
+					makeMappingMsg("x", makeProjSourceMsg("Log", makeArgMsg(1, ".patient.name"), nil), nil),
+				}),
+			wantOutput: map[string]interface{}{"logged": "Ann"},
+			wantLineage: map[string][]string{
+				"logged": {
+					"$root field .patient.name = \"Ann\"",
+					"arg 1 of Log ← $root.patient.name = \"Ann\"",
+				},
+			},
+			wantProv: map[string][]string{"logged": {"patient.name"}},
+		},
+		{
+			name: "plugin without a Go function",
+			mpc: makeMappingConfigMsg(nil, []*mbp.FieldMapping{
+				makeMappingMsg("code", makeProjSourceMsg("LookupCode", makeArgMsg(1, ".patient.name"), nil), nil),
+			}),
+			plugins: []Plugin{{Name: "LookupCode", NumArgs: 1}},
+			wantErr: "tracing plugin LookupCode without its Go function is not supported in root",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, output, err := Trace(test.mpc, input, Options{Plugins: test.plugins})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Trace() returned error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Trace() returned unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(test.wantOutput, output); diff != "" {
+				t.Errorf("Trace() output mismatch (-want +got):\n%s", diff)
+			}
+			lineage := map[string][]string{}
+			for name, ids := range g.Outputs() {
+				lineage[name] = []string{}
+				for _, id := range ids {
+					for _, ancestorID := range g.Upstream(id, true) {
+						lineage[name] = append(lineage[name], tracedLabel(t, g, ancestorID))
+					}
+				}
+				sort.Strings(lineage[name])
+			}
+			if diff := cmp.Diff(test.wantLineage, lineage); diff != "" {
+				t.Errorf("Trace() lineage mismatch (-want +got):\n%s", diff)
+			}
+			if test.wantProv != nil {
+				if diff := cmp.Diff(test.wantProv, g.Provenance); diff != "" {
+					t.Errorf("Trace() provenance mismatch (-want +got):\n%s", diff)
+				}
+			}
+		},
+		)
+	}
+}
+
+func tracedLabel(t *testing.T, g Graph, id int) string {
+	label, err := getNodeLabel(g.Nodes[id])
+	if err != nil {
+		t.Fatalf("getNodeLabel(%v) returned unexpected error: %v", g.Nodes[id], err)
+	}
+	value, err := json.Marshal(g.Values[id])
+	if err != nil {
+		t.Fatalf("failed to marshal the value of %v: %v", g.Nodes[id], err)
+	}
+	return fmt.Sprintf("%v = %s", strings.ReplaceAll(label, "\n", " "), value)
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/impact"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/loader"
+)
+
+// runImpact writes the tests of a manifest that are affected by the change from the old mapping
+// file to the new one, given as arguments.
+func runImpact(args []string) error {
+	flags := flag.NewFlagSet("impact", flag.ExitOnError)
+	manifestFile := flags.String("manifest", "", "JSON file mapping each test name to the output field paths it asserts.")
+	format := flags.String("format", "text", "Output format of the affected tests: text, with a test name per line, or json, with the changed fields of each test.")
+	out := flags.String("out", loader.StdioSpec, "File to write the affected tests to. Use - to write to stdout.")
+	libDir := flags.String("lib_dir_spec", "", "Directory of whistle library files whose projectors the mappings can call.")
+	pluginsSpec := flags.String("plugins", "", pluginsUsage)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v impact -manifest=tests.json [flags] old.wstl new.wstl\n", flag.CommandLine.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 || *manifestFile == "" {
+		flags.Usage()
+		return fmt.Errorf("expected a test manifest and an old and a new mapping file")
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown output format %v; expected text or json", *format)
+	}
+	manifest, err := impact.ReadManifest(*manifestFile)
+	if err != nil {
+		return err
+	}
+	plugins, err := loader.ReadPlugins(*pluginsSpec)
+	if err != nil {
+		return err
+	}
+	libraries, err := loader.LibraryProjectors(*libDir)
+	if err != nil {
+		return err
+	}
+
+	graphs := []graph.Graph{}
+	for _, file := range flags.Args() {
+		whistle, err := loader.ReadMapping(file)
+		if err != nil {
+			return err
+		}
+		g, _, err := loader.FromWhistle(file, whistle, libraries, graph.Options{Plugins: plugins})
+		if err != nil {
+			return fmt.Errorf("failed to build the lineage graph of %v:\n%w", file, err)
+		}
+		graphs = append(graphs, g)
+	}
+	affected := impact.Select(graphs[0], graphs[1], manifest)
+
+	var output []byte
+	if *format == "json" {
+		if output, err = json.MarshalIndent(affected, "", "  "); err != nil {
+			return fmt.Errorf("failed to marshal the affected tests:\n%w", err)
+		}
+	} else {
+		lines := []string{}
+		for _, a := range affected {
+			lines = append(lines, a.Test+"\n")
+		}
+		output = []byte(strings.Join(lines, ""))
+	}
+	if err := loader.WriteOutput(*out, output); err != nil {
+		return fmt.Errorf("failed to write the affected tests:\n%w", err)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package impact selects the mapping tests affected by a change to a mapping: the tests asserting
+// output fields whose lineage differs between the old and new lineage graphs.
+package impact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+)
+
+// Manifest maps the name of each test to the output field paths it asserts, such as
+//
+//	{
+//	  "patient_name": ["Patient.name", "Patient.name.given"],
+//	  "encounter_dates": ["Encounter.period.start"]
+//	}
+type Manifest map[string][]string
+
+// ReadManifest reads and validates a test manifest file.
+func ReadManifest(path string) (Manifest, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the test manifest %v:\n%w", path, err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse the test manifest %v:\n%w", path, err)
+	}
+	for test, fields := range manifest {
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("the test %q in %v asserts no fields", test, path)
+		}
+	}
+	return manifest, nil
+}
+
+// Affected is a test whose asserted fields have a changed lineage.
+type Affected struct {
+	Test   string   `json:"test"`
+	Fields []string `json:"fields"`
+}
+
+func (a Affected) String() string {
+	return fmt.Sprintf("%v: %v", a.Test, strings.Join(a.Fields, ", "))
+}
+
+// Select returns the tests of the manifest that assert a field whose lineage differs between the
+// old and new graphs, sorted by name.
+func Select(old, new graph.Graph, manifest Manifest) []Affected {
+	oldPrints := newFingerprints(old)
+	newPrints := newFingerprints(new)
+	affected := []Affected{}
+	for test, fields := range manifest {
+		changed := []string{}
+		for _, field := range fields {
+			if oldPrints.field(field) != newPrints.field(field) {
+				changed = append(changed, field)
+			}
+		}
+		if len(changed) > 0 {
+			affected = append(affected, Affected{Test: test, Fields: changed})
+		}
+	}
+	sort.Slice(affected, func(i, j int) bool { return affected[i].Test < affected[j].Test })
+	return affected
+}
+
+// fingerprints hashes the lineage of the nodes of a graph, memoizing the hash of each node.
+type fingerprints struct {
+	g      graph.Graph
+	hashes map[int]string
+}
+
+func newFingerprints(g graph.Graph) *fingerprints {
+	return &fingerprints{g: g, hashes: map[int]string{}}
+}
+
+// field hashes the lineage of the targets matching an output field path, as found by
+// graph.FindTargets. A field without targets has an empty hash.
+func (f *fingerprints) field(path string) string {
+	hashes := []string{}
+	for _, id := range f.g.FindTargets(path) {
+		hashes = append(hashes, f.node(id))
+	}
+	if len(hashes) == 0 {
+		return ""
+	}
+	sort.Strings(hashes)
+	return hash(strings.Join(hashes, ","))
+}
+
+// node hashes a node with the hashes of every node it derives from, through primary, argument
+// and condition edges. Positions and IDs aren't hashed, so moving code or rebuilding the graph
+// doesn't change the hash, while changing any expression a node derives from does. Arguments are
+// hashed with the call-site slot they fill, so swapping the arguments of a call changes the hash.
+func (f *fingerprints) node(id int) string {
+	if h, ok := f.hashes[id]; ok {
+		return h
+	}
+	f.hashes[id] = "cycle" // the graphs are acyclic, but don't loop forever if one isn't
+	parts := []string{label(f.g.Nodes[id])}
+	for _, edges := range []struct {
+		kind    string
+		adjList map[int][]int
+	}{{"edge", f.g.Edges}, {"arg", f.g.ArgumentEdges}, {"cond", f.g.ConditionEdges}} {
+		ancestors := []string{}
+		for i, ancestorID := range edges.adjList[id] {
+			if edges.kind != "arg" {
+				ancestors = append(ancestors, f.node(ancestorID))
+				continue
+			}
+			slot := f.g.ArgumentSlot(id, i)
+			if slot == 0 { // without recorded slots, the edges are in call-site order
+				slot = i + 1
+			}
+			ancestors = append(ancestors, fmt.Sprintf("%v:%v", slot, f.node(ancestorID)))
+		}
+		sort.Strings(ancestors)
+		parts = append(parts, edges.kind+"["+strings.Join(ancestors, ",")+"]")
+	}
+	f.hashes[id] = hash(strings.Join(parts, " "))
+	return f.hashes[id]
+}
+
+// label describes a node by its kind and the fields that aren't positions, IDs or schemas.
+func label(node graph.Node) string {
+	switch n := node.(type) {
+	case *graph.TargetNode:
+		return fmt.Sprintf("target %q %q var=%v overwrite=%v root=%v out=%v", n.Name, n.Context, n.IsVariable, n.IsOverwrite, n.IsRoot, n.IsOut)
+	case *graph.ConstBoolNode:
+		return fmt.Sprintf("bool %v %q", n.Value, n.Context)
+	case *graph.ConstIntNode:
+		return fmt.Sprintf("int %v %q", n.Value, n.Context)
+	case *graph.ConstFloatNode:
+		return fmt.Sprintf("float %v %q", n.Value, n.Context)
+	case *graph.ConstStringNode:
+		return fmt.Sprintf("string %q %q", n.Value, n.Context)
+	case *graph.ProjectorNode:
+		return fmt.Sprintf("projector %q %q", n.Name, n.Context)
+	case *graph.ArgumentNode:
+		return fmt.Sprintf("argument %v %q %q", n.Index, n.Field, n.Context)
+	case *graph.RootNode:
+		return fmt.Sprintf("root %q %q", n.Field, n.Context)
+	case *graph.ConceptMapNode:
+		return fmt.Sprintf("concept map %q %q %q", n.Map, n.Projector, n.Context)
+	case *graph.UnknownNode:
+		return fmt.Sprintf("unknown %q %q", n.Reason, n.Context)
+	default:
+		return fmt.Sprintf("%T", node)
+	}
+}
+
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,117 @@
+package impact
+
+import (
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/internal/mappingtest"
+)
+
+func TestSelect(t *testing.T) {
+	manifest := Manifest{
+		"name":     {"Patient.name"},
+		"birth":    {"Patient.birthDate"},
+		"patient":  {"Patient.name", "Patient.birthDate"},
+		"gender":   {"Patient.gender"},
+		"identity": {"Id"},
+	}
+	old := []*mbp.FieldMapping{
+		mappingtest.Mapping("name", mappingtest.FromInput(".name")),
+		mappingtest.Mapping("birthDate", mappingtest.Call("ShiftDate", mappingtest.FromInput(".birthDate"))),
+	}
+	tests := []struct {
+		name    string
+		patient []*mbp.FieldMapping
+		shift   []*mbp.FieldMapping
+		want    []Affected
+	}{
+		{
+			name:    "unchanged",
+			patient: old,
+			want:    []Affected{},
+		},
+		{
+			name: "reordered",
+			patient: []*mbp.FieldMapping{
+				mappingtest.Mapping("birthDate", mappingtest.Call("ShiftDate", mappingtest.FromInput(".birthDate"))),
+				mappingtest.Mapping("name", mappingtest.FromInput(".name")),
+			},
+			want: []Affected{},
+		},
+		{
+			name: "input field changed",
+			patient: []*mbp.FieldMapping{
+				mappingtest.Mapping("name", mappingtest.FromInput(".fullName")),
+				mappingtest.Mapping("birthDate", mappingtest.Call("ShiftDate", mappingtest.FromInput(".birthDate"))),
+			},
+			want: []Affected{
+				{Test: "name", Fields: []string{"Patient.name"}},
+				{Test: "patient", Fields: []string{"Patient.name"}},
+			},
+		},
+		{
+			name:    "called projector changed",
+			patient: old,
+			shift:   []*mbp.FieldMapping{mappingtest.Mapping("date", mappingtest.Call("$StrCat", mappingtest.FromInput("")))},
+			want: []Affected{
+				{Test: "birth", Fields: []string{"Patient.birthDate"}},
+				{Test: "patient", Fields: []string{"Patient.birthDate"}},
+			},
+		},
+		{
+			name: "field added",
+			patient: append([]*mbp.FieldMapping{
+				mappingtest.Mapping("gender", mappingtest.FromInput(".gender")),
+			}, old...),
+			want: []Affected{
+				{Test: "gender", Fields: []string{"Patient.gender"}},
+			},
+		},
+	}
+	graphOf := func(t *testing.T, patient, shift []*mbp.FieldMapping) graph.Graph {
+		if shift == nil {
+			shift = []*mbp.FieldMapping{mappingtest.Mapping("date", mappingtest.FromInput(""))}
+		}
+		g, err := graph.New(&mbp.MappingConfig{
+			Projector: []*mbp.ProjectorDefinition{
+				{Name: "PatientP", Mapping: patient},
+				{Name: "ShiftDate", Mapping: shift},
+			},
+			RootMapping: []*mbp.FieldMapping{mappingtest.Mapping("Patient", mappingtest.Call("PatientP", mappingtest.FromInput(".patient")))},
+		})
+		if err != nil {
+			t.Fatalf("building the graph failed:\n%v", err)
+		}
+		return g
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Select(graphOf(t, old, nil), graphOf(t, test.patient, test.shift), manifest)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Select() returned unexpected tests (-want +got):\n%v", diff)
+			}
+		},
+		)
+	}
+}
+
+func TestSelect_ArgumentsSwapped(t *testing.T) {
+	manifest := Manifest{"age": {"age"}, "name": {"name"}}
+	graphOf := func(t *testing.T, first, second string) graph.Graph {
+		g, err := graph.New(&mbp.MappingConfig{RootMapping: []*mbp.FieldMapping{
+			mappingtest.Mapping("age", mappingtest.Call("$Sum", mappingtest.FromInput(".years"), mappingtest.FromInput(".months"))),
+			mappingtest.Mapping("name", mappingtest.Call("$StrCat", mappingtest.FromInput(first), mappingtest.FromInput(second))),
+		}})
+		if err != nil {
+			t.Fatalf("building the graph failed:\n%v", err)
+		}
+		return g
+	}
+	got := Select(graphOf(t, ".given", ".family"), graphOf(t, ".family", ".given"), manifest)
+	want := []Affected{{Test: "name", Fields: []string{"name"}}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Select() returned unexpected tests (-want +got):\n%v", diff)
+	}
+}
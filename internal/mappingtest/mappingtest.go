@@ -0,0 +1,76 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mappingtest builds the mapping config messages shared by the tests of several packages.
+package mappingtest
+
+import (
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+)
+
+// Mapping returns a mapping writing the value source to an output target.
+func Mapping(target string, source *mbp.ValueSource) *mbp.FieldMapping {
+	return Guarded(target, source, nil)
+}
+
+// Guarded returns a mapping writing the value source to an output target if the condition holds.
+func Guarded(target string, source *mbp.ValueSource, condition *mbp.ValueSource) *mbp.FieldMapping {
+	return &mbp.FieldMapping{
+		Target:      &mbp.FieldMapping_TargetField{TargetField: target},
+		ValueSource: source,
+		Condition:   condition,
+	}
+}
+
+// Variable returns a mapping writing the value source to a local variable.
+func Variable(name string, source *mbp.ValueSource) *mbp.FieldMapping {
+	return &mbp.FieldMapping{
+		Target:      &mbp.FieldMapping_TargetLocalVar{TargetLocalVar: name},
+		ValueSource: source,
+	}
+}
+
+// FromInput returns a value source reading a field of the first argument, such as ".a" of $root.
+func FromInput(field string) *mbp.ValueSource {
+	return &mbp.ValueSource{Source: &mbp.ValueSource_FromInput{FromInput: &mbp.ValueSource_InputSource{Arg: 1, Field: field}}}
+}
+
+// FromDest returns a value source reading an output path.
+func FromDest(path string) *mbp.ValueSource {
+	return &mbp.ValueSource{Source: &mbp.ValueSource_FromDestination{FromDestination: path}}
+}
+
+// FromVar returns a value source reading a local variable.
+func FromVar(name string) *mbp.ValueSource {
+	return &mbp.ValueSource{Source: &mbp.ValueSource_FromLocalVar{FromLocalVar: name}}
+}
+
+// ConstInt returns a constant integer value source.
+func ConstInt(value int32) *mbp.ValueSource {
+	return &mbp.ValueSource{Source: &mbp.ValueSource_ConstInt{ConstInt: value}}
+}
+
+// Call returns a call to a projector on the given arguments. A first argument that is itself a call
+// is wrapped as a projected value, as the transpiler does.
+func Call(projector string, args ...*mbp.ValueSource) *mbp.ValueSource {
+	source := &mbp.ValueSource{Projector: projector}
+	if len(args) > 0 {
+		source.Source = args[0].Source
+		if args[0].GetProjector() != "" {
+			source.Source = &mbp.ValueSource_ProjectedValue{ProjectedValue: args[0]}
+		}
+		source.AdditionalArg = args[1:]
+	}
+	return source
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/lint"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/loader"
+)
+
+// runLint lints the mapping files given as arguments and writes the findings. It fails if there
+// are findings as severe as the -fail_on flag, after writing them.
+func runLint(args []string) error {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	format := flags.String("format", "text", "Output format of the findings: text, json or sarif.")
+	out := flags.String("out", loader.StdioSpec, "File to write the findings to. Use - to write to stdout.")
+	libDir := flags.String("lib_dir_spec", "", "Directory of whistle library files whose projectors the mappings can call.")
+	pluginsSpec := flags.String("plugins", "", pluginsUsage)
+	failOn := flags.String("fail_on", string(lint.Warning), "Fail if there are findings of this severity or worse: warning, error or never.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v lint [flags] mapping.wstl...\n", flag.CommandLine.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no mapping files were given")
+	}
+	if *failOn != string(lint.Warning) && *failOn != string(lint.Error) && *failOn != "never" {
+		return fmt.Errorf("unknown severity %v; expected warning, error or never", *failOn)
+	}
+
+	plugins, err := loader.ReadPlugins(*pluginsSpec)
+	if err != nil {
+		return err
+	}
+	libraries, err := loader.LibraryProjectors(*libDir)
+	if err != nil {
+		return err
+	}
+	findings := []lint.Finding{}
+	for _, file := range flags.Args() {
+		whistle, err := loader.ReadMapping(file)
+		if err != nil {
+			return err
+		}
+		findings = append(findings, lint.File(file, whistle, libraries, graph.Options{Plugins: plugins})...)
+	}
+
+	var report []byte
+	switch *format {
+	case "text":
+		report = lint.Text(findings)
+	case "json":
+		report, err = lint.JSON(findings)
+	case "sarif":
+		report, err = lint.SARIF(findings)
+	default:
+		return fmt.Errorf("unknown output format %v; expected text, json or sarif", *format)
+	}
+	if err != nil {
+		return err
+	}
+	if err := loader.WriteOutput(*out, report); err != nil {
+		return fmt.Errorf("failed to write the findings:\n%w", err)
+	}
+
+	failing := 0
+	for _, f := range findings {
+		if *failOn == string(lint.Warning) || (*failOn == string(lint.Error) && f.Severity == lint.Error) {
+			failing++
+		}
+	}
+	if failing > 0 {
+		return fmt.Errorf("found %v problems", failing)
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lint checks whistle mappings for mistakes visible in their lineage graphs: projectors
+// that are never called, variables that are never read, targets overwritten before they are read,
+// inputs that feed no output, and conditions that are always the same.
+package lint
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_language/transpiler"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/loader"
+)
+
+// Rule identifies a check.
+type Rule string
+
+const (
+	UnusedProjector   Rule = "unused-projector"
+	UnreadVariable    Rule = "unread-variable"
+	OverwrittenTarget Rule = "overwritten-target"
+	UnusedInput       Rule = "unused-input"
+	ConstantCondition Rule = "constant-condition"
+	// InvalidMapping is a mapping that couldn't be transpiled or turned into a graph.
+	InvalidMapping Rule = "invalid-mapping"
+)
+
+// Severity is how serious a finding is.
+type Severity string
+
+const (
+	Warning Severity = "warning"
+	Error   Severity = "error"
+)
+
+// Rules describes every rule, including the graph diagnostic kinds reported as errors.
+var Rules = map[Rule]string{
+	UnusedProjector:                  "A projector is defined but never called from the root mappings.",
+	UnreadVariable:                   "A local variable is written but never read.",
+	OverwrittenTarget:                "A target is overwritten before anything reads it.",
+	UnusedInput:                      "A $root input field is read but feeds no output.",
+	ConstantCondition:                "A condition depends only on constants, so it is always true or always false. A target whose condition is always false is never written.",
+	InvalidMapping:                   "The mapping couldn't be transpiled or turned into a lineage graph.",
+	Rule(graph.UnsupportedConstruct): "A construct the lineage graph can't represent yet.",
+	Rule(graph.UnresolvedReference):  "A projector, variable, destination or argument that couldn't be found.",
+	Rule(graph.Recursion):            "A projector that calls itself.",
+	Rule(graph.UnknownField):         "An input field or output target missing from its schema.",
+}
+
+// Finding is a problem found in a mapping. The Position is unknown if its line is 0.
+type Finding struct {
+	Rule     Rule               `json:"rule"`
+	Severity Severity           `json:"severity"`
+	Message  string             `json:"message"`
+	Context  string             `json:"context,omitempty"`
+	Position graph.FileMetaData `json:"position"`
+}
+
+func (f Finding) String() string {
+	position := f.Position.FileName
+	if f.Position.LineStart > 0 {
+		position = fmt.Sprintf("%v:%v:%v", f.Position.FileName, f.Position.LineStart, f.Position.CharStart)
+	}
+	return fmt.Sprintf("%v: %v: %v [%v]", position, f.Severity, f.Message, f.Rule)
+}
+
+// Check lints the lineage graph of a mapping. The mapping config is the file's own, without
+// libraries, so that only the projectors it defines are reported as unused. The graph should be
+// built in tolerant mode so that its diagnostics are reported too. The source may be nil, in which
+// case positions are unknown. Findings are sorted by position.
+func Check(mpc *mbp.MappingConfig, g graph.Graph, source *graph.Source) []Finding {
+	var c checker
+	for _, diagnostic := range g.Diagnostics {
+		c.add(Finding{
+			Rule:     Rule(diagnostic.Kind),
+			Severity: Error,
+			Message:  diagnostic.Message,
+			Context:  diagnostic.Context,
+			Position: diagnostic.Position,
+		}, true)
+	}
+	c.unusedProjectors(mpc, g, source)
+	c.unreadVariables(g)
+	c.overwrittenTargets(g)
+	c.unusedInputs(g)
+	c.constantConditions(g)
+	return c.findings()
+}
+
+// File lints a whistle mapping file. The library projectors can be called from the mapping but
+// aren't linted themselves. A mapping that can't be transpiled or turned into a graph is reported
+// as an InvalidMapping finding. Every finding is in the file, even if its position is unknown. The
+// graph is built with the options, in tolerant mode.
+func File(fileName string, whistle []byte, libraries []*mbp.ProjectorDefinition, opts graph.Options) []Finding {
+	invalid := func(err error, position graph.FileMetaData) []Finding {
+		position.FileName = fileName
+		return []Finding{{Rule: InvalidMapping, Severity: Error, Message: err.Error(), Position: position}}
+	}
+	mpc, err := transpiler.Transpile(string(whistle))
+	if err != nil {
+		return invalid(fmt.Errorf("transpiling whistle failed:\n%w", err), graph.FileMetaData{})
+	}
+	opts.Tolerant = true
+	g, source, err := loader.FromMappingConfig(fileName, whistle, mpc, libraries, opts)
+	if err != nil {
+		var gerr graph.Error
+		if errors.As(err, &gerr) {
+			return invalid(gerr, gerr.Info().Position)
+		}
+		return invalid(err, graph.FileMetaData{})
+	}
+	findings := Check(mpc, g, source)
+	for i := range findings {
+		findings[i].Position.FileName = fileName
+	}
+	return findings
+}
+
+// checker collects findings. A finding is reported once however many nodes it is found for, and
+// only if it holds for all of them, since a projector called several times is expanded into
+// several copies of the same nodes.
+type checker struct {
+	order []Finding
+	holds map[Finding]bool
+}
+
+func (c *checker) add(f Finding, holds bool) {
+	if c.holds == nil {
+		c.holds = map[Finding]bool{}
+	}
+	if prev, ok := c.holds[f]; ok {
+		c.holds[f] = prev && holds
+		return
+	}
+	c.holds[f] = holds
+	c.order = append(c.order, f)
+}
+
+func (c *checker) findings() []Finding {
+	findings := []Finding{}
+	for _, f := range c.order {
+		if c.holds[f] {
+			findings = append(findings, f)
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		pi, pj := findings[i].Position, findings[j].Position
+		if pi.FileName != pj.FileName {
+			return pi.FileName < pj.FileName
+		}
+		if pi.LineStart != pj.LineStart {
+			return pi.LineStart < pj.LineStart
+		}
+		return pi.CharStart < pj.CharStart
+	})
+	return findings
+}
+
+func (c *checker) unusedProjectors(mpc *mbp.MappingConfig, g graph.Graph, source *graph.Source) {
+	called := map[string]bool{}
+	for _, node := range g.Nodes {
+		if p, ok := node.(*graph.ProjectorNode); ok {
+			called[p.Name] = true
+		}
+	}
+	for _, projector := range mpc.GetProjector() {
+		name := projector.GetName()
+		if called[name] || strings.HasPrefix(name, "$") { // anonymous blocks are inlined where they are written
+			continue
+		}
+		var position graph.FileMetaData
+		if source != nil {
+			position = source.Defs[name]
+		}
+		c.add(Finding{
+			Rule:     UnusedProjector,
+			Severity: Warning,
+			Message:  fmt.Sprintf("projector %v is never called", name),
+			Position: position,
+		}, true)
+	}
+}
+
+func (c *checker) unreadVariables(g graph.Graph) {
+	read := readNodes(g)
+	for id, node := range g.Nodes {
+		if t, ok := node.(*graph.TargetNode); ok && t.IsVariable {
+			c.add(Finding{
+				Rule:     UnreadVariable,
+				Severity: Warning,
+				Message:  fmt.Sprintf("variable %v is written but never read", t.Name),
+				Context:  t.Context,
+				Position: t.FileData,
+			}, !read[id])
+		}
+	}
+}
+
+// overwrittenTargets finds targets written earlier in the same projector call as a target of the
+// same name written with '!', that nothing reads in between. Nodes are numbered in the order they
+// are generated, which follows the mapping.
+func (c *checker) overwrittenTargets(g graph.Graph) {
+	type scope struct {
+		projector int // the projector call writing the target, or -1 for root mappings
+		name      string
+	}
+	descendants := reverse(g.Edges, g.ArgumentEdges, g.ConditionEdges)
+	writers := reverse(g.Edges)
+	writes := map[scope][]int{}
+	for id, node := range g.Nodes {
+		t, ok := node.(*graph.TargetNode)
+		if !ok || t.IsVariable {
+			continue
+		}
+		s := scope{projector: -1, name: strings.TrimSuffix(t.Name, "!")}
+		for _, d := range writers[id] {
+			if _, ok := g.Nodes[d].(*graph.ProjectorNode); ok {
+				s.projector = d
+			}
+		}
+		writes[s] = append(writes[s], id)
+	}
+	for s, ids := range writes {
+		sort.Ints(ids)
+		for i, id := range ids {
+			overwrite := -1
+			for _, later := range ids[i+1:] {
+				if t := g.Nodes[later].(*graph.TargetNode); t.IsOverwrite || strings.HasSuffix(t.Name, "!") {
+					overwrite = later
+					break
+				}
+			}
+			if overwrite < 0 {
+				continue
+			}
+			readBefore := false
+			for _, d := range descendants[id] {
+				if d != s.projector && d < overwrite {
+					readBefore = true
+				}
+			}
+			t := g.Nodes[id].(*graph.TargetNode)
+			c.add(Finding{
+				Rule:     OverwrittenTarget,
+				Severity: Warning,
+				Message:  fmt.Sprintf("target %v is overwritten before it is read", s.name),
+				Context:  t.Context,
+				Position: t.FileData,
+			}, !readBefore)
+		}
+	}
+}
+
+func (c *checker) unusedInputs(g graph.Graph) {
+	outputs := map[int]bool{}
+	for _, ids := range g.Outputs() {
+		for _, id := range ids {
+			outputs[id] = true
+		}
+	}
+	for id, node := range g.Nodes {
+		r, ok := node.(*graph.RootNode)
+		if !ok {
+			continue
+		}
+		feedsOutput := false
+		for _, d := range g.Downstream(id, true) {
+			feedsOutput = feedsOutput || outputs[d]
+		}
+		c.add(Finding{
+			Rule:     UnusedInput,
+			Severity: Warning,
+			Message:  fmt.Sprintf("input $root%v feeds no output", r.Field),
+			Context:  r.Context,
+			Position: r.FileData,
+		}, !feedsOutput)
+	}
+}
+
+func (c *checker) constantConditions(g graph.Graph) {
+	for targetID, conditionIDs := range g.ConditionEdges {
+		for _, id := range conditionIDs {
+			position := graph.FileData(g.Nodes[id])
+			if position.LineStart == 0 {
+				position = graph.FileData(g.Nodes[targetID])
+			}
+			msg := fmt.Sprintf("the condition of %v depends only on constants", graph.Describe(g.Nodes[targetID]))
+			value, known := g.Evaluate(id)
+			if value == false {
+				msg = fmt.Sprintf("the condition of %v is always false, so it is never written", graph.Describe(g.Nodes[targetID]))
+			} else if value == true {
+				msg = fmt.Sprintf("the condition of %v is always true", graph.Describe(g.Nodes[targetID]))
+			}
+			c.add(Finding{
+				Rule:     ConstantCondition,
+				Severity: Warning,
+				Message:  msg,
+				Context:  graph.Context(g.Nodes[id]),
+				Position: position,
+			}, known || isConstant(g, id))
+		}
+	}
+}
+
+// isConstant returns whether the value of a node depends only on constants: every node it derives
+// from that has no ancestors of its own is a constant.
+func isConstant(g graph.Graph, id int) bool {
+	for _, n := range append(g.Upstream(id, false), id) {
+		switch g.Nodes[n].(type) {
+		case *graph.ConstBoolNode, *graph.ConstIntNode, *graph.ConstFloatNode, *graph.ConstStringNode:
+			continue
+		case *graph.RootNode, *graph.UnknownNode, *graph.ConceptMapNode: // the contents of a concept map aren't known
+			return false
+		}
+		if len(g.Edges[n]) == 0 && len(g.ArgumentEdges[n]) == 0 {
+			return false // a builtin called without arguments, or a target never written
+		}
+	}
+	return true
+}
+
+// readNodes returns the nodes that are an ancestor of any other node. The mappings of a projector's
+// body are ancestors of its call, but a variable written in the body is only read by the mappings
+// reading it, so the edge from the variable to its projector is left out, as in overwrittenTargets.
+func readNodes(g graph.Graph) map[int]bool {
+	read := map[int]bool{}
+	for descendantID, ancestorIDs := range g.Edges {
+		_, isCall := g.Nodes[descendantID].(*graph.ProjectorNode)
+		for _, id := range ancestorIDs {
+			if t, ok := g.Nodes[id].(*graph.TargetNode); ok && t.IsVariable && isCall {
+				continue
+			}
+			read[id] = true
+		}
+	}
+	for _, adjList := range []map[int][]int{g.ArgumentEdges, g.ConditionEdges} {
+		for _, ancestorIDs := range adjList {
+			for _, id := range ancestorIDs {
+				read[id] = true
+			}
+		}
+	}
+	return read
+}
+
+// reverse maps each node to the nodes it is an ancestor of in any of the adjacency lists.
+func reverse(adjLists ...map[int][]int) map[int][]int {
+	descendants := map[int][]int{}
+	for _, adjList := range adjLists {
+		for id, ancestorIDs := range adjList {
+			for _, ancestorID := range ancestorIDs {
+				descendants[ancestorID] = append(descendants[ancestorID], id)
+			}
+		}
+	}
+	return descendants
+}
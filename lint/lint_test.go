@@ -0,0 +1,118 @@
+package lint
+
+import (
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/internal/mappingtest"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		mpc  *mbp.MappingConfig
+		want []Rule
+	}{
+		{
+			name: "clean",
+			mpc: &mbp.MappingConfig{RootMapping: []*mbp.FieldMapping{
+				mappingtest.Mapping("x", mappingtest.FromInput(".a")),
+			}},
+			want: []Rule{},
+		},
+		{
+			name: "unused projector",
+			mpc: &mbp.MappingConfig{
+				Projector:   []*mbp.ProjectorDefinition{{Name: "foo", Mapping: []*mbp.FieldMapping{mappingtest.Mapping("y", mappingtest.ConstInt(1))}}},
+				RootMapping: []*mbp.FieldMapping{mappingtest.Mapping("x", mappingtest.ConstInt(1))},
+			},
+			want: []Rule{UnusedProjector},
+		},
+		{
+			name: "unread variable",
+			mpc: &mbp.MappingConfig{RootMapping: []*mbp.FieldMapping{
+				mappingtest.Variable("v", mappingtest.ConstInt(1)),
+			}},
+			want: []Rule{UnreadVariable},
+		},
+		{
+			name: "unread variable in a projector",
+			mpc: &mbp.MappingConfig{
+				Projector:   []*mbp.ProjectorDefinition{{Name: "foo", Mapping: []*mbp.FieldMapping{mappingtest.Variable("v", mappingtest.ConstInt(1)), mappingtest.Mapping("y", mappingtest.ConstInt(2))}}},
+				RootMapping: []*mbp.FieldMapping{mappingtest.Mapping("x", mappingtest.Call("foo"))},
+			},
+			want: []Rule{UnreadVariable},
+		},
+		{
+			name: "variable read in a projector",
+			mpc: &mbp.MappingConfig{
+				Projector:   []*mbp.ProjectorDefinition{{Name: "foo", Mapping: []*mbp.FieldMapping{mappingtest.Variable("v", mappingtest.ConstInt(1)), mappingtest.Mapping("y", mappingtest.FromVar("v"))}}},
+				RootMapping: []*mbp.FieldMapping{mappingtest.Mapping("x", mappingtest.Call("foo"))},
+			},
+			want: []Rule{},
+		},
+		{
+			name: "overwritten target",
+			mpc: &mbp.MappingConfig{RootMapping: []*mbp.FieldMapping{
+				mappingtest.Mapping("x", mappingtest.ConstInt(1)),
+				mappingtest.Mapping("x!", mappingtest.ConstInt(2)),
+			}},
+			want: []Rule{OverwrittenTarget},
+		},
+		{
+			name: "target read before it is overwritten",
+			mpc: &mbp.MappingConfig{RootMapping: []*mbp.FieldMapping{
+				mappingtest.Mapping("x", mappingtest.ConstInt(1)),
+				mappingtest.Mapping("y", mappingtest.FromDest("x")),
+				mappingtest.Mapping("x!", mappingtest.ConstInt(2)),
+			}},
+			want: []Rule{},
+		},
+		{
+			name: "unused input",
+			mpc: &mbp.MappingConfig{RootMapping: []*mbp.FieldMapping{
+				mappingtest.Variable("v", mappingtest.FromInput(".a")),
+			}},
+			want: []Rule{UnreadVariable, UnusedInput},
+		},
+		{
+			name: "constant condition",
+			mpc: &mbp.MappingConfig{RootMapping: []*mbp.FieldMapping{
+				mappingtest.Guarded("x", mappingtest.FromInput(".a"), mappingtest.Call("$Eq", mappingtest.ConstInt(1), mappingtest.ConstInt(2))),
+			}},
+			want: []Rule{ConstantCondition},
+		},
+		{
+			name: "input condition",
+			mpc: &mbp.MappingConfig{RootMapping: []*mbp.FieldMapping{
+				mappingtest.Guarded("x", mappingtest.ConstInt(1), mappingtest.Call("$Eq", mappingtest.FromInput(".a"), mappingtest.ConstInt(2))),
+			}},
+			want: []Rule{},
+		},
+		{
+			name: "graph diagnostic",
+			mpc: &mbp.MappingConfig{RootMapping: []*mbp.FieldMapping{
+				mappingtest.Mapping("x", mappingtest.Call("missing")),
+			}},
+			want: []Rule{Rule(graph.UnresolvedReference)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := graph.NewWithOptions(test.mpc, graph.Options{Tolerant: true})
+			if err != nil {
+				t.Fatalf("building the graph failed:\n%v", err)
+			}
+			got := []Rule{}
+			for _, f := range Check(test.mpc, g, nil) {
+				got = append(got, f.Rule)
+			}
+			if !cmp.Equal(test.want, got) {
+				t.Errorf("expected findings %v, but got %v", test.want, Check(test.mpc, g, nil))
+			}
+		},
+		)
+	}
+}
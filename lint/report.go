@@ -0,0 +1,147 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// toolName names the linter in SARIF reports.
+const toolName = "healthcare-data-harmonization-lineage"
+
+// Text formats the findings one per line.
+func Text(findings []Finding) []byte {
+	var b strings.Builder
+	for _, f := range findings {
+		b.WriteString(f.String())
+		b.WriteString("\n")
+	}
+	return []byte(b.String())
+}
+
+// JSON formats the findings as a JSON array.
+func JSON(findings []Finding) ([]byte, error) {
+	out, err := json.MarshalIndent(findings, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the findings:\n%w", err)
+	}
+	return out, nil
+}
+
+// the subset of SARIF 2.1.0 the linter writes
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine"`
+	EndColumn   int `json:"endColumn"`
+}
+
+// SARIF formats the findings as a SARIF 2.1.0 log, which code scanning tools can display.
+func SARIF(findings []Finding) ([]byte, error) {
+	rules := []sarifRule{}
+	for rule, description := range Rules {
+		rules = append(rules, sarifRule{ID: string(rule), ShortDescription: sarifMessage{Text: description}})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+
+	results := []sarifResult{}
+	for _, f := range findings {
+		result := sarifResult{
+			RuleID:  string(f.Rule),
+			Level:   string(f.Severity),
+			Message: sarifMessage{Text: f.Message},
+		}
+		if f.Position.FileName != "" {
+			location := sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: f.Position.FileName},
+			}}
+			if f.Position.LineStart > 0 {
+				location.PhysicalLocation.Region = &sarifRegion{
+					StartLine:   f.Position.LineStart,
+					StartColumn: f.Position.CharStart,
+					EndLine:     f.Position.LineEnd,
+					EndColumn:   f.Position.CharEnd,
+				}
+			}
+			result.Locations = []sarifLocation{location}
+		}
+		results = append(results, result)
+	}
+
+	out, err := json.MarshalIndent(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs: []sarifRun{{
+			Tool:    sarifTool{Driver: sarifDriver{Name: toolName, Rules: rules}},
+			Results: results,
+		}},
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the SARIF log:\n%w", err)
+	}
+	return out, nil
+}
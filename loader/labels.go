@@ -0,0 +1,51 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+)
+
+// labelConfig is the format of a label config file, such as
+// {"labels": [{"field": "$root.patient.ssn", "labels": ["PHI"]}]}.
+type labelConfig struct {
+	Labels []graph.LabelRule `json:"labels"`
+}
+
+// ReadLabelRules reads the input field label rules from a JSON config file.
+func ReadLabelRules(path string) ([]graph.LabelRule, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the label config %v:\n%w", path, err)
+	}
+	var config labelConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse the label config %v:\n%w", path, err)
+	}
+	for _, rule := range config.Labels {
+		if rule.Field != "$root" && !strings.HasPrefix(rule.Field, "$root.") {
+			return nil, fmt.Errorf("the labelled field %q in %v is not a $root field", rule.Field, path)
+		}
+		if len(rule.Labels) == 0 {
+			return nil, fmt.Errorf("the field %v in %v has no labels", rule.Field, path)
+		}
+	}
+	return config.Labels, nil
+}
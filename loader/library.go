@@ -0,0 +1,64 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+)
+
+// LibraryFiles returns the whistle files in a library directory and its subdirectories, like the
+// mapping engine's -lib_dir_spec.
+func LibraryFiles(dir string) ([]string, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(path) == WhistleExt {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the library files in %v:\n%w", dir, err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// LibraryProjectors transpiles the whistle files in a library directory and returns the projectors they define.
+func LibraryProjectors(dir string) ([]*mbp.ProjectorDefinition, error) {
+	files, err := LibraryFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	projectors := []*mbp.ProjectorDefinition{}
+	for _, file := range files {
+		mpc, err := Transpile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load library %v:\n%w", file, err)
+		}
+		projectors = append(projectors, mpc.GetProjector()...)
+	}
+	return projectors, nil
+}
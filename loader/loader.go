@@ -0,0 +1,218 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package loader reads whistle mappings and saved lineage graphs from disk.
+package loader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_language/transpiler"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	gpb "github.com/googleinterns/healthcare-data-harmonization-lineage/graph/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// StdioSpec is the file spec used to read from stdin or write to stdout.
+const StdioSpec = "-"
+
+// WhistleExt is the file extension of whistle mappings.
+const WhistleExt = ".wstl"
+
+// protobufExts are the file extensions of serialized protobuf graphs.
+var protobufExts = map[string]bool{".pb": true, ".bin": true, ".binpb": true}
+
+// IsProtobuf returns whether the file spec names a serialized protobuf graph rather than a whistle mapping.
+func IsProtobuf(spec string) bool {
+	return protobufExts[filepath.Ext(spec)]
+}
+
+// ReadMapping reads the whistle mapping named by the file spec, or stdin if the spec is "-".
+func ReadMapping(spec string) ([]byte, error) {
+	if spec == StdioSpec {
+		whistle, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the mapping from stdin:\n%w", err)
+		}
+		return whistle, nil
+	}
+	whistle, err := ioutil.ReadFile(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the mapping file:\n%w", err)
+	}
+	return whistle, nil
+}
+
+// WriteOutput writes the output to the file spec, or stdout if the spec is "-".
+func WriteOutput(spec string, out []byte) error {
+	if spec == StdioSpec {
+		_, err := os.Stdout.Write(out)
+		return err
+	}
+	return ioutil.WriteFile(spec, out, 0644)
+}
+
+// Transpile reads and transpiles the whistle mapping named by the file spec.
+func Transpile(spec string) (*mbp.MappingConfig, error) {
+	whistle, err := ReadMapping(spec)
+	if err != nil {
+		return nil, err
+	}
+	mpc, err := transpiler.Transpile(string(whistle))
+	if err != nil {
+		return nil, fmt.Errorf("Transpiling whistle failed:\n%w", err)
+	}
+	return mpc, nil
+}
+
+// Graph loads a lineage graph from a file. Serialized protobuf graphs are read as they are; any
+// other file is read as a whistle mapping and turned into a new graph built with the options, whose
+// calls can reach the library projectors.
+func Graph(spec string, libraries []*mbp.ProjectorDefinition, opts graph.Options) (graph.Graph, error) {
+	if IsProtobuf(spec) {
+		return ReadProtobuf(spec)
+	}
+	whistle, err := ReadMapping(spec)
+	if err != nil {
+		return graph.Graph{}, err
+	}
+	g, _, err := FromWhistle(spec, whistle, libraries, opts)
+	return g, err
+}
+
+// FromWhistle transpiles the whistle mapping and generates its lineage graph. The library projectors
+// can be called from the mapping, which takes precedence if it defines a projector of the same name.
+// The graph is built with the given options, its nodes are annotated with their positions in the
+// named file, and the index of the file is returned. A graph.Error returned is located in the file.
+func FromWhistle(fileName string, whistle []byte, libraries []*mbp.ProjectorDefinition, opts graph.Options) (graph.Graph, *graph.Source, error) {
+	mpc, err := transpiler.Transpile(string(whistle))
+	if err != nil {
+		return graph.Graph{}, nil, fmt.Errorf("%v: Transpiling whistle failed:\n%w", fileName, err)
+	}
+	return FromMappingConfig(fileName, whistle, mpc, libraries, opts)
+}
+
+// FromMappingConfig generates the lineage graph of a mapping already transpiled from the whistle
+// source, like FromWhistle. The mapping config isn't modified.
+func FromMappingConfig(fileName string, whistle []byte, mpc *mbp.MappingConfig, libraries []*mbp.ProjectorDefinition, opts graph.Options) (graph.Graph, *graph.Source, error) {
+	if len(libraries) > 0 {
+		withLibraries := proto.Clone(mpc).(*mbp.MappingConfig)
+		withLibraries.Projector = append(append([]*mbp.ProjectorDefinition{}, libraries...), mpc.GetProjector()...)
+		mpc = withLibraries
+	}
+	source := graph.ParseSource(fileName, string(whistle))
+	if opts.Params == nil {
+		opts.Params = source.Params
+	}
+	g, err := graph.NewWithOptions(mpc, opts)
+	if err != nil {
+		source.Locate(err)
+		return graph.Graph{}, nil, fmt.Errorf("%v: Graph construction failed:\n%w", fileName, err)
+	}
+	source.Annotate(g)
+	return g, source, nil
+}
+
+// TraceWhistle transpiles the whistle mapping and runs it on the input, decoded from JSON, returning
+// the instance graph of the run and the mapping's output, like graph.Trace. The library projectors
+// can be called from the mapping, and the graph's nodes are annotated with their positions in the
+// named file. The options are passed to graph.Trace.
+func TraceWhistle(fileName string, whistle []byte, libraries []*mbp.ProjectorDefinition, input interface{}, opts graph.Options) (graph.Graph, interface{}, error) {
+	mpc, err := transpiler.Transpile(string(whistle))
+	if err != nil {
+		return graph.Graph{}, nil, fmt.Errorf("%v: Transpiling whistle failed:\n%w", fileName, err)
+	}
+	if len(libraries) > 0 {
+		mpc.Projector = append(append([]*mbp.ProjectorDefinition{}, libraries...), mpc.GetProjector()...)
+	}
+	source := graph.ParseSource(fileName, string(whistle))
+	g, output, err := graph.Trace(mpc, input, opts)
+	if err != nil {
+		source.Locate(err)
+		return graph.Graph{}, nil, fmt.Errorf("%v: Tracing the mapping failed:\n%w", fileName, err)
+	}
+	source.Annotate(g)
+	return g, output, nil
+}
+
+// ReadJSON reads a JSON file, such as a sample input of a mapping.
+func ReadJSON(path string) (interface{}, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %v:\n%w", path, err)
+	}
+	var value interface{}
+	if err := json.Unmarshal(content, &value); err != nil {
+		return nil, fmt.Errorf("failed to parse %v as JSON:\n%w", path, err)
+	}
+	return value, nil
+}
+
+// SampleFiles lists the JSON files of a comma-separated list of files and directories of them,
+// in file name order within a directory.
+func SampleFiles(spec string) ([]string, error) {
+	files := []string{}
+	for _, path := range strings.Split(spec, ",") {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the samples:\n%w", err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the samples in %v:\n%w", path, err)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// ReadSamples reads the sample inputs of the JSON files listed by SampleFiles.
+func ReadSamples(spec string) ([]interface{}, error) {
+	files, err := SampleFiles(spec)
+	if err != nil {
+		return nil, err
+	}
+	samples := []interface{}{}
+	for _, file := range files {
+		sample, err := ReadJSON(file)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+// ReadProtobuf reads a lineage graph from a serialized protobuf file.
+func ReadProtobuf(path string) (graph.Graph, error) {
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return graph.Graph{}, fmt.Errorf("failed to read the protobuf graph:\n%w", err)
+	}
+	pbGraph := &gpb.Graph{}
+	if err := proto.Unmarshal(in, pbGraph); err != nil {
+		return graph.Graph{}, fmt.Errorf("failed to unmarshal the protobuf graph %v:\n%w", path, err)
+	}
+	return graph.ReadProtobuf(pbGraph)
+}
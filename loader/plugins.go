@@ -0,0 +1,51 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+)
+
+// pluginConfig is the format of a plugin config file, such as
+// {"plugins": [{"name": "LookupCode", "numArgs": 2, "outputs": {"code": [1], "display": [1, 2]}, "lookup": true}]}.
+type pluginConfig struct {
+	Plugins []graph.Plugin `json:"plugins"`
+}
+
+// ReadPlugins reads the signatures of the Go projectors the mappings can call from a JSON config
+// file, to be passed to graph.Options. An empty path reads none.
+func ReadPlugins(path string) ([]graph.Plugin, error) {
+	if path == "" {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the plugin config %v:\n%w", path, err)
+	}
+	var config pluginConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse the plugin config %v:\n%w", path, err)
+	}
+	for _, plugin := range config.Plugins {
+		if err := plugin.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid plugin in %v:\n%w", path, err)
+		}
+	}
+	return config.Plugins, nil
+}
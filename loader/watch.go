@@ -0,0 +1,109 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loader
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+// Watcher polls a set of files for changes. Polling keeps the watcher portable and dependency-free;
+// mapping files are small and few, so the cost of a stat per file per interval is negligible.
+type Watcher struct {
+	Interval time.Duration
+	// Debounce is how long the files must stay unchanged before a change is reported, so that
+	// saving several files at once only reports a single change.
+	Debounce time.Duration
+	modTimes map[string]time.Time
+}
+
+// NewWatcher returns a watcher that checks for changes every interval and reports them once the
+// files have been quiet for the debounce duration.
+func NewWatcher(interval time.Duration, debounce time.Duration) *Watcher {
+	return &Watcher{
+		Interval: interval,
+		Debounce: debounce,
+		modTimes: map[string]time.Time{},
+	}
+}
+
+// Watch calls onChange with the changed files every time one of the files returned by paths is
+// created, modified or removed. paths is called on every poll, so the set of watched files may
+// change between calls. Watch blocks until the stop channel is closed.
+func (w *Watcher) Watch(paths func() []string, onChange func(changed []string), stop <-chan struct{}) {
+	w.poll(paths(), false) // record the initial state without reporting it
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	pending := map[string]bool{}
+	var lastChange time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			changed := w.poll(paths(), true)
+			for _, path := range changed {
+				pending[path] = true
+			}
+			if len(changed) > 0 {
+				lastChange = now
+			}
+			if len(pending) > 0 && now.Sub(lastChange) >= w.Debounce {
+				onChange(sortedKeys(pending))
+				pending = map[string]bool{}
+			}
+		}
+	}
+}
+
+// poll stats every file and returns the ones whose modification time differs from the last poll,
+// including files that were not watched before if report is true.
+func (w *Watcher) poll(paths []string, report bool) []string {
+	changed := []string{}
+	seen := map[string]bool{}
+	for _, path := range paths {
+		seen[path] = true
+		var modTime time.Time
+		if info, err := os.Stat(path); err == nil {
+			modTime = info.ModTime()
+		}
+		if last, ok := w.modTimes[path]; !ok || !last.Equal(modTime) {
+			if report {
+				changed = append(changed, path)
+			}
+			w.modTimes[path] = modTime
+		}
+	}
+	for path := range w.modTimes {
+		if !seen[path] {
+			delete(w.modTimes, path)
+			if report {
+				changed = append(changed, path)
+			}
+		}
+	}
+	return changed
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
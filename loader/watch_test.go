@@ -0,0 +1,96 @@
+package loader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatalf("failed to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	a, b, c := filepath.Join(dir, "a.wstl"), filepath.Join(dir, "b.wstl"), filepath.Join(dir, "c.wstl")
+	for _, path := range []string{a, b} {
+		if err := ioutil.WriteFile(path, []byte("x: 1"), 0644); err != nil {
+			t.Fatalf("failed to write %v: %v", path, err)
+		}
+	}
+	modTime := time.Now()
+	touch := func(path string) {
+		modTime = modTime.Add(time.Minute)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("failed to touch %v: %v", path, err)
+		}
+	}
+
+	changes := make(chan []string, 10)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	w := NewWatcher(5*time.Millisecond, 100*time.Millisecond)
+	go func() {
+		w.Watch(func() []string { return []string{a, b, c} }, func(changed []string) { changes <- changed }, stop)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond) // let the watcher record the initial state
+
+	tests := []struct {
+		name   string
+		change func()
+		want   []string
+	}{
+		{
+			name: "changes within the debounce duration are reported once",
+			change: func() {
+				touch(a)
+				time.Sleep(30 * time.Millisecond)
+				touch(b)
+			},
+			want: []string{a, b},
+		},
+		{
+			name: "created file",
+			change: func() {
+				if err := ioutil.WriteFile(c, []byte("y: 2"), 0644); err != nil {
+					t.Fatalf("failed to write %v: %v", c, err)
+				}
+			},
+			want: []string{c},
+		},
+		{
+			name: "removed file",
+			change: func() {
+				if err := os.Remove(a); err != nil {
+					t.Fatalf("failed to remove %v: %v", a, err)
+				}
+			},
+			want: []string{a},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.change()
+			select {
+			case got := <-changes:
+				if diff := cmp.Diff(test.want, got); diff != "" {
+					t.Errorf("Watch() reported unexpected changes (-want +got):\n%v", diff)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Watch() didn't report the changes %v", test.want)
+			}
+		},
+		)
+	}
+
+	close(stop)
+	<-done
+	if len(changes) > 0 {
+		t.Errorf("Watch() reported extra changes %v", <-changes)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/loader"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/lsp"
+)
+
+// runLSP runs a language server for whistle mappings over stdin and stdout.
+func runLSP(args []string) error {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	pluginsSpec := flags.String("plugins", "", pluginsUsage)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v lsp [flags]\n", flag.CommandLine.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	plugins, err := loader.ReadPlugins(*pluginsSpec)
+	if err != nil {
+		return err
+	}
+	log.SetOutput(os.Stderr) // stdout carries the protocol
+	return lsp.Serve(os.Stdin, os.Stdout, graph.Options{Plugins: plugins})
+}
@@ -0,0 +1,430 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lsp implements a Language Server Protocol server for whistle mappings that answers
+// editor queries from the lineage graph:
+//   - hovering over a target shows its upstream sources and the concept maps translating them,
+//   - going to the definition of an argument jumps to the call sites feeding it,
+//   - finding the references of a $root input field lists every output it reaches,
+//   - the findings of the linter, such as unused variables and projectors, are reported as diagnostics.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_language/transpiler"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/lint"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/loader"
+)
+
+// document is an open whistle file and the lineage graph of its latest contents.
+type document struct {
+	uri    string
+	text   string
+	lines  []string
+	mpc    *mbp.MappingConfig
+	graph  graph.Graph
+	source *graph.Source
+	err    error
+}
+
+// Server is a language server for whistle mappings.
+type Server struct {
+	conn     *conn
+	docs     map[string]*document
+	opts     graph.Options
+	shutdown bool
+}
+
+// Serve runs a language server reading requests from in and writing responses to out until the
+// client asks it to exit. The graphs of the documents are built with the options, in tolerant mode.
+func Serve(in io.Reader, out io.Writer, opts graph.Options) error {
+	opts.Tolerant = true
+	s := &Server{
+		conn: &conn{in: bufio.NewReader(in), out: out},
+		docs: map[string]*document{},
+		opts: opts,
+	}
+	for {
+		msg, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("the client exited without shutting down the server")
+			}
+			return nil
+		}
+		result, respErr := s.handle(msg)
+		if msg.ID == nil {
+			continue // notifications have no response
+		}
+		if err := s.conn.reply(msg.ID, result, respErr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(msg *message) (interface{}, *responseError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": map[string]interface{}{
+					"openClose": true,
+					"change":    1, // the full text is sent on every change
+					"save":      map[string]bool{"includeText": true},
+				},
+				"hoverProvider":      true,
+				"definitionProvider": true,
+				"referencesProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "whistle-lineage"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		params := didOpenParams{}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &responseError{Code: invalidParams, Message: err.Error()}
+		}
+		s.update(params.TextDocument.URI, params.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		params := didChangeParams{}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &responseError{Code: invalidParams, Message: err.Error()}
+		}
+		if len(params.ContentChanges) > 0 {
+			s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didSave":
+		params := didSaveParams{}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &responseError{Code: invalidParams, Message: err.Error()}
+		}
+		if params.Text != nil {
+			s.update(params.TextDocument.URI, *params.Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		params := didSaveParams{}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &responseError{Code: invalidParams, Message: err.Error()}
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, nil
+	case "textDocument/hover":
+		return s.withPosition(msg, s.hover)
+	case "textDocument/definition":
+		return s.withPosition(msg, s.definition)
+	case "textDocument/references":
+		return s.withPosition(msg, s.references)
+	case "initialized", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+	default:
+		if msg.ID == nil {
+			return nil, nil // unknown notifications are ignored
+		}
+		return nil, &responseError{Code: methodNotFound, Message: fmt.Sprintf("method %v is not supported", msg.Method)}
+	}
+}
+
+// update rebuilds the lineage graph of a document and publishes its diagnostics.
+func (s *Server) update(uri string, text string) {
+	mpc, err := transpiler.Transpile(text)
+	doc := newDocument(uri, text, mpc, err, s.opts)
+	s.docs[uri] = doc
+	s.conn.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: diagnostics(doc),
+	})
+}
+
+// newDocument builds the lineage graph of a document from its transpiled mapping with the options,
+// unless transpiling it failed with err.
+func newDocument(uri string, text string, mpc *mbp.MappingConfig, err error, opts graph.Options) *document {
+	doc := &document{uri: uri, text: text, lines: strings.Split(text, "\n"), mpc: mpc, err: err}
+	if doc.err == nil {
+		doc.graph, doc.source, doc.err = loader.FromMappingConfig(uriToPath(uri), []byte(text), doc.mpc, nil, opts)
+	}
+	return doc
+}
+
+// withPosition looks up the document and the nodes at the position of a request and passes them to the handler.
+func (s *Server) withPosition(msg *message, handler func(*document, []graph.Node, Position) interface{}) (interface{}, *responseError) {
+	params := textDocumentPositionParams{}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return nil, &responseError{Code: invalidParams, Message: err.Error()}
+	}
+	doc, ok := s.docs[params.TextDocument.URI]
+	if !ok || doc.err != nil {
+		return nil, nil
+	}
+	nodes := []graph.Node{}
+	for _, id := range doc.source.NodesAt(doc.graph, params.Position.Line+1, doc.column(params.Position)) {
+		nodes = append(nodes, doc.graph.Nodes[id])
+	}
+	return handler(doc, nodes, params.Position), nil
+}
+
+// hover shows the upstream sources of the target under the cursor, and the input fields translated
+// by concept maps on the way.
+func (s *Server) hover(doc *document, nodes []graph.Node, pos Position) interface{} {
+	for _, node := range nodes {
+		target, ok := node.(*graph.TargetNode)
+		if !ok {
+			continue
+		}
+		sources := []string{}
+		projectors := []string{}
+		for _, id := range doc.graph.Upstream(target.ID(), false) {
+			switch n := doc.graph.Nodes[id].(type) {
+			case *graph.RootNode:
+				sources = append(sources, "$root"+n.Field)
+			case *graph.ConstBoolNode:
+				sources = append(sources, fmt.Sprintf("%v", n.Value))
+			case *graph.ConstIntNode:
+				sources = append(sources, fmt.Sprintf("%v", n.Value))
+			case *graph.ConstFloatNode:
+				sources = append(sources, fmt.Sprintf("%v", n.Value))
+			case *graph.ConstStringNode:
+				sources = append(sources, fmt.Sprintf("%q", n.Value))
+			case *graph.ProjectorNode:
+				projectors = append(projectors, n.Name)
+			}
+		}
+		lines := []string{fmt.Sprintf("**%v** (%v)", target.Name, target.Context)}
+		if len(sources) == 0 {
+			lines = append(lines, "", "No upstream sources.")
+		} else {
+			lines = append(lines, "", "Upstream sources:")
+			for _, source := range uniqueSorted(sources) {
+				lines = append(lines, "* `"+source+"`")
+			}
+		}
+		if len(projectors) > 0 {
+			lines = append(lines, "", "Through projectors: `"+strings.Join(uniqueSorted(projectors), "`, `")+"`")
+		}
+		translations := []string{}
+		for _, d := range doc.graph.Derivations(target.ID()) {
+			if len(d.ConceptMaps) > 0 {
+				translations = append(translations, doc.graph.DescribeDerivation(d))
+			}
+		}
+		if len(translations) > 0 {
+			lines = append(lines, "", "Code translations:")
+			for _, translation := range uniqueSorted(translations) {
+				lines = append(lines, "* "+translation)
+			}
+		}
+		r := doc.toRange(target.FileData)
+		return hover{
+			Contents: markupContent{Kind: "markdown", Value: strings.Join(lines, "\n")},
+			Range:    &r,
+		}
+	}
+	return nil
+}
+
+// definition jumps from an argument to the call sites feeding it, and from a projector call to its definition.
+func (s *Server) definition(doc *document, nodes []graph.Node, pos Position) interface{} {
+	locations := []Location{}
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *graph.ArgumentNode:
+			for _, ancestorID := range doc.graph.Edges[n.ID()] {
+				locations = append(locations, callSiteLocations(doc, ancestorID)...)
+			}
+		case *graph.ProjectorNode:
+			if def, ok := doc.source.Defs[n.Name]; ok {
+				locations = append(locations, Location{URI: doc.uri, Range: doc.toRange(def)})
+			}
+		}
+	}
+	return uniqueLocations(locations)
+}
+
+// callSiteLocations returns the location of a node passed as an argument or, if the node has no
+// position like constants, the locations of the projector calls it is passed to.
+func callSiteLocations(doc *document, id int) []Location {
+	if data := graph.FileData(doc.graph.Nodes[id]); data.LineStart > 0 {
+		return []Location{{URI: doc.uri, Range: doc.toRange(data)}}
+	}
+	locations := []Location{}
+	for projID, argIDs := range doc.graph.ArgumentEdges {
+		for _, argID := range argIDs {
+			if data := graph.FileData(doc.graph.Nodes[projID]); argID == id && data.LineStart > 0 {
+				locations = append(locations, Location{URI: doc.uri, Range: doc.toRange(data)})
+			}
+		}
+	}
+	return locations
+}
+
+// references lists the outputs the $root input field under the cursor reaches.
+func (s *Server) references(doc *document, nodes []graph.Node, pos Position) interface{} {
+	outputIDs := map[int]bool{}
+	for _, ids := range doc.graph.Outputs() {
+		for _, id := range ids {
+			outputIDs[id] = true
+		}
+	}
+	locations := []Location{}
+	for _, node := range nodes {
+		if _, ok := node.(*graph.RootNode); !ok {
+			continue
+		}
+		for _, id := range doc.graph.Downstream(node.ID(), false) {
+			if data := graph.FileData(doc.graph.Nodes[id]); outputIDs[id] && data.LineStart > 0 {
+				locations = append(locations, Location{URI: doc.uri, Range: doc.toRange(data)})
+			}
+		}
+	}
+	return uniqueLocations(locations)
+}
+
+// diagnostics reports transpiling and graph errors, and the findings of the linter.
+func diagnostics(doc *document) []Diagnostic {
+	if doc.err != nil {
+		var gerr graph.Error
+		if errors.As(doc.err, &gerr) { // report the graph error alone, at its position
+			return []Diagnostic{{
+				Range:    doc.toRange(gerr.Info().Position),
+				Severity: severityError,
+				Source:   "lineage",
+				Message:  gerr.Error(),
+			}}
+		}
+		return []Diagnostic{{
+			Severity: severityError,
+			Source:   "lineage",
+			Message:  doc.err.Error(),
+		}}
+	}
+	diags := []Diagnostic{}
+	for _, f := range lint.Check(doc.mpc, doc.graph, doc.source) {
+		severity := severityWarning
+		if f.Severity == lint.Error {
+			severity = severityError
+		} else if f.Position.LineStart == 0 {
+			continue // a warning is only useful where it can be shown
+		}
+		diags = append(diags, Diagnostic{
+			Range:    doc.toRange(f.Position),
+			Severity: severity,
+			Code:     string(f.Rule),
+			Source:   "lineage",
+			Message:  f.Message,
+		})
+	}
+	sort.Slice(diags, func(i, j int) bool {
+		if diags[i].Range.Start.Line != diags[j].Range.Start.Line {
+			return diags[i].Range.Start.Line < diags[j].Range.Start.Line
+		}
+		return diags[i].Range.Start.Character < diags[j].Range.Start.Character
+	})
+	return diags
+}
+
+// toRange converts the 1-based FileMetaData, whose characters are byte offsets, to a zero-based LSP
+// range. Unknown positions become the start of the file.
+func (d *document) toRange(data graph.FileMetaData) Range {
+	if data.LineStart == 0 {
+		return Range{}
+	}
+	return Range{
+		Start: Position{Line: data.LineStart - 1, Character: d.character(data.LineStart, data.CharStart)},
+		End:   Position{Line: data.LineEnd - 1, Character: d.character(data.LineEnd, data.CharEnd)},
+	}
+}
+
+// character converts a 1-based byte offset into a 1-based line to a zero-based UTF-16 offset. An
+// offset of 0, as given when the column isn't known, is taken as the start of the line.
+func (d *document) character(line int, char int) int {
+	if char < 1 {
+		return 0
+	}
+	if line < 1 || line > len(d.lines) {
+		return char - 1
+	}
+	text := d.lines[line-1]
+	if char-1 > len(text) {
+		char = len(text) + 1
+	}
+	return len(utf16.Encode([]rune(text[:char-1])))
+}
+
+// column converts the UTF-16 offset of a position to a 1-based byte offset into its line.
+func (d *document) column(pos Position) int {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return pos.Character + 1
+	}
+	text := d.lines[pos.Line]
+	units := 0
+	for i, r := range text {
+		if units >= pos.Character {
+			return i + 1
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return len(text) + 1
+}
+
+func uriToPath(uri string) string {
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		return u.Path
+	}
+	return uri
+}
+
+func uniqueSorted(strs []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, str := range strs {
+		if !seen[str] {
+			seen[str] = true
+			unique = append(unique, str)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+func uniqueLocations(locations []Location) []Location {
+	seen := map[Location]bool{}
+	unique := []Location{}
+	for _, location := range locations {
+		if !seen[location] {
+			seen[location] = true
+			unique = append(unique, location)
+		}
+	}
+	return unique
+}
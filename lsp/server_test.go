@@ -0,0 +1,227 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/internal/mappingtest"
+)
+
+// frame encodes messages with Content-Length headers, as a client sends them.
+func frame(t *testing.T, msgs ...interface{}) *bytes.Buffer {
+	t.Helper()
+	in := &bytes.Buffer{}
+	for _, msg := range msgs {
+		body, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatalf("failed to encode %v: %v", msg, err)
+		}
+		fmt.Fprintf(in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	return in
+}
+
+func TestServe(t *testing.T) {
+	in := frame(t,
+		map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": map[string]interface{}{}},
+		map[string]interface{}{"jsonrpc": "2.0", "method": "initialized", "params": map[string]interface{}{}},
+		map[string]interface{}{"jsonrpc": "2.0", "id": 2, "method": "shutdown"},
+		map[string]interface{}{"jsonrpc": "2.0", "method": "exit"},
+	)
+	out := &bytes.Buffer{}
+	if err := Serve(in, out, graph.Options{}); err != nil {
+		t.Fatalf("Serve() returned an unexpected error: %v", err)
+	}
+
+	c := &conn{in: bufio.NewReader(out)}
+	got := []string{}
+	for {
+		msg, err := c.read()
+		if err != nil {
+			break
+		}
+		if msg.ID == nil {
+			got = append(got, "notification "+msg.Method)
+		} else {
+			got = append(got, "response "+string(*msg.ID))
+		}
+	}
+	want := []string{"response 1", "response 2"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Serve() wrote unexpected messages (-want +got):\n%v", diff)
+	}
+}
+
+func TestServe_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      map[string]interface{}
+		wantCode int
+	}{
+		{
+			name:     "unknown method",
+			msg:      map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "textDocument/formatting", "params": map[string]interface{}{}},
+			wantCode: methodNotFound,
+		},
+		{
+			name:     "invalid params",
+			msg:      map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "textDocument/hover", "params": "not a position"},
+			wantCode: invalidParams,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			if err := Serve(frame(t, test.msg), out, graph.Options{}); err != nil {
+				t.Fatalf("Serve() returned an unexpected error: %v", err)
+			}
+			body := out.Bytes()[bytes.Index(out.Bytes(), []byte("\r\n\r\n"))+4:]
+			resp := struct {
+				Error *responseError `json:"error"`
+			}{}
+			if err := json.Unmarshal(body, &resp); err != nil {
+				t.Fatalf("failed to decode the response %s: %v", body, err)
+			}
+			if resp.Error == nil || resp.Error.Code != test.wantCode {
+				t.Errorf("Serve() responded %s, want error code %v", body, test.wantCode)
+			}
+		},
+		)
+	}
+}
+
+func TestServe_ExitWithoutShutdown(t *testing.T) {
+	in := frame(t, map[string]interface{}{"jsonrpc": "2.0", "method": "exit"})
+	if err := Serve(in, &bytes.Buffer{}, graph.Options{}); err == nil {
+		t.Errorf("Serve() returned no error, want an error for exiting without shutting down")
+	}
+}
+
+func TestDocument_Positions(t *testing.T) {
+	doc := &document{lines: []string{`x: "Zoë 😀" + $root.a`, "y: 1"}}
+	tests := []struct {
+		name      string
+		line      int // 1-based
+		char      int // 1-based byte offset
+		wantUTF16 int // zero-based
+	}{
+		{
+			name:      "ASCII prefix",
+			line:      1,
+			char:      4,
+			wantUTF16: 3,
+		},
+		{
+			name:      "after a two byte character",
+			line:      1,
+			char:      9, // the space after ë
+			wantUTF16: 7,
+		},
+		{
+			name:      "after a surrogate pair",
+			line:      1,
+			char:      14, // the closing quote
+			wantUTF16: 10,
+		},
+		{
+			name:      "end of the line",
+			line:      1,
+			char:      25,
+			wantUTF16: 21,
+		},
+		{
+			name:      "other line",
+			line:      2,
+			char:      4,
+			wantUTF16: 3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := doc.character(test.line, test.char); got != test.wantUTF16 {
+				t.Errorf("character(%v, %v) = %v, want %v", test.line, test.char, got, test.wantUTF16)
+			}
+			pos := Position{Line: test.line - 1, Character: test.wantUTF16}
+			if got := doc.column(pos); got != test.char {
+				t.Errorf("column(%v) = %v, want %v", pos, got, test.char)
+			}
+		},
+		)
+	}
+}
+
+func TestDocument_UnknownCharacter(t *testing.T) {
+	doc := &document{lines: []string{`x: "Zoë"`}}
+	for _, line := range []int{1, 2} {
+		if got := doc.character(line, 0); got != 0 {
+			t.Errorf("character(%v, 0) = %v, want 0", line, got)
+		}
+	}
+}
+
+func TestReferences_NonASCII(t *testing.T) {
+	// the $root.a read follows characters of two and four bytes, of one and two UTF-16 code units
+	text := `x: $StrCat("Zoë 😀", $root.a)` + "\n"
+	mpc := &mbp.MappingConfig{RootMapping: []*mbp.FieldMapping{
+		mappingtest.Mapping("x", mappingtest.Call("$StrCat",
+			&mbp.ValueSource{Source: &mbp.ValueSource_ConstString{ConstString: "Zoë 😀"}},
+			mappingtest.FromInput(".a"))),
+	}}
+	doc := newDocument("file:///mapping.wstl", text, mpc, nil, graph.Options{Tolerant: true})
+	if doc.err != nil {
+		t.Fatalf("newDocument() returned an unexpected error: %v", doc.err)
+	}
+	s := &Server{docs: map[string]*document{doc.uri: doc}}
+
+	params, err := json.Marshal(textDocumentPositionParams{
+		TextDocument: textDocumentIdentifier{URI: doc.uri},
+		Position:     Position{Line: 0, Character: 21}, // the $ of $root.a, at byte 24
+	})
+	if err != nil {
+		t.Fatalf("failed to encode the params: %v", err)
+	}
+	got, respErr := s.handle(&message{Method: "textDocument/references", Params: params})
+	if respErr != nil {
+		t.Fatalf("handle() returned an unexpected error: %v", respErr)
+	}
+	want := []Location{{URI: doc.uri, Range: Range{End: Position{Character: 1}}}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("references returned unexpected locations (-want +got):\n%v", diff)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  *document
+		want []Diagnostic
+	}{
+		{
+			name: "transpiling error",
+			doc:  newDocument("file:///mapping.wstl", "x: (", nil, errors.New("syntax error"), graph.Options{Tolerant: true}),
+			want: []Diagnostic{{Severity: severityError, Source: "lineage", Message: "syntax error"}},
+		},
+		{
+			name: "no findings",
+			doc: newDocument("file:///mapping.wstl", "x: $root.a\n", &mbp.MappingConfig{RootMapping: []*mbp.FieldMapping{
+				mappingtest.Mapping("x", mappingtest.FromInput(".a")),
+			}}, nil, graph.Options{Tolerant: true}),
+			want: []Diagnostic{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.want, diagnostics(test.doc)); diff != "" {
+				t.Errorf("diagnostics() returned unexpected diagnostics (-want +got):\n%v", diff)
+			}
+		},
+		)
+	}
+}
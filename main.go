@@ -16,85 +16,263 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
-	fileutil "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/util/ioutil"
-	"github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_language/transpiler"
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/batch"
 	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/loader"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/schema"
 	"google.golang.org/protobuf/proto"
 )
 
 var (
-	mappingFile   = flag.String("mapping_file_spec", "", "Mapping file (DHML file).")
-	protobufOut   = flag.String("protobuf_out", "", "Output lineage graph file (textproto file).")
-	pngOut        = flag.String("png_out", "", "Output file path and name for the PNG rendering")
-	dotOut        = flag.String("dot_out", "", "Output file path for the dot text output")
-	writeExamples = flag.Bool("write_examples", false, "Write example files from whistle code in examples/whistle to graphs in examples/graphs")
+	mappingFile      = flag.String("mapping_file_spec", "", "Mapping file (DHML file). Use - to read the mapping from stdin.")
+	protobufOut      = flag.String("protobuf_out", "", "Output lineage graph file (textproto file). Use - to write to stdout.")
+	pngOut           = flag.String("png_out", "", "Output file path and name for the PNG rendering")
+	dotOut           = flag.String("dot_out", "", "Output file path for the dot text output. Use - to write to stdout.")
+	writeExamples    = flag.Bool("write_examples", false, "Write example files from whistle code in examples/whistle to graphs in examples/graphs")
+	libDir           = flag.String("lib_dir_spec", "", "Directory of whistle library files whose projectors the mapping can call.")
+	pluginsSpec      = flag.String("plugins", "", pluginsUsage)
+	watch            = flag.Bool("watch", false, "Watch the mapping file and libraries, and regenerate the outputs whenever they change.")
+	watchInterval    = flag.Duration("watch_interval", 500*time.Millisecond, "How often to check for changes in watch mode. Changes are batched until the files are unchanged for one interval.")
+	summarise        = flag.Bool("summarise", false, "Expand each projector once into a template shared by its calls. The graph is much smaller, but a call's lineage includes the arguments of every call to the projector.")
+	tolerant         = flag.Bool("tolerant", false, "Replace the mappings that can't be added to the graph by 'unknown' nodes and print the problems instead of failing.")
+	pruneUnreachable = flag.Bool("prune_unreachable", false, "Remove the targets whose conditions are always false, and the nodes only feeding them, from the graph.")
+	inputSchemaSpec  = flag.String("input_schema", "", "JSON Schema file, or file or directory of FHIR StructureDefinitions, describing the input. Input fields are typed from it, and unknown fields reported.")
+	inputResource    = flag.String("input_resource_type", "", "Type of the FHIR resource the mapping reads, such as Patient, if -input_schema defines more than one. Its fields are read without the type name, as in $root.gender.")
+	outputSchemaSpec = flag.String("output_schema", "", "JSON Schema file, or file or directory of FHIR StructureDefinitions, describing the output. Output targets are typed from it, and unknown targets reported.")
+	labelsSpec       = flag.String("labels", "", "JSON file of sensitivity labels of input fields, such as {\"labels\": [{\"field\": \"$root.patient.ssn\", \"labels\": [\"PHI\"]}]}. Labelled nodes are coloured in the DOT graph.")
+	labelConditions  = flag.Bool("label_conditions", false, "Also propagate labels from conditions to the targets they guard, as influenced by the label.")
+	labelsOut        = flag.String("labels_out", "", "Output file for the JSON report of the labels of each output field. Use - to write to stdout.")
+	samplesSpec      = flag.String("samples", "", "Comma-separated sample input JSON files or directories of them. Input fields are labelled with their null rate and example values, and conditions with how often they are met.")
+	traceInput       = flag.String("trace_input", "", "JSON input to run the mapping on. The outputs are the instance graph of the run, with the value of each node, instead of the lineage graph.")
+	traceOutput      = flag.String("trace_output", "", "Output file for the JSON output of the mapping run on -trace_input. Use - to write to stdout.")
+	batchInput       = flag.String("batch_input_dir", "", "Directory of whistle files to generate lineage graphs for in batch mode.")
+	batchOutput      = flag.String("batch_output_dir", "", "Directory the batch mode writes the graphs and the summary report to.")
+	batchFormats     = flag.String("batch_formats", "dot,png,pb", "Comma-separated output formats of the batch mode: dot, png and pb.")
+	batchWorkers     = flag.Int("batch_workers", runtime.NumCPU(), "Number of mapping files the batch mode processes concurrently.")
 )
 
+// the schemas loaded from -input_schema and -output_schema, the label rules from -labels, and the
+// sample inputs from -samples, and the plugins from -plugins
+var (
+	inputSchema, outputSchema graph.Schema
+	labelRules                []graph.LabelRule
+	samples                   []interface{}
+	plugins                   []graph.Plugin
+)
+
+// pluginsUsage describes the -plugins flag shared by the subcommands.
+const pluginsUsage = "JSON file of the signatures of the Go projectors registered with the mapping engine that the mappings can call, such as {\"plugins\": [{\"name\": \"LookupCode\", \"numArgs\": 2, \"outputs\": {\"code\": [1]}, \"lookup\": true}]}."
+
 const exampleWhistleDir = "./examples/whistle/"
 const examplePNGdir = "./examples/png/"
 const exampleDotDir = "./examples/dottext/"
+const batchReportFile = "summary.json"
+
+// commands are the subcommands run with 'lineage <command> [flags]'. Without a subcommand, the
+// lineage graph of a single mapping file is generated.
+var commands = map[string]func(args []string) error{
+	"serve":    runServe,
+	"lsp":      runLSP,
+	"lint":     runLint,
+	"coverage": runCoverage,
+	"policy":   runPolicy,
+	"compare":  runCompare,
+	"impact":   runImpact,
+}
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatalf("%v failed:\n%v", os.Args[1], err)
+			}
+			return
+		}
+	}
 	flag.Parse()
+	if err := loadConfigs(); err != nil {
+		log.Fatalf("%v", err)
+	}
 
 	if *writeExamples {
 		if err := writeExampleGraphs(); err != nil {
 			log.Fatalf("failed to write examples:\n%v", err)
 		}
+	} else if *batchInput != "" {
+		if err := runBatch(); err != nil {
+			log.Fatalf("batch processing failed:\n%v", err)
+		}
 	} else {
 		if *mappingFile == "" {
 			log.Fatalf("The whistle mapping file is not provided or is an empty string.\n" +
 				"Please provide file with -mapping_file_spec=/path/to/your-file.wstl")
 		}
 
-		dotString, g, err := makeGraphAndDot(*mappingFile, *pngOut)
+		if *watch {
+			if err := watchMapping(); err != nil {
+				log.Fatalf("watching the mapping failed:\n%v", err)
+			}
+			return
+		}
+
+		if err := generate(); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+}
+
+// generate writes the lineage graph outputs requested by the flags.
+func generate() error {
+	dotString, g, err := makeGraphAndDot(*mappingFile, *pngOut)
+	if err != nil {
+		return fmt.Errorf("creating the graph failed:\n%w", err)
+	}
+
+	if *protobufOut != "" {
+		pbGraph, err := graph.WriteProtobuf(g)
+		if err != nil {
+			return fmt.Errorf("Failed to write graph to protobuf:\n%w", err)
+		}
+
+		out, err := proto.Marshal(pbGraph)
 		if err != nil {
-			log.Fatalf("creating the graph failed:\n%v", err)
+			return fmt.Errorf("Failed to marshal the protobuf graph:\n%w", err)
+		}
+		if err := loader.WriteOutput(*protobufOut, out); err != nil {
+			return fmt.Errorf("Failed to write the graph:\n%w", err)
 		}
+	}
 
-		fmt.Println(dotString)
+	if *dotOut != "" {
+		if err := loader.WriteOutput(*dotOut, []byte(dotString)); err != nil {
+			return fmt.Errorf("Failed to write the dot graph:\n%w", err)
+		}
+	}
 
-		if *protobufOut != "" {
-			pbGraph, err := graph.WriteProtobuf(g)
-			if err != nil {
-				log.Fatalf("Failed to write graph to protobuf:\n%v", err)
-			}
+	if *labelsOut != "" {
+		out, err := json.MarshalIndent(g.OutputLabels(), "", "  ")
+		if err != nil {
+			return fmt.Errorf("Failed to marshal the output labels:\n%w", err)
+		}
+		if err := loader.WriteOutput(*labelsOut, out); err != nil {
+			return fmt.Errorf("Failed to write the output labels:\n%w", err)
+		}
+	}
+	return nil
+}
 
-			out, err := proto.Marshal(pbGraph)
-			if err != nil {
-				log.Fatalf("Failed to marshal the protobuf graph %v:\n%v", pbGraph, err)
-			}
-			if err := ioutil.WriteFile(*protobufOut, out, 0644); err != nil {
-				log.Fatalf("Failed to write the graph:\n%v", err)
-			}
+// watchMapping regenerates the outputs whenever the mapping file or a library file changes.
+// Errors are logged and the mapping is watched until the next change.
+func watchMapping() error {
+	if *mappingFile == loader.StdioSpec {
+		return fmt.Errorf("a mapping read from stdin can't be watched")
+	}
+	lastErr := ""
+	watchedFiles := func() []string {
+		files, err := loader.LibraryFiles(*libDir)
+		if err == nil {
+			lastErr = ""
+		} else if err.Error() != lastErr { // logged when it appears or changes, not on every poll
+			lastErr = err.Error()
+			log.Printf("%v", err)
 		}
+		return append(files, *mappingFile)
+	}
+	rebuild := func(changed []string) {
+		if len(changed) > 0 {
+			log.Printf("%v changed, regenerating", strings.Join(changed, ", "))
+		}
+		if err := generate(); err != nil {
+			log.Printf("%v", err)
+			return
+		}
+		log.Printf("regenerated the lineage of %v", *mappingFile)
+	}
 
-		if *dotOut != "" {
-			if err := ioutil.WriteFile(*dotOut, []byte(dotString), 0644); err != nil {
-				log.Fatalf("Failed to write the dot graph:\n%v", err)
-			}
+	rebuild(nil)
+	w := loader.NewWatcher(*watchInterval, *watchInterval)
+	w.Watch(watchedFiles, rebuild, nil)
+	return nil
+}
+
+// loadConfigs loads the schemas given by -input_schema and -output_schema, the label rules given
+// by -labels, the sample inputs given by -samples, and the plugins given by -plugins.
+func loadConfigs() error {
+	var err error
+	if plugins, err = loader.ReadPlugins(*pluginsSpec); err != nil {
+		return err
+	}
+	if *inputSchemaSpec != "" {
+		if inputSchema, err = schema.LoadInput(*inputSchemaSpec, *inputResource); err != nil {
+			return fmt.Errorf("failed to load the input schema:\n%w", err)
 		}
+	}
+	if *outputSchemaSpec != "" {
+		if outputSchema, err = schema.Load(*outputSchemaSpec); err != nil {
+			return fmt.Errorf("failed to load the output schema:\n%w", err)
+		}
+	}
+	if *labelsSpec != "" {
+		if labelRules, err = loader.ReadLabelRules(*labelsSpec); err != nil {
+			return err
+		}
+	}
+	if *samplesSpec != "" {
+		if samples, err = loader.ReadSamples(*samplesSpec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// graphOptions returns the graph options set by the flags.
+func graphOptions() graph.Options {
+	return graph.Options{
+		Summarise:        *summarise,
+		Tolerant:         *tolerant,
+		PruneUnreachable: *pruneUnreachable,
+		InputSchema:      inputSchema,
+		OutputSchema:     outputSchema,
+		Labels:           labelRules,
+		LabelConditions:  *labelConditions,
+		Samples:          samples,
+		Plugins:          plugins,
 	}
 }
 
 func makeGraphAndDot(mappingFile string, pngOut string) (string, graph.Graph, error) {
-	mpc, err := transpiler.Transpile(string(fileutil.MustRead(mappingFile, "mapping")))
+	whistle, err := loader.ReadMapping(mappingFile)
 	if err != nil {
-		return "", graph.Graph{}, fmt.Errorf("Transpiling whistle failed:\n%w", err)
+		return "", graph.Graph{}, err
 	}
 
-	g, err := graph.New(mpc)
+	libraries, err := loader.LibraryProjectors(*libDir)
 	if err != nil {
-		return "", graph.Graph{}, fmt.Errorf("Graph construction failed:\n%w", err)
+		return "", graph.Graph{}, err
+	}
+
+	var g graph.Graph
+	if *traceInput != "" {
+		g, err = traceMapping(mappingFile, whistle, libraries)
+	} else {
+		g, _, err = loader.FromWhistle(mappingFile, whistle, libraries, graphOptions())
+	}
+	if err != nil {
+		return "", graph.Graph{}, err
+	}
+	for _, diagnostic := range g.Diagnostics {
+		log.Printf("%v", diagnostic)
 	}
 
 	dotString, err := graph.WriteDOTpng(g, pngOut)
@@ -105,32 +283,96 @@ func makeGraphAndDot(mappingFile string, pngOut string) (string, graph.Graph, er
 	return dotString, g, nil
 }
 
+// traceMapping runs the mapping on the -trace_input and returns the instance graph of the run,
+// writing the mapping's output to -trace_output.
+func traceMapping(mappingFile string, whistle []byte, libraries []*mbp.ProjectorDefinition) (graph.Graph, error) {
+	input, err := loader.ReadJSON(*traceInput)
+	if err != nil {
+		return graph.Graph{}, fmt.Errorf("failed to read the trace input:\n%w", err)
+	}
+	g, output, err := loader.TraceWhistle(mappingFile, whistle, libraries, input, graphOptions())
+	if err != nil {
+		return graph.Graph{}, err
+	}
+	if *traceOutput != "" {
+		out, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			return graph.Graph{}, fmt.Errorf("Failed to marshal the mapping output:\n%w", err)
+		}
+		if err := loader.WriteOutput(*traceOutput, out); err != nil {
+			return graph.Graph{}, fmt.Errorf("Failed to write the mapping output:\n%w", err)
+		}
+	}
+	return g, nil
+}
+
 func writeExampleGraphs() error {
-	var whistleFiles []string
-	var pngFiles []string
-	var dotFiles []string
-	err := filepath.Walk(exampleWhistleDir, func(path string, info os.FileInfo, err error) error {
-		if filepath.Ext(path) == ".wstl" {
-			whistleFiles = append(whistleFiles, path)
-			name := strings.TrimSuffix(info.Name(), ".wstl")
-			pngFiles = append(pngFiles, examplePNGdir+name+".png")
-			dotFiles = append(dotFiles, exampleDotDir+name+".dot")
-		}
-		return nil
+	report, err := batch.Run(batch.Options{
+		InputDir: exampleWhistleDir,
+		DOTDir:   exampleDotDir,
+		PNGDir:   examplePNGdir,
+		Workers:  runtime.NumCPU(),
 	})
 	if err != nil {
-		return fmt.Errorf("failed to find example whistle files:\n:%w", err)
+		return err
 	}
+	for _, result := range report.Results {
+		if result.Error != "" {
+			return fmt.Errorf("failed to make graph for file %v:\n%v", result.File, result.Error)
+		}
+	}
+	return nil
+}
 
-	for i := range whistleFiles {
-		fmt.Printf("process file %v\n", whistleFiles[i])
-		dotString, _, err := makeGraphAndDot(whistleFiles[i], pngFiles[i])
-		if err != nil {
-			return fmt.Errorf("failed to make graph for file %v:\n%w", whistleFiles[i], err)
+// runBatch generates the lineage graphs of every whistle file in the batch input directory and
+// writes a summary report. It fails if any file failed, after processing all of them.
+func runBatch() error {
+	if *batchOutput == "" {
+		return fmt.Errorf("the batch output directory is not provided. Please provide it with -batch_output_dir=/path/to/dir")
+	}
+	opts := batch.Options{
+		InputDir:     *batchInput,
+		Workers:      *batchWorkers,
+		GraphOptions: graphOptions(),
+	}
+	for _, format := range strings.Split(*batchFormats, ",") {
+		switch strings.TrimSpace(format) {
+		case "dot":
+			opts.DOTDir = *batchOutput
+		case "png":
+			opts.PNGDir = *batchOutput
+		case "pb":
+			opts.ProtobufDir = *batchOutput
+		case "":
+		default:
+			return fmt.Errorf("unknown batch output format %q", format)
 		}
-		if err := ioutil.WriteFile(dotFiles[i], []byte(dotString), 0644); err != nil {
-			return fmt.Errorf("failed to write dot text for file %v:\n%w", whistleFiles[i], err)
+	}
+	libraries, err := loader.LibraryProjectors(*libDir)
+	if err != nil {
+		return err
+	}
+	opts.Libraries = libraries
+
+	report, err := batch.Run(opts)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*batchOutput, 0755); err != nil {
+		return fmt.Errorf("failed to create the batch output directory:\n%w", err)
+	}
+	reportFile := filepath.Join(*batchOutput, batchReportFile)
+	if err := batch.WriteReport(report, reportFile); err != nil {
+		return fmt.Errorf("failed to write the batch report:\n%w", err)
+	}
+	for _, result := range report.Results {
+		if result.Error != "" {
+			log.Printf("%v failed:\n%v", result.File, result.Error)
 		}
 	}
+	fmt.Printf("%v succeeded, %v failed; see %v\n", report.Succeeded, report.Failed, reportFile)
+	if report.Failed > 0 {
+		return fmt.Errorf("%v of %v files failed", report.Failed, len(report.Results))
+	}
 	return nil
 }
@@ -0,0 +1,94 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/loader"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/policy"
+)
+
+// runPolicy checks the mapping files given as arguments against a policy file and writes the
+// violations. It fails if there are any, after writing them.
+func runPolicy(args []string) error {
+	flags := flag.NewFlagSet("policy", flag.ExitOnError)
+	configFile := flags.String("config", "", "JSON policy file of input field labels and the projectors their flows must pass through.")
+	format := flags.String("format", "text", "Output format of the violations: text or json.")
+	out := flags.String("out", loader.StdioSpec, "File to write the violations to. Use - to write to stdout.")
+	libDir := flags.String("lib_dir_spec", "", "Directory of whistle library files whose projectors the mappings can call.")
+	pluginsSpec := flags.String("plugins", "", pluginsUsage)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v policy -config=policy.json [flags] mapping.wstl...\n", flag.CommandLine.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 || *configFile == "" {
+		flags.Usage()
+		return fmt.Errorf("no policy file or no mapping files were given")
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown output format %v; expected text or json", *format)
+	}
+	config, err := policy.ReadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	plugins, err := loader.ReadPlugins(*pluginsSpec)
+	if err != nil {
+		return err
+	}
+	libraries, err := loader.LibraryProjectors(*libDir)
+	if err != nil {
+		return err
+	}
+
+	violations := []policy.Violation{}
+	for _, file := range flags.Args() {
+		whistle, err := loader.ReadMapping(file)
+		if err != nil {
+			return err
+		}
+		g, _, err := loader.FromWhistle(file, whistle, libraries, graph.Options{Plugins: plugins})
+		if err != nil {
+			return fmt.Errorf("failed to build the lineage graph of %v:\n%w", file, err)
+		}
+		violations = append(violations, policy.Check(g, config)...)
+	}
+
+	var report []byte
+	if *format == "json" {
+		if report, err = json.MarshalIndent(violations, "", "  "); err != nil {
+			return fmt.Errorf("failed to marshal the violations:\n%w", err)
+		}
+	} else {
+		lines := []string{}
+		for _, v := range violations {
+			lines = append(lines, v.String()+"\n")
+		}
+		report = []byte(strings.Join(lines, ""))
+	}
+	if err := loader.WriteOutput(*out, report); err != nil {
+		return fmt.Errorf("failed to write the violations:\n%w", err)
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("found %v policy violations", len(violations))
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package policy checks de-identification policies over lineage graphs: that every flow from a
+// sensitive input field to an output passes through an approved projector.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+)
+
+// Config is a policy file, such as
+//
+//	{
+//	  "labels": [{"field": "$root.patient.ssn", "labels": ["SSN"]}],
+//	  "policies": [
+//	    {"name": "hash-ssn", "from": "SSN", "through": ["$Hash", "RedactSSN"]},
+//	    {"name": "shift-dates", "from": "$root.patient.birthDate", "through": ["ShiftDate"]}
+//	  ]
+//	}
+type Config struct {
+	Labels   []graph.LabelRule `json:"labels"`
+	Policies []Policy          `json:"policies"`
+}
+
+// Policy requires every flow from the input fields From to an output to pass through one of the
+// projectors Through. From is a label of the config's label rules, or a $root field pattern as
+// matched by graph.MatchField.
+type Policy struct {
+	Name    string   `json:"name"`
+	From    string   `json:"from"`
+	Through []string `json:"through"`
+}
+
+// ReadConfig reads and validates a policy file.
+func ReadConfig(path string) (Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read the policy file %v:\n%w", path, err)
+	}
+	var config Config
+	if err := json.Unmarshal(content, &config); err != nil {
+		return Config{}, fmt.Errorf("failed to parse the policy file %v:\n%w", path, err)
+	}
+	if len(config.Policies) == 0 {
+		return Config{}, fmt.Errorf("the policy file %v has no policies", path)
+	}
+	for _, p := range config.Policies {
+		if p.Name == "" || p.From == "" || len(p.Through) == 0 {
+			return Config{}, fmt.Errorf("the policy %q in %v needs a name, a from field or label, and projectors to pass through", p.Name, path)
+		}
+	}
+	return config, nil
+}
+
+// Step is a node on a violating path.
+type Step struct {
+	Node     string             `json:"node"`
+	Context  string             `json:"context,omitempty"`
+	Position graph.FileMetaData `json:"position"`
+}
+
+func (s Step) String() string {
+	return fmt.Sprintf("%v%v", s.Node, position(s.Position, " (", ")"))
+}
+
+// Violation is a flow from a sensitive input field to an output that bypasses the policy's
+// projectors. Path runs from the input field to the output target.
+type Violation struct {
+	Policy  string `json:"policy"`
+	Message string `json:"message"`
+	Source  string `json:"source"`
+	Output  string `json:"output"`
+	Path    []Step `json:"path"`
+}
+
+func (v Violation) String() string {
+	lines := []string{fmt.Sprintf("%vpolicy %v: %v", position(v.Path[0].Position, "", ": "), v.Policy, v.Message)}
+	for i, step := range v.Path {
+		arrow := "    "
+		if i > 0 {
+			arrow = "    -> "
+		}
+		lines = append(lines, arrow+step.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func position(data graph.FileMetaData, prefix, suffix string) string {
+	if data.LineStart == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%v%v:%v:%v%v", prefix, data.FileName, data.LineStart, data.CharStart, suffix)
+}
+
+// Check returns the violations of the config's policies in a graph, with the shortest violating
+// path from each input field to each output, named by the most specific output field on the path.
+// A flow passes through a projector if it reaches its node or flows within its body. Values flow as given by graph.DataFlows, so
+// conditions don't carry sensitive data.
+func Check(g graph.Graph, config Config) []Violation {
+	outputs := map[int]bool{}
+	for _, ids := range g.Outputs() {
+		for _, id := range ids {
+			outputs[id] = true
+		}
+	}
+	outputPaths := map[int]string{}
+	for _, path := range g.OutputPaths() {
+		outputPaths[path.ID] = strings.Join(path.Path, ".")
+	}
+	flows := g.DataFlows()
+	inputPaths := g.InputPaths()
+
+	violations := []Violation{}
+	seen := map[string]bool{}
+	for _, p := range config.Policies {
+		approved := map[string]bool{}
+		for _, name := range p.Through {
+			approved[name] = true
+		}
+		for _, input := range inputPaths {
+			if !matches(p, config.Labels, input.Path) || isApproved(g.Nodes[input.ID], approved) {
+				continue
+			}
+			source := "$root." + strings.Join(input.Path, ".")
+			for _, path := range unapprovedPaths(g, flows, input.ID, outputs, approved) {
+				output := ""
+				for _, id := range path {
+					if output = outputPaths[id]; output != "" {
+						break
+					}
+				}
+				key := strings.Join([]string{p.Name, source, output}, "\x00")
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				steps := []Step{}
+				for _, id := range path {
+					steps = append(steps, Step{Node: graph.Describe(g.Nodes[id]), Context: graph.Context(g.Nodes[id]), Position: graph.FileData(g.Nodes[id])})
+				}
+				violations = append(violations, Violation{
+					Policy:  p.Name,
+					Message: fmt.Sprintf("%v derives from %v without passing through %v", output, source, strings.Join(p.Through, " or ")),
+					Source:  source,
+					Output:  output,
+					Path:    steps,
+				})
+			}
+		}
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		if violations[i].Policy != violations[j].Policy {
+			return violations[i].Policy < violations[j].Policy
+		}
+		if violations[i].Output != violations[j].Output {
+			return violations[i].Output < violations[j].Output
+		}
+		return violations[i].Source < violations[j].Source
+	})
+	return violations
+}
+
+// matches returns whether a policy applies to an input path, by its field pattern or by a label
+// of the label rules.
+func matches(p Policy, rules []graph.LabelRule, path []string) bool {
+	if p.From == "$root" || strings.HasPrefix(p.From, "$root.") {
+		return graph.MatchField(p.From, path)
+	}
+	for _, rule := range rules {
+		for _, label := range rule.Labels {
+			if label == p.From && graph.MatchField(rule.Field, path) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isApproved returns whether a node is one of the approved projectors or in the body of one.
+func isApproved(node graph.Node, approved map[string]bool) bool {
+	if p, ok := node.(*graph.ProjectorNode); ok && approved[p.Name] {
+		return true
+	}
+	return approved[graph.Context(node)]
+}
+
+// unapprovedPaths does a breadth-first search from the source, without entering approved nodes,
+// and returns the path to each output reached.
+func unapprovedPaths(g graph.Graph, flows map[int][]int, source int, outputs map[int]bool, approved map[string]bool) [][]int {
+	parents := map[int]int{source: source}
+	paths := [][]int{}
+	queue := []int{source}
+	for len(queue) > 0 {
+		var id int
+		id, queue = queue[0], queue[1:]
+		if outputs[id] {
+			path := []int{id}
+			for id != source {
+				id = parents[id]
+				path = append([]int{id}, path...)
+			}
+			paths = append(paths, path)
+			continue
+		}
+		for _, next := range flows[id] {
+			if _, ok := parents[next]; ok || isApproved(g.Nodes[next], approved) {
+				continue
+			}
+			parents[next] = id
+			queue = append(queue, next)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,87 @@
+package policy
+
+import (
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/internal/mappingtest"
+)
+
+func TestCheck(t *testing.T) {
+	config := Config{
+		Labels: []graph.LabelRule{{Field: "$root.patient.ssn", Labels: []string{"SSN"}}},
+		Policies: []Policy{
+			{Name: "hash-ssn", From: "SSN", Through: []string{"$Hash", "RedactSSN"}},
+			{Name: "shift-dates", From: "$root.patient.birthDate", Through: []string{"ShiftDate"}},
+		},
+	}
+	tests := []struct {
+		name     string
+		patient  []*mbp.FieldMapping
+		wantPath map[string][]string
+	}{
+		{
+			name: "hashed",
+			patient: []*mbp.FieldMapping{
+				mappingtest.Mapping("identifier", mappingtest.Call("$Hash", mappingtest.FromInput(".ssn"))),
+				mappingtest.Mapping("birthDate", mappingtest.Call("ShiftDate", mappingtest.FromInput(".birthDate"))),
+			},
+			wantPath: map[string][]string{},
+		},
+		{
+			name: "redacted by a projector of the mapping",
+			patient: []*mbp.FieldMapping{
+				mappingtest.Mapping("identifier", mappingtest.Call("RedactSSN", mappingtest.FromInput(".ssn"))),
+			},
+			wantPath: map[string][]string{},
+		},
+		{
+			name: "copied",
+			patient: []*mbp.FieldMapping{
+				mappingtest.Mapping("identifier", mappingtest.FromInput(".ssn")),
+				mappingtest.Mapping("birthDate", mappingtest.FromInput(".birthDate")),
+			},
+			wantPath: map[string][]string{
+				"Patient.identifier": {"argument 1.ssn of PatientP", "target identifier", "projector PatientP", "target Patient"},
+				"Patient.birthDate":  {"argument 1.birthDate of PatientP", "target birthDate", "projector PatientP", "target Patient"},
+			},
+		},
+		{
+			name: "through an unapproved builtin",
+			patient: []*mbp.FieldMapping{
+				mappingtest.Mapping("identifier", mappingtest.Call("$Concat", mappingtest.FromInput(".ssn"))),
+			},
+			wantPath: map[string][]string{
+				"Patient.identifier": {"argument 1.ssn of PatientP", "projector $Concat", "target identifier", "projector PatientP", "target Patient"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mpc := &mbp.MappingConfig{
+				Projector: []*mbp.ProjectorDefinition{
+					{Name: "PatientP", Mapping: test.patient},
+					{Name: "RedactSSN", Mapping: []*mbp.FieldMapping{mappingtest.Mapping("last4", mappingtest.Call("$Concat", mappingtest.FromInput("")))}},
+					{Name: "ShiftDate", Mapping: []*mbp.FieldMapping{mappingtest.Mapping("date", mappingtest.FromInput(""))}},
+				},
+				RootMapping: []*mbp.FieldMapping{mappingtest.Mapping("Patient", mappingtest.Call("PatientP", mappingtest.FromInput(".patient")))},
+			}
+			g, err := graph.New(mpc)
+			if err != nil {
+				t.Fatalf("building the graph failed:\n%v", err)
+			}
+			got := map[string][]string{}
+			for _, v := range Check(g, config) {
+				for _, step := range v.Path {
+					got[v.Output] = append(got[v.Output], step.Node)
+				}
+			}
+			if diff := cmp.Diff(test.wantPath, got); diff != "" {
+				t.Errorf("unexpected violating paths (-want +got):\n%v", diff)
+			}
+		},
+		)
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+)
+
+// FHIR is a set of FHIR StructureDefinitions. The first field of a path is the name of a resource
+// or data type, as in "Patient.name.family"; the remaining fields follow the elements of its
+// definition, into the definitions of the elements' types.
+type FHIR struct {
+	// types maps a type name to its elements, by path.
+	types map[string]map[string]fhirElement
+	// resources are the names of the types defining resources.
+	resources map[string]bool
+}
+
+type fhirElement struct {
+	Path string     `json:"path"`
+	Min  int        `json:"min"`
+	Max  string     `json:"max"`
+	Type []fhirType `json:"type"`
+}
+
+type fhirType struct {
+	Code string `json:"code"`
+}
+
+type fhirResource struct {
+	ResourceType string `json:"resourceType"`
+	Type         string `json:"type"`
+	Kind         string `json:"kind"`
+	Snapshot     struct {
+		Element []fhirElement `json:"element"`
+	} `json:"snapshot"`
+	Differential struct {
+		Element []fhirElement `json:"element"`
+	} `json:"differential"`
+	Entry []struct {
+		Resource json.RawMessage `json:"resource"`
+	} `json:"entry"`
+}
+
+func newFHIR() *FHIR {
+	return &FHIR{types: map[string]map[string]fhirElement{}, resources: map[string]bool{}}
+}
+
+// ParseFHIR parses FHIR StructureDefinitions, or Bundles of them.
+func ParseFHIR(contents ...[]byte) (*FHIR, error) {
+	f := newFHIR()
+	for _, content := range contents {
+		if err := f.add(content); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// add adds a StructureDefinition, or the StructureDefinitions in a Bundle. Other resources are
+// ignored.
+func (f *FHIR) add(content []byte) error {
+	var resource fhirResource
+	if err := json.Unmarshal(content, &resource); err != nil {
+		return fmt.Errorf("failed to parse the FHIR resource:\n%w", err)
+	}
+	switch resource.ResourceType {
+	case "Bundle":
+		for _, entry := range resource.Entry {
+			if len(entry.Resource) == 0 {
+				continue
+			}
+			if err := f.add(entry.Resource); err != nil {
+				return err
+			}
+		}
+	case "StructureDefinition":
+		elements := resource.Snapshot.Element
+		if len(elements) == 0 {
+			elements = resource.Differential.Element
+		}
+		if resource.Type == "" || len(elements) == 0 {
+			return fmt.Errorf("the StructureDefinition has no type or no elements")
+		}
+		byPath := map[string]fhirElement{}
+		for _, element := range elements {
+			byPath[element.Path] = element
+		}
+		f.types[resource.Type] = byPath
+		if resource.Kind == "resource" {
+			f.resources[resource.Type] = true
+		}
+	}
+	return nil
+}
+
+// Lookup returns the element at the path, whose first field is a type name. The path of the type
+// name alone, such as a root "Patient" output target, is the type itself.
+func (f *FHIR) Lookup(path []string) (graph.SchemaElement, bool) {
+	if len(path) == 0 {
+		return graph.SchemaElement{}, false
+	}
+	if len(path) == 1 {
+		byPath, ok := f.types[path[0]]
+		if !ok {
+			return graph.SchemaElement{}, false
+		}
+		root := byPath[path[0]]
+		return graph.SchemaElement{Path: path[0], Type: path[0], Min: root.Min, Max: root.Max}, true
+	}
+	if path[1] == "resourceType" && len(path) == 2 {
+		if _, ok := f.types[path[0]]; ok {
+			return graph.SchemaElement{Path: join(path), Type: "code", Min: 1, Max: "1"}, true
+		}
+	}
+	element, ok := f.element(path[0], path[1:], 0)
+	if !ok {
+		return graph.SchemaElement{}, false
+	}
+	return schemaElement(join(path), element), true
+}
+
+// Elements returns the elements of the resources defined, or of every type if none defines a
+// resource, in path order. The elements of the data types the resources use aren't repeated under
+// each resource, and choices of types are listed once, by their path such as Observation.value[x].
+func (f *FHIR) Elements() []graph.SchemaElement {
+	elements := []graph.SchemaElement{}
+	for typeName, byPath := range f.types {
+		if len(f.resources) > 0 && !f.resources[typeName] {
+			continue
+		}
+		for path, element := range byPath {
+			if !strings.Contains(path, ".") {
+				continue // the element describing the type itself
+			}
+			elements = append(elements, schemaElement(path, element))
+		}
+	}
+	sort.Slice(elements, func(i, j int) bool { return elements[i].Path < elements[j].Path })
+	return elements
+}
+
+// Resource returns the schema of a single resource of the named type, such as the input of a
+// mapping, whose paths leave out the type name: "gender" rather than "Patient.gender".
+func (f *FHIR) Resource(typeName string) (Schema, error) {
+	if _, ok := f.types[typeName]; !ok {
+		return nil, fmt.Errorf("no StructureDefinition defines %v", typeName)
+	}
+	return &fhirResourceSchema{fhir: f, typeName: typeName}, nil
+}
+
+// singleType returns the only resource defined, or the only type if none defines a resource.
+func (f *FHIR) singleType() (string, bool) {
+	candidates := f.resources
+	if len(candidates) == 0 {
+		candidates = map[string]bool{}
+		for typeName := range f.types {
+			candidates[typeName] = true
+		}
+	}
+	if len(candidates) != 1 {
+		return "", false
+	}
+	for typeName := range candidates {
+		return typeName, true
+	}
+	return "", false
+}
+
+// fhirResourceSchema is the schema of a resource of one type, with paths relative to the resource.
+type fhirResourceSchema struct {
+	fhir     *FHIR
+	typeName string
+}
+
+func (r *fhirResourceSchema) Lookup(path []string) (graph.SchemaElement, bool) {
+	if len(path) == 0 {
+		return graph.SchemaElement{}, false
+	}
+	element, ok := r.fhir.Lookup(append([]string{r.typeName}, path...))
+	element.Path = join(path)
+	return element, ok
+}
+
+func (r *fhirResourceSchema) Elements() []graph.SchemaElement {
+	elements := []graph.SchemaElement{}
+	for path, element := range r.fhir.types[r.typeName] {
+		if rel := strings.TrimPrefix(path, r.typeName+"."); rel != path {
+			elements = append(elements, schemaElement(rel, element))
+		}
+	}
+	sort.Slice(elements, func(i, j int) bool { return elements[i].Path < elements[j].Path })
+	return elements
+}
+
+func schemaElement(path string, element fhirElement) graph.SchemaElement {
+	types := []string{}
+	for _, t := range element.Type {
+		types = append(types, t.Code)
+	}
+	return graph.SchemaElement{Path: path, Type: strings.Join(types, "|"), Min: element.Min, Max: element.Max}
+}
+
+// element finds the element at the fields below a type. Fields of a BackboneElement or Element are
+// defined in the same definition; fields of other types in the definition of that type.
+func (f *FHIR) element(typeName string, fields []string, depth int) (fhirElement, bool) {
+	elements, ok := f.types[typeName]
+	if !ok || depth > maxDepth {
+		return fhirElement{}, false
+	}
+	prefix := typeName
+	for i, field := range fields {
+		element, ok := choice(elements, prefix, field)
+		if !ok {
+			return fhirElement{}, false
+		}
+		if i == len(fields)-1 {
+			return element, true
+		}
+		prefix = element.Path
+		if len(element.Type) != 1 {
+			continue
+		}
+		if code := element.Type[0].Code; code != "BackboneElement" && code != "Element" {
+			if _, ok := elements[prefix+"."+fields[i+1]]; !ok {
+				return f.element(code, fields[i+1:], depth+1)
+			}
+		}
+	}
+	return fhirElement{}, false
+}
+
+// choice finds a field among the elements, matching a choice of types such as value[x] by the
+// field named after one of the types, such as valueString.
+func choice(elements map[string]fhirElement, prefix, field string) (fhirElement, bool) {
+	if element, ok := elements[prefix+"."+field]; ok {
+		return element, true
+	}
+	for path, element := range elements {
+		if !strings.HasPrefix(path, prefix+".") || !strings.HasSuffix(path, "[x]") {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(path, prefix+"."), "[x]")
+		if strings.Contains(name, ".") || !strings.HasPrefix(field, name) {
+			continue
+		}
+		for _, t := range element.Type {
+			if strings.EqualFold(field[len(name):], t.Code) {
+				element.Type = []fhirType{t}
+				return element, true
+			}
+		}
+	}
+	return fhirElement{}, false
+}
@@ -0,0 +1,243 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+)
+
+// JSONSchema is a JSON Schema describing a whole input or output document. Local references to
+// "definitions" and "$defs" are followed; the properties of "allOf", "anyOf" and "oneOf" members
+// are all considered.
+type JSONSchema struct {
+	root *jsonSchemaNode
+}
+
+type jsonSchemaNode struct {
+	Type        interface{}                `json:"type"`
+	Ref         string                     `json:"$ref"`
+	Properties  map[string]*jsonSchemaNode `json:"properties"`
+	Items       *jsonSchemaNode            `json:"items"`
+	Required    []string                   `json:"required"`
+	AllOf       []*jsonSchemaNode          `json:"allOf"`
+	AnyOf       []*jsonSchemaNode          `json:"anyOf"`
+	OneOf       []*jsonSchemaNode          `json:"oneOf"`
+	Definitions map[string]*jsonSchemaNode `json:"definitions"`
+	Defs        map[string]*jsonSchemaNode `json:"$defs"`
+}
+
+// ParseJSONSchema parses a JSON Schema document.
+func ParseJSONSchema(content []byte) (*JSONSchema, error) {
+	root := &jsonSchemaNode{}
+	if err := json.Unmarshal(content, root); err != nil {
+		return nil, fmt.Errorf("failed to parse the JSON Schema:\n%w", err)
+	}
+	return &JSONSchema{root: root}, nil
+}
+
+// Lookup returns the property at the path. Arrays are stepped through, so the path of an array's
+// items' property is the same as if it weren't an array.
+func (s *JSONSchema) Lookup(path []string) (graph.SchemaElement, bool) {
+	node := s.resolve(s.root, 0)
+	var prop *jsonSchemaNode
+	required := false
+	for i, field := range path {
+		if i > 0 {
+			node = s.items(prop)
+		}
+		if prop, required = s.property(node, field, 0); prop == nil {
+			return graph.SchemaElement{}, false
+		}
+	}
+	if prop == nil {
+		return graph.SchemaElement{}, false
+	}
+	resolved := s.resolve(prop, 0)
+	repeated := resolved != nil && typeName(resolved) == "array"
+	typ := s.name(prop)
+	if repeated && resolved.Items != nil {
+		typ = s.name(resolved.Items)
+	}
+	min := 0
+	if required {
+		min = 1
+	}
+	return graph.SchemaElement{Path: join(path), Type: typ, Min: min, Max: cardinality(repeated)}, true
+}
+
+// Elements returns every property of the schema, in path order. The properties of a definition
+// referring to itself are listed down to the first repetition.
+func (s *JSONSchema) Elements() []graph.SchemaElement {
+	elements := []graph.SchemaElement{}
+	var visit func(node *jsonSchemaNode, parent []string, refs map[string]bool)
+	visit = func(node *jsonSchemaNode, parent []string, refs map[string]bool) {
+		if len(parent) > maxDepth {
+			return
+		}
+		for _, field := range s.propertyNames(node, 0) {
+			path := append(append([]string{}, parent...), field)
+			element, ok := s.Lookup(path)
+			if !ok {
+				continue
+			}
+			elements = append(elements, element)
+			prop, _ := s.property(node, field, 0)
+			if ref := s.itemsRef(prop); ref != "" {
+				if refs[ref] {
+					continue
+				}
+				refs[ref] = true
+				visit(s.items(prop), path, refs)
+				delete(refs, ref)
+				continue
+			}
+			visit(s.items(prop), path, refs)
+		}
+	}
+	visit(s.resolve(s.root, 0), nil, map[string]bool{})
+	sort.Slice(elements, func(i, j int) bool { return elements[i].Path < elements[j].Path })
+	return elements
+}
+
+// propertyNames returns the names of the properties of a node, including its members'.
+func (s *JSONSchema) propertyNames(node *jsonSchemaNode, depth int) []string {
+	node = s.resolve(node, depth)
+	if node == nil || depth > maxDepth {
+		return nil
+	}
+	names := []string{}
+	for name := range node.Properties {
+		names = append(names, name)
+	}
+	for _, members := range [][]*jsonSchemaNode{node.AllOf, node.AnyOf, node.OneOf} {
+		for _, member := range members {
+			names = append(names, s.propertyNames(member, depth+1)...)
+		}
+	}
+	sort.Strings(names)
+	unique := []string{}
+	for i, name := range names {
+		if i == 0 || name != names[i-1] {
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
+
+// itemsRef returns the reference a property, or its array items, are described by.
+func (s *JSONSchema) itemsRef(prop *jsonSchemaNode) string {
+	for node := prop; node != nil; node = node.Items {
+		if node.Ref != "" {
+			return node.Ref
+		}
+	}
+	return ""
+}
+
+// maxDepth bounds the references followed, in case they are circular.
+const maxDepth = 32
+
+// resolve follows the node's reference, if it has one.
+func (s *JSONSchema) resolve(node *jsonSchemaNode, depth int) *jsonSchemaNode {
+	for node != nil && node.Ref != "" && depth < maxDepth {
+		node = s.definition(node.Ref)
+		depth++
+	}
+	return node
+}
+
+func (s *JSONSchema) definition(ref string) *jsonSchemaNode {
+	for _, prefix := range []string{"#/definitions/", "#/$defs/"} {
+		if strings.HasPrefix(ref, prefix) {
+			name := strings.TrimPrefix(ref, prefix)
+			if def, ok := s.root.Definitions[name]; ok {
+				return def
+			}
+			return s.root.Defs[name]
+		}
+	}
+	if ref == "#" {
+		return s.root
+	}
+	return nil
+}
+
+// items returns the node an array property's items are described by, or the property itself.
+func (s *JSONSchema) items(prop *jsonSchemaNode) *jsonSchemaNode {
+	node := s.resolve(prop, 0)
+	for node != nil && node.Items != nil {
+		node = s.resolve(node.Items, 0)
+	}
+	return node
+}
+
+// property finds a property of the node, and whether it is required.
+func (s *JSONSchema) property(node *jsonSchemaNode, field string, depth int) (*jsonSchemaNode, bool) {
+	node = s.resolve(node, depth)
+	if node == nil || depth > maxDepth {
+		return nil, false
+	}
+	if prop, ok := node.Properties[field]; ok {
+		for _, name := range node.Required {
+			if name == field {
+				return prop, true
+			}
+		}
+		return prop, false
+	}
+	for _, members := range [][]*jsonSchemaNode{node.AllOf, node.AnyOf, node.OneOf} {
+		for _, member := range members {
+			if prop, required := s.property(member, field, depth+1); prop != nil {
+				return prop, required
+			}
+		}
+	}
+	return nil, false
+}
+
+// name is the type of a node: the definition it refers to, or its JSON type.
+func (s *JSONSchema) name(node *jsonSchemaNode) string {
+	if node.Ref != "" {
+		return node.Ref[strings.LastIndex(node.Ref, "/")+1:]
+	}
+	if typ := typeName(node); typ != "" {
+		return typ
+	}
+	return "any"
+}
+
+// typeName returns the JSON type of a node, joining the types of a node allowing several.
+func typeName(node *jsonSchemaNode) string {
+	switch t := node.Type.(type) {
+	case string:
+		return t
+	case []interface{}:
+		types := []string{}
+		for _, typ := range t {
+			types = append(types, fmt.Sprintf("%v", typ))
+		}
+		return strings.Join(types, "|")
+	default:
+		if len(node.Properties) > 0 {
+			return "object"
+		}
+		return ""
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package schema loads JSON Schemas and FHIR StructureDefinitions from local files, to type the
+// input fields and output targets of a lineage graph.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+)
+
+// Schema is a loaded schema, whose elements can be looked up by path or listed.
+type Schema interface {
+	graph.Schema
+	// Elements returns the elements of the schema, in path order.
+	Elements() []graph.SchemaElement
+}
+
+// Load reads a schema from a JSON file, or from every JSON file in a directory. The files are
+// either one JSON Schema, or FHIR StructureDefinitions, alone or in Bundles, which together
+// describe the resources and data types they define.
+func Load(path string) (Schema, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the schema %v:\n%w", path, err)
+	}
+	files := []string{path}
+	if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+			return nil, fmt.Errorf("failed to list the schema files in %v:\n%w", path, err)
+		}
+		sort.Strings(files)
+	}
+
+	fhir := newFHIR()
+	var jsonSchema *JSONSchema
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the schema %v:\n%w", file, err)
+		}
+		var header struct {
+			ResourceType string `json:"resourceType"`
+		}
+		if err := json.Unmarshal(content, &header); err != nil {
+			return nil, fmt.Errorf("failed to parse the schema %v:\n%w", file, err)
+		}
+		if header.ResourceType != "" {
+			if err := fhir.add(content); err != nil {
+				return nil, fmt.Errorf("failed to load the FHIR definitions in %v:\n%w", file, err)
+			}
+			continue
+		}
+		if jsonSchema != nil {
+			return nil, fmt.Errorf("%v is a second JSON Schema; only one can be given", file)
+		}
+		if jsonSchema, err = ParseJSONSchema(content); err != nil {
+			return nil, fmt.Errorf("failed to load the JSON Schema %v:\n%w", file, err)
+		}
+	}
+	switch {
+	case jsonSchema != nil && len(fhir.types) > 0:
+		return nil, fmt.Errorf("%v mixes a JSON Schema with FHIR definitions", path)
+	case jsonSchema != nil:
+		return jsonSchema, nil
+	case len(fhir.types) > 0:
+		return fhir, nil
+	default:
+		return nil, fmt.Errorf("no schema was found in %v", path)
+	}
+}
+
+// LoadInput loads the schema of the input of a mapping, like Load. A mapping written against FHIR
+// definitions reads a single resource, whose fields it reads without the type name, as in
+// $root.gender of a Patient. The resource is of the given type or, if it is empty, of the only
+// resource the definitions define.
+func LoadInput(path string, resourceType string) (Schema, error) {
+	s, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	fhir, ok := s.(*FHIR)
+	if !ok {
+		if resourceType != "" {
+			return nil, fmt.Errorf("a resource type was given for %v, which isn't a set of FHIR definitions", path)
+		}
+		return s, nil
+	}
+	if resourceType == "" {
+		if resourceType, ok = fhir.singleType(); !ok {
+			return nil, fmt.Errorf("%v defines several resources; give the resource type of the input", path)
+		}
+	}
+	return fhir.Resource(resourceType)
+}
+
+// cardinality returns the maximum cardinality of an element: "*" if it repeats, and "1" otherwise.
+func cardinality(repeated bool) string {
+	if repeated {
+		return "*"
+	}
+	return "1"
+}
+
+func join(path []string) string {
+	return strings.Join(path, ".")
+}
@@ -0,0 +1,320 @@
+package schema
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/internal/mappingtest"
+)
+
+const jsonSchema = `{
+  "type": "object",
+  "required": ["id"],
+  "properties": {
+    "id": {"type": "string"},
+    "names": {"type": "array", "items": {"$ref": "#/definitions/Name"}},
+    "address": {"allOf": [{"$ref": "#/$defs/Address"}]}
+  },
+  "definitions": {
+    "Name": {"type": "object", "required": ["family"], "properties": {"family": {"type": "string"}}}
+  },
+  "$defs": {
+    "Address": {"type": "object", "properties": {"postcode": {"type": ["string", "null"]}}}
+  }
+}`
+
+func TestJSONSchemaLookup(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		want   graph.SchemaElement
+		wantOK bool
+	}{
+		{
+			name:   "required property",
+			path:   "id",
+			want:   graph.SchemaElement{Path: "id", Type: "string", Min: 1, Max: "1"},
+			wantOK: true,
+		},
+		{
+			name:   "array of references",
+			path:   "names",
+			want:   graph.SchemaElement{Path: "names", Type: "Name", Min: 0, Max: "*"},
+			wantOK: true,
+		},
+		{
+			name:   "property of array items",
+			path:   "names.family",
+			want:   graph.SchemaElement{Path: "names.family", Type: "string", Min: 1, Max: "1"},
+			wantOK: true,
+		},
+		{
+			name:   "property of allOf member",
+			path:   "address.postcode",
+			want:   graph.SchemaElement{Path: "address.postcode", Type: "string|null", Min: 0, Max: "1"},
+			wantOK: true,
+		},
+		{
+			name:   "unknown property",
+			path:   "names.famly",
+			wantOK: false,
+		},
+	}
+	s, err := ParseJSONSchema([]byte(jsonSchema))
+	if err != nil {
+		t.Fatalf("parsing the schema failed:\n%v", err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := s.Lookup(strings.Split(test.path, "."))
+			if ok != test.wantOK {
+				t.Fatalf("expected found: %v, but got %v", test.wantOK, ok)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("unexpected element (-want +got):\n%v", diff)
+			}
+		},
+		)
+	}
+}
+
+const patientDefinition = `{
+  "resourceType": "StructureDefinition",
+  "type": "Patient",
+  "snapshot": {"element": [
+    {"path": "Patient", "min": 0, "max": "*"},
+    {"path": "Patient.gender", "min": 0, "max": "1", "type": [{"code": "code"}]},
+    {"path": "Patient.name", "min": 0, "max": "*", "type": [{"code": "HumanName"}]},
+    {"path": "Patient.contact", "min": 0, "max": "*", "type": [{"code": "BackboneElement"}]},
+    {"path": "Patient.contact.gender", "min": 0, "max": "1", "type": [{"code": "code"}]},
+    {"path": "Patient.deceased[x]", "min": 0, "max": "1", "type": [{"code": "boolean"}, {"code": "dateTime"}]}
+  ]}
+}`
+
+const humanNameBundle = `{
+  "resourceType": "Bundle",
+  "entry": [{"resource": {
+    "resourceType": "StructureDefinition",
+    "type": "HumanName",
+    "differential": {"element": [
+      {"path": "HumanName", "min": 0, "max": "*"},
+      {"path": "HumanName.family", "min": 0, "max": "1", "type": [{"code": "string"}]}
+    ]}
+  }}]
+}`
+
+func TestFHIRLookup(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		want   graph.SchemaElement
+		wantOK bool
+	}{
+		{
+			name:   "element",
+			path:   "Patient.gender",
+			want:   graph.SchemaElement{Path: "Patient.gender", Type: "code", Min: 0, Max: "1"},
+			wantOK: true,
+		},
+		{
+			name:   "element of another type",
+			path:   "Patient.name.family",
+			want:   graph.SchemaElement{Path: "Patient.name.family", Type: "string", Min: 0, Max: "1"},
+			wantOK: true,
+		},
+		{
+			name:   "backbone element",
+			path:   "Patient.contact.gender",
+			want:   graph.SchemaElement{Path: "Patient.contact.gender", Type: "code", Min: 0, Max: "1"},
+			wantOK: true,
+		},
+		{
+			name:   "choice of types",
+			path:   "Patient.deceasedDateTime",
+			want:   graph.SchemaElement{Path: "Patient.deceasedDateTime", Type: "dateTime", Min: 0, Max: "1"},
+			wantOK: true,
+		},
+		{
+			name:   "resource type",
+			path:   "Patient.resourceType",
+			want:   graph.SchemaElement{Path: "Patient.resourceType", Type: "code", Min: 1, Max: "1"},
+			wantOK: true,
+		},
+		{
+			name:   "type",
+			path:   "Patient",
+			want:   graph.SchemaElement{Path: "Patient", Type: "Patient", Min: 0, Max: "*"},
+			wantOK: true,
+		},
+		{
+			name:   "misspelt element",
+			path:   "Patient.gendr",
+			wantOK: false,
+		},
+		{
+			name:   "unknown type",
+			path:   "Observation.status",
+			wantOK: false,
+		},
+	}
+	s, err := ParseFHIR([]byte(patientDefinition), []byte(humanNameBundle))
+	if err != nil {
+		t.Fatalf("parsing the definitions failed:\n%v", err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := s.Lookup(strings.Split(test.path, "."))
+			if ok != test.wantOK {
+				t.Fatalf("expected found: %v, but got %v", test.wantOK, ok)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("unexpected element (-want +got):\n%v", diff)
+			}
+		},
+		)
+	}
+}
+
+const patientResource = `{
+  "resourceType": "StructureDefinition",
+  "type": "Patient",
+  "kind": "resource",
+  "snapshot": {"element": [
+    {"path": "Patient", "min": 0, "max": "*"},
+    {"path": "Patient.gender", "min": 0, "max": "1", "type": [{"code": "code"}]},
+    {"path": "Patient.birthDate", "min": 0, "max": "1", "type": [{"code": "date"}]}
+  ]}
+}`
+
+func TestLoadInput_FHIR(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fhir")
+	if err != nil {
+		t.Fatalf("failed to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{"patient.json": patientResource, "humanname.json": humanNameBundle} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %v: %v", name, err)
+		}
+	}
+	input, err := LoadInput(dir, "")
+	if err != nil {
+		t.Fatalf("LoadInput() returned an unexpected error: %v", err)
+	}
+	output, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load() returned an unexpected error: %v", err)
+	}
+
+	// Patient: MakePatient($root.gender)
+	// Patient.birthDate: $root.birthDat
+	// def MakePatient(g) {
+	//   gender: g
+	// }
+	makePatient := &mbp.ProjectorDefinition{
+		Name:    "MakePatient",
+		Mapping: []*mbp.FieldMapping{mappingtest.Mapping("gender", mappingtest.FromInput(""))},
+	}
+	mpc := &mbp.MappingConfig{
+		Projector: []*mbp.ProjectorDefinition{makePatient},
+		RootMapping: []*mbp.FieldMapping{
+			mappingtest.Mapping("Patient", mappingtest.Call("MakePatient", mappingtest.FromInput(".gender"))),
+			mappingtest.Mapping("Patient.birthDate", mappingtest.FromInput(".birthDat")),
+		},
+	}
+	g, err := graph.NewWithOptions(mpc, graph.Options{InputSchema: input, OutputSchema: output})
+	if err != nil {
+		t.Fatalf("graph.NewWithOptions() returned an unexpected error: %v", err)
+	}
+
+	got := []string{}
+	for _, node := range g.Nodes {
+		switch n := node.(type) {
+		case *graph.RootNode:
+			got = append(got, fmt.Sprintf("$root%v: %v", n.Field, n.Schema))
+		case *graph.TargetNode:
+			if !n.IsVariable {
+				got = append(got, fmt.Sprintf("%v: %v", n.Name, n.Schema))
+			}
+		}
+	}
+	for _, diagnostic := range g.Diagnostics {
+		got = append(got, diagnostic.Message)
+	}
+	sort.Strings(got)
+	want := []string{
+		"$root.birthDat:  [0..]",
+		"$root.gender: code [0..1]",
+		"Patient.birthDate: date [0..1]",
+		"Patient: Patient [0..*]",
+		"gender: code [0..1]",
+		"input field birthDat is not in the schema",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected schema elements and diagnostics (-want +got):\n%v", diff)
+	}
+}
+
+func TestLoadInput_Errors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fhir")
+	if err != nil {
+		t.Fatalf("failed to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "fhir"), 0755); err != nil {
+		t.Fatalf("failed to create a directory: %v", err)
+	}
+	observation := strings.Replace(patientResource, "Patient", "Observation", -1)
+	for name, content := range map[string]string{"fhir/patient.json": patientResource, "fhir/observation.json": observation, "schema.json": jsonSchema} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %v: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		resourceType string
+		wantErr      bool
+	}{
+		{
+			name:    "several resources",
+			path:    filepath.Join(dir, "fhir"),
+			wantErr: true,
+		},
+		{
+			name:         "chosen resource",
+			path:         filepath.Join(dir, "fhir"),
+			resourceType: "Observation",
+		},
+		{
+			name:         "undefined resource",
+			path:         filepath.Join(dir, "fhir"),
+			resourceType: "Encounter",
+			wantErr:      true,
+		},
+		{
+			name:         "resource type of a JSON Schema",
+			path:         filepath.Join(dir, "schema.json"),
+			resourceType: "Patient",
+			wantErr:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := LoadInput(test.path, test.resourceType)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("LoadInput() returned error %v, want an error: %v", err, test.wantErr)
+			}
+		},
+		)
+	}
+}
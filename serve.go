@@ -0,0 +1,56 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/loader"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/server"
+)
+
+// runServe serves the lineage of the mapping files or protobuf graphs given as arguments.
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8080", "Address to serve the lineage API on.")
+	pollInterval := flags.Duration("poll_interval", time.Second, "How often to check the whistle files for changes. Set to 0 to disable reloading.")
+	libDir := flags.String("lib_dir_spec", "", "Directory of whistle library files whose projectors the mappings can call. The mappings are reloaded when they change.")
+	pluginsSpec := flags.String("plugins", "", pluginsUsage)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v serve [flags] mapping.wstl|graph.pb...\n", flag.CommandLine.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no mapping files or protobuf graphs were given")
+	}
+	plugins, err := loader.ReadPlugins(*pluginsSpec)
+	if err != nil {
+		return err
+	}
+
+	s := server.New(flags.Args(), *libDir, graph.Options{Plugins: plugins})
+	if *pollInterval > 0 {
+		go s.Watch(*pollInterval, nil)
+	}
+	log.Printf("serving lineage on http://%v", *addr)
+	return http.ListenAndServe(*addr, s.Handler())
+}
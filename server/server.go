@@ -0,0 +1,393 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package server exposes lineage graphs through a JSON query API over HTTP.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/loader"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Server holds the lineage graphs loaded from a set of mapping files or saved protobuf graphs.
+type Server struct {
+	specs  []string
+	libDir string
+	opts   graph.Options
+	mu     sync.RWMutex
+	graphs map[string]graph.Graph
+	errors map[string]error
+}
+
+// New loads a graph for every file spec, building the graphs of mappings with the options and the
+// projectors of the whistle library files in libDir, and returns a server answering queries about
+// them. Files that fail to load are reported by the /graphs endpoint rather than failing the
+// server.
+func New(specs []string, libDir string, opts graph.Options) *Server {
+	s := &Server{
+		specs:  specs,
+		libDir: libDir,
+		opts:   opts,
+		graphs: map[string]graph.Graph{},
+		errors: map[string]error{},
+	}
+	s.Reload(specs)
+	return s
+}
+
+// Reload reloads the graphs of the given file specs, with the library projectors as they are now.
+// If a file fails to load, its previous graph is kept so that a mapping saved mid-edit doesn't take
+// the graph offline.
+func (s *Server) Reload(specs []string) {
+	libraries, libErr := loader.LibraryProjectors(s.libDir)
+	for _, spec := range specs {
+		var g graph.Graph
+		var err error
+		if libErr != nil && !loader.IsProtobuf(spec) { // saved graphs don't need the libraries
+			err = libErr
+		} else {
+			g, err = loader.Graph(spec, libraries, s.opts)
+		}
+		s.mu.Lock()
+		if err != nil {
+			log.Printf("failed to load %v:\n%v", spec, err)
+			s.errors[spec] = err
+		} else {
+			s.graphs[spec] = g
+			delete(s.errors, spec)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Watch reloads the whistle mappings whenever they change, and all of them whenever a library file
+// changes, until the stop channel is closed.
+func (s *Server) Watch(interval time.Duration, stop <-chan struct{}) {
+	whistleSpecs := []string{}
+	for _, spec := range s.specs {
+		if !loader.IsProtobuf(spec) {
+			whistleSpecs = append(whistleSpecs, spec)
+		}
+	}
+	libraryFiles := map[string]bool{}
+	lastErr := ""
+	watchedFiles := func() []string {
+		files, err := loader.LibraryFiles(s.libDir)
+		if err == nil {
+			lastErr = ""
+		} else if err.Error() != lastErr { // logged when it appears or changes, not on every poll
+			lastErr = err.Error()
+			log.Printf("%v", err)
+		}
+		for _, file := range files {
+			libraryFiles[file] = true
+		}
+		return append(files, whistleSpecs...)
+	}
+	w := loader.NewWatcher(interval, interval)
+	w.Watch(watchedFiles, func(changed []string) {
+		log.Printf("reloading %v", changed)
+		for _, file := range changed {
+			if libraryFiles[file] {
+				s.Reload(whistleSpecs)
+				return
+			}
+		}
+		s.Reload(changed)
+	}, stop)
+}
+
+// Handler returns the HTTP handler serving the query API:
+//   - /graphs lists the loaded graphs.
+//   - /outputs lists the output targets of a graph.
+//   - /upstream returns the upstream lineage of an output field or node, or only the calls to
+//     builtins of the semantics given as 'builtins=generating,side-effecting'.
+//   - /downstream returns the outputs impacted by an input field or node.
+//   - /derivations returns the input fields of an output field or node, and the concept maps
+//     translating them.
+//   - /node returns a single node, including its FileMetaData.
+//   - /subgraph renders the upstream or downstream lineage of a node as SVG or DOT.
+//
+// Queries take a 'graph' parameter naming the file spec, which may be omitted if only one graph is loaded.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphs", s.handleGraphs)
+	mux.HandleFunc("/outputs", s.withGraph(handleOutputs))
+	mux.HandleFunc("/upstream", s.withGraph(handleUpstream))
+	mux.HandleFunc("/downstream", s.withGraph(handleDownstream))
+	mux.HandleFunc("/derivations", s.withGraph(handleDerivations))
+	mux.HandleFunc("/node", s.withGraph(handleNode))
+	mux.HandleFunc("/subgraph", s.withGraph(handleSubgraph))
+	return mux
+}
+
+type graphInfo struct {
+	Name     string `json:"name"`
+	NumNodes int    `json:"numNodes"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (s *Server) handleGraphs(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	infos := []graphInfo{}
+	for _, spec := range s.specs {
+		info := graphInfo{Name: spec, NumNodes: len(s.graphs[spec].Nodes)}
+		if err, ok := s.errors[spec]; ok {
+			info.Error = err.Error()
+		}
+		infos = append(infos, info)
+	}
+	writeJSON(w, infos)
+}
+
+// withGraph looks up the graph named by the request and passes it to the handler.
+func (s *Server) withGraph(handler func(http.ResponseWriter, *http.Request, graph.Graph)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("graph")
+		s.mu.RLock()
+		if name == "" && len(s.specs) == 1 {
+			name = s.specs[0]
+		}
+		g, ok := s.graphs[name]
+		s.mu.RUnlock()
+		if !ok {
+			http.Error(w, fmt.Sprintf("graph %q is not loaded", name), http.StatusNotFound)
+			return
+		}
+		handler(w, r, g)
+	}
+}
+
+func handleOutputs(w http.ResponseWriter, r *http.Request, g graph.Graph) {
+	writeJSON(w, g.Outputs())
+}
+
+type lineageResponse struct {
+	Start   []int                   `json:"start"`
+	Outputs map[string][]int        `json:"outputs,omitempty"`
+	Nodes   map[int]json.RawMessage `json:"nodes"`
+}
+
+// handleUpstream returns the lineage of an output field, given as 'field=x.y', or of a node, given as 'id=3'.
+func handleUpstream(w http.ResponseWriter, r *http.Request, g graph.Graph) {
+	start, err := startNodes(r, g, g.FindTargets)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	withConditions := r.URL.Query().Get("conditions") == "true"
+	ids := []int{}
+	if builtins := r.URL.Query().Get("builtins"); builtins != "" {
+		semantics := []graph.BuiltinSemantics{}
+		for _, s := range strings.Split(builtins, ",") {
+			semantics = append(semantics, graph.BuiltinSemantics(s))
+		}
+		for _, id := range start {
+			ids = append(ids, g.BuiltinCalls(id, withConditions, semantics...)...)
+		}
+		writeLineage(w, g, start, ids, false)
+		return
+	}
+	for _, id := range start {
+		ids = append(ids, g.Upstream(id, withConditions)...)
+	}
+	writeLineage(w, g, start, ids, false)
+}
+
+// handleDownstream returns the outputs an input field, given as 'field=$root.x.y', or a node, given as 'id=3', reaches.
+func handleDownstream(w http.ResponseWriter, r *http.Request, g graph.Graph) {
+	start, err := startNodes(r, g, g.FindRootNodes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	withConditions := r.URL.Query().Get("conditions") == "true"
+	ids := []int{}
+	for _, id := range start {
+		ids = append(ids, g.Downstream(id, withConditions)...)
+	}
+	writeLineage(w, g, start, ids, true)
+}
+
+type derivation struct {
+	Input       int    `json:"input"`
+	ConceptMaps []int  `json:"conceptMaps,omitempty"`
+	Description string `json:"description"`
+}
+
+// handleDerivations returns the input fields an output field, given as 'field=x.y', or a node, given
+// as 'id=3', derives from, and the concept maps translating each of them on the way.
+func handleDerivations(w http.ResponseWriter, r *http.Request, g graph.Graph) {
+	start, err := startNodes(r, g, g.FindTargets)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := map[int][]derivation{}
+	for _, id := range start {
+		resp[id] = []derivation{}
+		for _, d := range g.Derivations(id) {
+			resp[id] = append(resp[id], derivation{Input: d.Input, ConceptMaps: d.ConceptMaps, Description: g.DescribeDerivation(d)})
+		}
+	}
+	writeJSON(w, resp)
+}
+
+func writeLineage(w http.ResponseWriter, g graph.Graph, start []int, ids []int, withOutputs bool) {
+	resp := lineageResponse{
+		Start: start,
+		Nodes: map[int]json.RawMessage{},
+	}
+	for _, id := range append(ids, start...) {
+		node, err := nodeJSON(g.Nodes[id])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.Nodes[id] = node
+	}
+	if withOutputs {
+		resp.Outputs = map[string][]int{}
+		for name, outputIDs := range g.Outputs() {
+			for _, id := range outputIDs {
+				if _, ok := resp.Nodes[id]; ok {
+					resp.Outputs[name] = append(resp.Outputs[name], id)
+				}
+			}
+		}
+	}
+	writeJSON(w, resp)
+}
+
+func handleNode(w http.ResponseWriter, r *http.Request, g graph.Graph) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "the 'id' parameter must be a node ID", http.StatusBadRequest)
+		return
+	}
+	node, ok := g.Nodes[id]
+	if !ok {
+		http.Error(w, fmt.Sprintf("node %v is not in the graph", id), http.StatusNotFound)
+		return
+	}
+	out, err := nodeJSON(node)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// handleSubgraph renders the lineage of a node, given as 'id=3'. The 'direction' parameter selects
+// 'upstream' (the default) or 'downstream' lineage and 'format' selects 'svg' (the default) or 'dot'.
+func handleSubgraph(w http.ResponseWriter, r *http.Request, g graph.Graph) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "the 'id' parameter must be a node ID", http.StatusBadRequest)
+		return
+	}
+	if _, ok := g.Nodes[id]; !ok {
+		http.Error(w, fmt.Sprintf("node %v is not in the graph", id), http.StatusNotFound)
+		return
+	}
+	withConditions := r.URL.Query().Get("conditions") == "true"
+	var ids []int
+	switch r.URL.Query().Get("direction") {
+	case "", "upstream":
+		ids = g.Upstream(id, withConditions)
+	case "downstream":
+		ids = g.Downstream(id, withConditions)
+	default:
+		http.Error(w, "the 'direction' parameter must be 'upstream' or 'downstream'", http.StatusBadRequest)
+		return
+	}
+	sub := g.Subgraph(append(ids, id))
+
+	switch r.URL.Query().Get("format") {
+	case "", "svg":
+		svg, err := graph.WriteSVG(sub)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(svg)
+	case "dot":
+		dotString, err := graph.WriteDOTpng(sub, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		w.Write([]byte(dotString))
+	default:
+		http.Error(w, "the 'format' parameter must be 'svg' or 'dot'", http.StatusBadRequest)
+	}
+}
+
+// startNodes reads the nodes a query starts from, either from the 'id' parameter or by looking up the 'field' parameter.
+func startNodes(r *http.Request, g graph.Graph, findField func(string) []int) ([]int, error) {
+	query := r.URL.Query()
+	if idString := query.Get("id"); idString != "" {
+		id, err := strconv.Atoi(idString)
+		if err != nil {
+			return nil, fmt.Errorf("the 'id' parameter must be a node ID")
+		}
+		if _, ok := g.Nodes[id]; !ok {
+			return nil, fmt.Errorf("node %v is not in the graph", id)
+		}
+		return []int{id}, nil
+	}
+	field := query.Get("field")
+	if field == "" {
+		return nil, fmt.Errorf("either the 'id' or the 'field' parameter must be set")
+	}
+	ids := findField(field)
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("field %q is not in the graph", field)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func nodeJSON(node graph.Node) (json.RawMessage, error) {
+	pbNode, err := graph.NodeProtobuf(node)
+	if err != nil {
+		return nil, err
+	}
+	return protojson.Marshal(pbNode)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
@@ -0,0 +1,297 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	mbp "github.com/GoogleCloudPlatform/healthcare-data-harmonization/mapping_engine/proto"
+	"github.com/google/go-cmp/cmp"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/graph"
+	"github.com/googleinterns/healthcare-data-harmonization-lineage/internal/mappingtest"
+	"google.golang.org/protobuf/proto"
+)
+
+// writeGraph saves the graph of a mapping as a protobuf file in dir and returns its path.
+func writeGraph(t *testing.T, dir string, name string, mappings ...*mbp.FieldMapping) string {
+	t.Helper()
+	g, err := graph.New(&mbp.MappingConfig{RootMapping: mappings})
+	if err != nil {
+		t.Fatalf("graph.New() returned an unexpected error: %v", err)
+	}
+	pbGraph, err := graph.WriteProtobuf(g)
+	if err != nil {
+		t.Fatalf("graph.WriteProtobuf() returned an unexpected error: %v", err)
+	}
+	out, err := proto.Marshal(pbGraph)
+	if err != nil {
+		t.Fatalf("proto.Marshal() returned an unexpected error: %v", err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, out, 0644); err != nil {
+		t.Fatalf("failed to write %v: %v", path, err)
+	}
+	return path
+}
+
+// tempDir returns a directory removed at the end of the test.
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatalf("failed to create a temporary directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func get(handler http.Handler, url string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+	return rec
+}
+
+// nodeNames describes the nodes of a lineage response by their kind and name or field, sorted.
+func nodeNames(t *testing.T, nodes map[int]json.RawMessage) []string {
+	t.Helper()
+	names := []string{}
+	for _, node := range nodes {
+		var kinds map[string]map[string]interface{}
+		if err := json.Unmarshal(node, &kinds); err != nil {
+			t.Fatalf("failed to decode node %s: %v", node, err)
+		}
+		for kind, fields := range kinds {
+			name := fields["name"]
+			if name == nil {
+				name = fields["field"]
+			}
+			names = append(names, fmt.Sprintf("%v %v", kind, name))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestHandler(t *testing.T) {
+	dir := tempDir(t)
+	spec := writeGraph(t, dir, "mapping.pb",
+		mappingtest.Mapping("x", mappingtest.FromInput(".a")),
+		mappingtest.Mapping("y", mappingtest.Call("$StrCat", mappingtest.FromInput(".a"), mappingtest.FromInput(".b"))),
+	)
+	handler := New([]string{spec}, "", graph.Options{}).Handler()
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantStart  int // the number of start nodes of a lineage response
+		wantNodes  []string
+		wantError  string
+	}{
+		{
+			name:       "upstream of a field",
+			url:        "/upstream?field=y",
+			wantStatus: http.StatusOK,
+			wantStart:  1,
+			wantNodes:  []string{"projectorNode $StrCat", "rootNode .a", "rootNode .b", "targetNode y"},
+		},
+		{
+			name:       "downstream of an input field",
+			url:        "/downstream?field=$root.a",
+			wantStatus: http.StatusOK,
+			wantStart:  2, // each read of $root.a is a node of its own
+			wantNodes:  []string{"projectorNode $StrCat", "rootNode .a", "rootNode .a", "targetNode x", "targetNode y"},
+		},
+		{
+			name:       "explicit graph",
+			url:        "/upstream?field=x&graph=" + spec,
+			wantStatus: http.StatusOK,
+			wantStart:  1,
+			wantNodes:  []string{"rootNode .a", "targetNode x"},
+		},
+		{
+			name:       "unknown graph",
+			url:        "/upstream?field=x&graph=other.pb",
+			wantStatus: http.StatusNotFound,
+			wantError:  `graph "other.pb" is not loaded`,
+		},
+		{
+			name:       "no start node",
+			url:        "/upstream",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "either the 'id' or the 'field' parameter must be set",
+		},
+		{
+			name:       "unknown field",
+			url:        "/upstream?field=z",
+			wantStatus: http.StatusBadRequest,
+			wantError:  `field "z" is not in the graph`,
+		},
+		{
+			name:       "invalid id",
+			url:        "/downstream?id=a",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "the 'id' parameter must be a node ID",
+		},
+		{
+			name:       "unknown id",
+			url:        "/downstream?id=1000",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "node 1000 is not in the graph",
+		},
+		{
+			name:       "unknown node",
+			url:        "/node?id=1000",
+			wantStatus: http.StatusNotFound,
+			wantError:  "node 1000 is not in the graph",
+		},
+		{
+			name:       "invalid subgraph direction",
+			url:        "/subgraph?id=0&direction=sideways",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "the 'direction' parameter must be 'upstream' or 'downstream'",
+		},
+		{
+			name:       "invalid subgraph format",
+			url:        "/subgraph?id=0&format=png",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "the 'format' parameter must be 'svg' or 'dot'",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := get(handler, test.url)
+			if rec.Code != test.wantStatus {
+				t.Fatalf("GET %v returned status %v, want %v: %v", test.url, rec.Code, test.wantStatus, rec.Body)
+			}
+			if test.wantError != "" {
+				if diff := cmp.Diff(test.wantError, strings.TrimSpace(rec.Body.String())); diff != "" {
+					t.Errorf("GET %v returned an unexpected error (-want +got):\n%v", test.url, diff)
+				}
+				return
+			}
+			var resp lineageResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode the response %v: %v", rec.Body, err)
+			}
+			if len(resp.Start) != test.wantStart {
+				t.Errorf("GET %v returned %v start nodes, want %v", test.url, len(resp.Start), test.wantStart)
+			}
+			if diff := cmp.Diff(test.wantNodes, nodeNames(t, resp.Nodes)); diff != "" {
+				t.Errorf("GET %v returned unexpected nodes (-want +got):\n%v", test.url, diff)
+			}
+		},
+		)
+	}
+}
+
+func TestHandler_Outputs(t *testing.T) {
+	spec := writeGraph(t, tempDir(t), "mapping.pb",
+		mappingtest.Mapping("x", mappingtest.FromInput(".a")),
+		mappingtest.Mapping("y", mappingtest.FromInput(".b")),
+	)
+	rec := get(New([]string{spec}, "", graph.Options{}).Handler(), "/outputs")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /outputs returned status %v: %v", rec.Code, rec.Body)
+	}
+	var outputs map[string][]int
+	if err := json.Unmarshal(rec.Body.Bytes(), &outputs); err != nil {
+		t.Fatalf("failed to decode the response %v: %v", rec.Body, err)
+	}
+	names := []string{}
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if diff := cmp.Diff([]string{"x", "y"}, names); diff != "" {
+		t.Errorf("GET /outputs returned unexpected outputs (-want +got):\n%v", diff)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := tempDir(t)
+	spec := writeGraph(t, dir, "mapping.pb", mappingtest.Mapping("x", mappingtest.FromInput(".a")))
+	missing := filepath.Join(dir, "missing.pb")
+	s := New([]string{spec, missing}, "", graph.Options{})
+
+	if err := ioutil.WriteFile(spec, []byte("not a graph"), 0644); err != nil {
+		t.Fatalf("failed to overwrite %v: %v", spec, err)
+	}
+	s.Reload([]string{spec})
+
+	rec := get(s.Handler(), "/graphs")
+	var infos []graphInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &infos); err != nil {
+		t.Fatalf("failed to decode the response %v: %v", rec.Body, err)
+	}
+	got := []string{}
+	for _, info := range infos {
+		got = append(got, fmt.Sprintf("%v loaded=%v failed=%v", filepath.Base(info.Name), info.NumNodes > 0, info.Error != ""))
+	}
+	want := []string{
+		"mapping.pb loaded=true failed=true", // the previous graph is kept
+		"missing.pb loaded=false failed=true",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("GET /graphs returned unexpected graphs (-want +got):\n%v", diff)
+	}
+
+	rec = get(s.Handler(), "/upstream?field=x&graph="+spec)
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /upstream on the previous graph returned status %v: %v", rec.Code, rec.Body)
+	}
+}
+
+func TestReload_Libraries(t *testing.T) {
+	dir := tempDir(t)
+	libDir := filepath.Join(dir, "libs")
+	if err := os.Mkdir(libDir, 0755); err != nil {
+		t.Fatalf("failed to create %v: %v", libDir, err)
+	}
+	files := map[string]string{
+		filepath.Join(libDir, "copy.wstl"): "def Copy(v) {\n  y: v\n}\n",
+		filepath.Join(dir, "mapping.wstl"): "x: Copy($root.a)\n",
+	}
+	for path, whistle := range files {
+		if err := ioutil.WriteFile(path, []byte(whistle), 0644); err != nil {
+			t.Fatalf("failed to write %v: %v", path, err)
+		}
+	}
+	spec := filepath.Join(dir, "mapping.wstl")
+	s := New([]string{spec}, libDir, graph.Options{})
+
+	rec := get(s.Handler(), "/graphs")
+	var infos []graphInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &infos); err != nil {
+		t.Fatalf("failed to decode the response %v: %v", rec.Body, err)
+	}
+	if len(infos) != 1 || infos[0].Error != "" || infos[0].NumNodes == 0 {
+		t.Errorf("GET /graphs returned %+v, want the mapping loaded with the library projector", infos)
+	}
+	rec = get(s.Handler(), "/upstream?field=x")
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /upstream returned status %v: %v", rec.Code, rec.Body)
+	}
+}
+
+func TestReload_ProtobufWithoutLibraries(t *testing.T) {
+	dir := tempDir(t)
+	spec := writeGraph(t, dir, "mapping.pb", mappingtest.Mapping("x", mappingtest.FromInput(".a")))
+	s := New([]string{spec}, filepath.Join(dir, "missing"), graph.Options{})
+
+	rec := get(s.Handler(), "/graphs")
+	var infos []graphInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &infos); err != nil {
+		t.Fatalf("failed to decode the response %v: %v", rec.Body, err)
+	}
+	if len(infos) != 1 || infos[0].Error != "" || infos[0].NumNodes == 0 {
+		t.Errorf("GET /graphs returned %+v, want the saved graph loaded despite the missing library directory", infos)
+	}
+}